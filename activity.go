@@ -0,0 +1,118 @@
+package main
+
+import "math/bits"
+
+// The mobility tables give the value of a piece by the number of safe squares it attacks: those not occupied by its
+// own side's pieces nor attacked by enemy pawns.
+var (
+	knightMobility = [...]Score{
+		{-30, -40}, {-15, -25}, {-5, -10}, {0, 0}, {5, 5}, {10, 10}, {15, 15}, {20, 18}, {25, 20},
+	}
+	bishopMobility = [...]Score{
+		{-25, -35}, {-12, -20}, {0, -5}, {6, 3}, {12, 10}, {18, 16}, {23, 21}, {27, 26}, {31, 30}, {34, 33},
+		{37, 36}, {40, 38}, {42, 40}, {44, 42},
+	}
+	rookMobility = [...]Score{
+		{-20, -40}, {-12, -20}, {-6, -8}, {-3, 0}, {0, 8}, {3, 15}, {6, 22}, {9, 28}, {12, 34}, {14, 40},
+		{16, 45}, {18, 50}, {20, 54}, {22, 57}, {24, 60},
+	}
+	queenMobility = [...]Score{
+		{-12, -24}, {-10, -21}, {-9, -19}, {-7, -16}, {-6, -14}, {-4, -11}, {-2, -8}, {-1, -6}, {1, -3}, {2, -1},
+		{4, 2}, {6, 5}, {7, 7}, {9, 10}, {10, 12}, {12, 15}, {14, 18}, {15, 20}, {17, 23}, {18, 25},
+		{19, 26}, {19, 27}, {20, 28}, {20, 29}, {21, 30}, {21, 31}, {22, 32}, {22, 33},
+	}
+)
+
+// The piece activity terms, for the middlegame and endgame.
+var (
+	// An outpost is a square on the fourth to sixth ranks, defended by a pawn, which no enemy pawn can attack.
+	knightOutpost = Score{30, 20}
+	bishopOutpost = Score{15, 10}
+	bishopPair    = Score{30, 50}
+	rookOpenFile  = Score{40, 20}
+	// rookSemiOpenFile is for a rook on a file without pawns of its own side but with enemy ones.
+	rookSemiOpenFile = Score{20, 10}
+	// rookOnSeventh is for a rook on the seventh rank which holds enemy pawns or cuts off the enemy king.
+	rookOnSeventh = Score{20, 40}
+	// trappedBishop is for a bishop on a7 or h7 which a pawn on b6 or g6 shuts in.
+	trappedBishop = Score{-100, -120}
+	// boxedRook is for a rook with few moves shut in on the first rank by its king, which has left the e-file and so
+	// can no longer castle to free it.
+	boxedRook = Score{-50, 0}
+)
+
+// evaluateActivity evaluates side's pieces by their mobility, and by their placement: outposts, the bishop pair, rooks
+// on open files and the seventh rank, and pieces trapped. The scores are from side's perspective.
+func evaluateActivity(w *EvalParams, p *Position, info *attackInfo, ranks *[2][8]uint8, side Side) (mobility, placement Score) {
+	opp := side.OppSide()
+	safe := ^(info.occupied[side] | info.byPiece[opp][Pawn])
+	kf, kr := info.king[side][0], info.king[side][1]
+	bishops := 0
+	for i := 0; i < info.count[side]; i++ {
+		piece := &info.pieces[side][i]
+		f, r := piece.f, piece.r
+		rank := relativeRank(side, r)
+		moves := bits.OnesCount64(piece.attacks & safe)
+		outpost := rank >= 3 && rank <= 5 && info.byPiece[side][Pawn]&squareBit(f, r) != 0 &&
+			adjacentPawns(ranks, opp, f)&ranksAhead(side, r) == 0
+
+		switch piece.piece {
+		case Knight:
+			mobility = mobility.Add(w.KnightMobility[moves])
+			if outpost {
+				placement = placement.Add(w.KnightOutpost)
+			}
+		case Bishop:
+			bishops++
+			mobility = mobility.Add(w.BishopMobility[moves])
+			if outpost {
+				placement = placement.Add(w.BishopOutpost)
+			}
+			if rank == 6 && (f == 0 || f == 7) {
+				// The pawn which shuts it in stands a file nearer the center and a rank ahead of it.
+				pf, pr := 1, r-1
+				if f == 7 {
+					pf = 6
+				}
+				if side == Black {
+					pr = r + 1
+				}
+				if p.board[pr][pf] == (GamePiece{Pawn, opp}) {
+					placement = placement.Add(w.TrappedBishop)
+				}
+			}
+		case Rook:
+			mobility = mobility.Add(w.RookMobility[moves])
+			switch {
+			case ranks[side][f] == 0 && ranks[opp][f] == 0:
+				placement = placement.Add(w.RookOpenFile)
+			case ranks[side][f] == 0:
+				placement = placement.Add(w.RookSemiOpenFile)
+			}
+			seventh := relativeRank(side, 6)
+			if rank == 6 && (relativeRank(side, info.king[opp][1]) == 7 || pawnsOnRank(ranks, opp, seventh)) {
+				placement = placement.Add(w.RookOnSeventh)
+			}
+			if rank == 0 && relativeRank(side, kr) == 0 && moves <= 3 &&
+				(kf >= 5 && f > kf || kf <= 3 && kf > 0 && f < kf) {
+				placement = placement.Add(w.BoxedRook)
+			}
+		case Queen:
+			mobility = mobility.Add(w.QueenMobility[moves])
+		}
+	}
+	if bishops >= 2 {
+		placement = placement.Add(w.BishopPair)
+	}
+	return mobility, placement
+}
+
+// pawnsOnRank reports whether side has pawns on rank r.
+func pawnsOnRank(ranks *[2][8]uint8, side Side, r int) bool {
+	for f := range ranks[side] {
+		if ranks[side][f]&(1<<uint(r)) != 0 {
+			return true
+		}
+	}
+	return false
+}
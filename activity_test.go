@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+// activityTest is a position whose side to move should get the mobility or placement score want.
+type activityTest struct {
+	name, fen string
+	want      Score
+}
+
+// evaluateActivityFEN returns evaluateActivity's scores for the side to move in fen, with the default weights.
+func evaluateActivityFEN(t *testing.T, fen string) (mobility, placement Score) {
+	t.Helper()
+	p, side, err := ParseFEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranks := pawnRanks(p)
+	return evaluateActivity(DefaultEvalParams(), p, newAttackInfo(p), &ranks, side)
+}
+
+// testPlacement checks the placement score of each of tests.
+func testPlacement(t *testing.T, tests []activityTest) {
+	for _, test := range tests {
+		if _, placement := evaluateActivityFEN(t, test.fen); placement != test.want {
+			t.Errorf("%s, %s: placement %v, want %v", test.name, test.fen, placement, test.want)
+		}
+	}
+}
+
+func TestMobility(t *testing.T) {
+	tests := []activityTest{
+		{"knight in the corner", "7k/8/8/8/8/8/8/N6K w - - 0 1", knightMobility[2]},
+		{"knight beside its own pawn", "7k/8/4P3/8/3N4/8/8/7K w - - 0 1", knightMobility[7]},
+		{"knight facing enemy pawn attacks", "7k/3p4/8/8/3N4/8/8/7K w - - 0 1", knightMobility[6]},
+		{"black knight", "7k/8/8/8/3nP3/8/8/7K b - - 0 1", knightMobility[7]},
+		{"rook stopped by its king", "7k/8/8/8/8/8/8/R6K w - - 0 1", rookMobility[13]},
+		{"bishop attacking an enemy piece", "7k/6n1/8/8/8/8/8/B6K w - - 0 1", bishopMobility[6]},
+		{"queen", "8/7k/8/8/8/8/8/Q6K w - - 0 1", queenMobility[20]},
+		{"pieces add up", "7k/8/8/8/8/8/8/NB5K w - - 0 1", knightMobility[2].Add(bishopMobility[7])},
+	}
+	for _, test := range tests {
+		if mobility, _ := evaluateActivityFEN(t, test.fen); mobility != test.want {
+			t.Errorf("%s, %s: mobility %v, want %v", test.name, test.fen, mobility, test.want)
+		}
+	}
+}
+
+func TestOutposts(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"knight outpost", "7k/8/8/3N4/4P3/8/8/7K w - - 0 1", knightOutpost},
+		{"bishop outpost", "7k/8/8/3B4/4P3/8/8/7K w - - 0 1", bishopOutpost},
+		{"black knight outpost", "7k/8/8/4p3/3n4/8/8/7K b - - 0 1", knightOutpost},
+		{"undefended", "7k/8/8/3N4/8/8/8/7K w - - 0 1", Score{}},
+		{"an enemy pawn can attack it", "7k/2p5/8/3N4/4P3/8/8/7K w - - 0 1", Score{}},
+		{"an enemy pawn has passed it", "7k/8/8/3N4/2p1P3/8/8/7K w - - 0 1", knightOutpost},
+		{"too far back", "7k/8/8/8/8/3N4/4P3/7K w - - 0 1", Score{}},
+	})
+}
+
+func TestBishopPair(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"pair", "7k/8/8/8/8/8/8/2B2B1K w - - 0 1", bishopPair},
+		{"black pair", "2b2b1k/8/8/8/8/8/8/7K b - - 0 1", bishopPair},
+		{"one bishop", "7k/8/8/8/8/8/8/2B4K w - - 0 1", Score{}},
+		{"bishop and knight", "7k/8/8/8/8/8/8/2B2N1K w - - 0 1", Score{}},
+	})
+}
+
+func TestRookFiles(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"open file", "7k/8/8/8/8/8/8/3R3K w - - 0 1", rookOpenFile},
+		{"semi-open file", "7k/3p4/8/8/8/8/8/3R3K w - - 0 1", rookSemiOpenFile},
+		{"behind its own pawn", "7k/8/8/8/8/8/3P4/3R3K w - - 0 1", Score{}},
+		{"black rook on a semi-open file", "3r3k/8/8/8/8/8/3P4/7K b - - 0 1", rookSemiOpenFile},
+	})
+}
+
+func TestRookOnSeventh(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"enemy pawns on the seventh", "7k/Rp6/8/8/8/8/8/7K w - - 0 1", rookOpenFile.Add(rookOnSeventh)},
+		{"enemy king cut off", "7k/R7/8/8/8/8/8/7K w - - 0 1", rookOpenFile.Add(rookOnSeventh)},
+		{"nothing to attack", "8/R7/7k/8/8/8/8/7K w - - 0 1", rookOpenFile},
+		{"black rook on the second", "k7/8/8/8/8/8/r6P/7K b - - 0 1", rookOpenFile.Add(rookOnSeventh)},
+		{"sixth rank", "7k/1p6/R7/8/8/8/8/7K w - - 0 1", rookOpenFile},
+	})
+}
+
+func TestTrappedBishop(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"a7 shut in by b6", "7k/B7/1p6/8/8/8/8/7K w - - 0 1", trappedBishop},
+		{"h7 shut in by g6", "k7/7B/6p1/8/8/8/8/K7 w - - 0 1", trappedBishop},
+		{"black bishop on h2 shut in by g3", "k7/8/8/8/8/6P1/7b/K7 b - - 0 1", trappedBishop},
+		{"its own pawn on b6", "7k/B7/1P6/8/8/8/8/7K w - - 0 1", Score{}},
+		{"enemy pawn on b5", "7k/B7/8/1p6/8/8/8/7K w - - 0 1", Score{}},
+	})
+}
+
+func TestBoxedRook(t *testing.T) {
+	testPlacement(t, []activityTest{
+		{"kingside", "7k/8/8/8/8/8/5PPP/5K1R w - - 0 1", boxedRook},
+		{"queenside", "7k/8/8/8/8/8/PP6/RK6 w - - 0 1", boxedRook},
+		{"black kingside", "6kr/5ppp/8/8/8/8/8/7K b - - 0 1", boxedRook},
+		{"king still able to castle", "7k/8/8/8/8/8/5PPP/4K2R w K - 0 1", Score{}},
+		{"rook with moves", "k7/8/8/8/8/8/5PP1/5K1R w - - 0 1", rookOpenFile},
+		{"rook beyond the king", "7k/8/8/8/8/8/5PPP/4RK2 w - - 0 1", rookOpenFile},
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+// The directions pieces move in, as file and rank steps.
+var (
+	knightSteps     = [...][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingSteps       = [...][2]int{{0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}, {-1, -1}, {-1, 0}, {-1, 1}}
+	bishopDirection = [...][2]int{{1, 1}, {1, -1}, {-1, -1}, {-1, 1}}
+	rookDirection   = [...][2]int{{0, 1}, {1, 0}, {0, -1}, {-1, 0}}
+)
+
+// squareBit returns the bit of the square at file f and rank r in a set of squares, which are numbered rank*8+file.
+func squareBit(f, r int) uint64 {
+	return 1 << uint(r*8+f)
+}
+
+// onBoard reports whether file f and rank r are on the board.
+func onBoard(f, r int) bool {
+	return f >= 0 && f < 8 && r >= 0 && r < 8
+}
+
+// stepAttacks returns the squares one step from (f, r) in each of the directions.
+func stepAttacks(f, r int, steps [][2]int) uint64 {
+	var attacks uint64
+	for _, step := range steps {
+		if tf, tr := f+step[0], r+step[1]; onBoard(tf, tr) {
+			attacks |= squareBit(tf, tr)
+		}
+	}
+	return attacks
+}
+
+// slideAttacks returns the squares reached from (f, r) in each of the directions, up to and including the first
+// occupied square.
+func slideAttacks(p *Position, f, r int, directions [][2]int) uint64 {
+	var attacks uint64
+	for _, dir := range directions {
+		for tf, tr := f+dir[0], r+dir[1]; onBoard(tf, tr); tf, tr = tf+dir[0], tr+dir[1] {
+			attacks |= squareBit(tf, tr)
+			if p.board[tr][tf].piece != None {
+				break
+			}
+		}
+	}
+	return attacks
+}
+
+// pieceAttacks returns the squares a piece of side on (f, r) attacks.
+func pieceAttacks(p *Position, piece Piece, side Side, f, r int) uint64 {
+	switch piece {
+	case Pawn:
+		forward := 1
+		if side == Black {
+			forward = -1
+		}
+		return stepAttacks(f, r, [][2]int{{-1, forward}, {1, forward}})
+	case Knight:
+		return stepAttacks(f, r, knightSteps[:])
+	case Bishop:
+		return slideAttacks(p, f, r, bishopDirection[:])
+	case Rook:
+		return slideAttacks(p, f, r, rookDirection[:])
+	case Queen:
+		return slideAttacks(p, f, r, bishopDirection[:]) | slideAttacks(p, f, r, rookDirection[:])
+	case King:
+		return stepAttacks(f, r, kingSteps[:])
+	}
+	return 0
+}
+
+// pieceAttack is a piece on the board with the squares it attacks.
+type pieceAttack struct {
+	piece   Piece
+	f, r    int
+	attacks uint64
+}
+
+// attackInfo holds what each side's pieces attack, worked out once for the evaluation terms which need it.
+type attackInfo struct {
+	occupied [2]uint64
+	// byPiece is the squares attacked by each side's pieces of each kind, and all those by any of them.
+	byPiece [2][King + 1]uint64
+	all     [2]uint64
+	// pieces is each side's pieces other than pawns and the king, of which there are at most 15.
+	pieces [2][15]pieceAttack
+	count  [2]int
+	king   [2][2]int
+}
+
+// newAttackInfo works out the attacks of every piece on the board.
+func newAttackInfo(p *Position) *attackInfo {
+	info := &attackInfo{}
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			if piece.piece == None {
+				continue
+			}
+			side := piece.color
+			info.occupied[side] |= squareBit(f, r)
+			attacks := pieceAttacks(p, piece.piece, side, f, r)
+			info.byPiece[side][piece.piece] |= attacks
+			info.all[side] |= attacks
+			switch piece.piece {
+			case Pawn:
+			case King:
+				info.king[side] = [2]int{f, r}
+			default:
+				if info.count[side] < len(info.pieces[side]) {
+					info.pieces[side][info.count[side]] = pieceAttack{piece.piece, f, r, attacks}
+					info.count[side]++
+				}
+			}
+		}
+	}
+	return info
+}
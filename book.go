@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// polyglotEntrySize is the size in bytes of an entry of a Polyglot book.
+const polyglotEntrySize = 16
+
+// polyglotPieces maps each Piece to its index among the Polyglot piece kinds, which run pawn, knight, bishop, rook,
+// queen, king. The kind of a piece is twice its index, plus one for White.
+var polyglotPieces = [...]int{Pawn: 0, Rook: 3, Knight: 1, Bishop: 2, Queen: 4, King: 5}
+
+// polyglotPromotions holds the promotion pieces in the order of Polyglot's move encoding, which uses 0 for none.
+var polyglotPromotions = [...]string{"", "n", "b", "r", "q"}
+
+// PolyglotKey returns the hash of the position with side to move which Polyglot books are indexed by.
+func PolyglotKey(p *Position, side Side) uint64 {
+	var key uint64
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			if piece.piece == None {
+				continue
+			}
+			kind := 2 * polyglotPieces[piece.piece]
+			if piece.color == White {
+				kind++
+			}
+			key ^= polyglotRandom[64*kind+8*r+f]
+		}
+	}
+	for i, castle := range [...]bool{p.canCastleShortWhite, p.canCastleLongWhite, p.canCastleShortBlack,
+		p.canCastleLongBlack} {
+		if castle {
+			key ^= polyglotRandom[768+i]
+		}
+	}
+	// The en passant file only counts when a pawn of the side to move stands ready to capture.
+	if p.enPassant != noSquare {
+		rank := p.enPassant.rank - 1
+		if side == Black {
+			rank = p.enPassant.rank + 1
+		}
+		for _, f := range [...]int{p.enPassant.file - 1, p.enPassant.file + 1} {
+			if f >= 0 && f < 8 && p.board[rank][f] == (GamePiece{Pawn, side}) {
+				key ^= polyglotRandom[772+p.enPassant.file]
+				break
+			}
+		}
+	}
+	if side == White {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+// polyglotEntry is an entry of a Polyglot book: a move from the position with the key, weighted by how good it is.
+type polyglotEntry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+// encodePolyglotMove encodes a move the way Polyglot books do. Castling is written as the king capturing its rook.
+func encodePolyglotMove(p *Position, move Move) uint16 {
+	nFile := move.nFile
+	if p.board[move.oRank][move.oFile].piece == King && move.oFile == 4 {
+		switch move.nFile - move.oFile {
+		case 2:
+			nFile = 7
+		case -2:
+			nFile = 0
+		}
+	}
+	promo := 0
+	for i, piece := range polyglotPromotions {
+		if piece != "" && piece == move.promoPiece {
+			promo = i
+		}
+	}
+	return uint16(nFile | move.nRank<<3 | move.oFile<<6 | move.oRank<<9 | promo<<12)
+}
+
+// decodePolyglotMove finds side's legal move encoded as a Polyglot move.
+func decodePolyglotMove(p *Position, side Side, encoded uint16) (Move, bool) {
+	for _, move := range p.GetMoves(side) {
+		if encodePolyglotMove(p, move) == encoded {
+			return move, true
+		}
+	}
+	return Move{}, false
+}
+
+// BookMove is a move from an opening book along with its weight.
+type BookMove struct {
+	Move   Move
+	Weight int
+}
+
+// OpeningBook chooses moves from a Polyglot opening book. It's safe for concurrent use.
+type OpeningBook struct {
+	entries []polyglotEntry
+	// MaxPly stops the book being used once that many plies have been played in the game. Zero means no limit.
+	MaxPly int
+	// Best picks the book's highest weighted move rather than a random move chosen in proportion to the weights.
+	Best bool
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// LoadBook reads a Polyglot .bin book.
+func LoadBook(path string) (*OpeningBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%polyglotEntrySize != 0 {
+		return nil, fmt.Errorf("%s: not a Polyglot book: size %d isn't a multiple of %d", path, len(data),
+			polyglotEntrySize)
+	}
+	b := &OpeningBook{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for i := 0; i < len(data); i += polyglotEntrySize {
+		b.entries = append(b.entries, polyglotEntry{
+			Key:    binary.BigEndian.Uint64(data[i:]),
+			Move:   binary.BigEndian.Uint16(data[i+8:]),
+			Weight: binary.BigEndian.Uint16(data[i+10:]),
+			Learn:  binary.BigEndian.Uint32(data[i+12:]),
+		})
+	}
+	if !sort.SliceIsSorted(b.entries, func(i, j int) bool { return b.entries[i].Key < b.entries[j].Key }) {
+		return nil, fmt.Errorf("%s: not a Polyglot book: entries aren't sorted by key", path)
+	}
+	return b, nil
+}
+
+// Moves returns the book's legal moves for side in the position, in the book's order, which is usually by
+// decreasing weight.
+func (b *OpeningBook) Moves(p *Position, side Side) []BookMove {
+	key := PolyglotKey(p, side)
+	var moves []BookMove
+	for i := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].Key >= key }); i < len(b.entries) &&
+		b.entries[i].Key == key; i++ {
+		if move, ok := decodePolyglotMove(p, side, b.entries[i].Move); ok {
+			moves = append(moves, BookMove{move, int(b.entries[i].Weight)})
+		}
+	}
+	return moves
+}
+
+// Probe chooses a move for side from the book, if the position is in it and within the book's depth. Moves of zero
+// weight are never chosen.
+func (b *OpeningBook) Probe(p *Position, side Side) (Move, bool) {
+	ply := 2 * (p.fullMoveNumber - 1)
+	if side == Black {
+		ply++
+	}
+	if b.MaxPly > 0 && ply >= b.MaxPly {
+		return Move{}, false
+	}
+
+	moves := b.Moves(p, side)
+	total := 0
+	best := -1
+	for i, move := range moves {
+		total += move.Weight
+		if move.Weight > 0 && (best < 0 || move.Weight > moves[best].Weight) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Move{}, false
+	}
+	if b.Best {
+		return moves[best].Move, true
+	}
+
+	b.mu.Lock()
+	pick := b.rng.Intn(total)
+	b.mu.Unlock()
+	for _, move := range moves {
+		if pick < move.Weight {
+			return move.Move, true
+		}
+		pick -= move.Weight
+	}
+	return moves[best].Move, true
+}
+
+// probe is Probe for a book which may be nil.
+func (b *OpeningBook) probe(p *Position, side Side) (Move, bool) {
+	if b == nil {
+		return Move{}, false
+	}
+	return b.Probe(p, side)
+}
+
+// BookPlayer plays from an opening book while it can, leaving the rest of the game to Player.
+type BookPlayer struct {
+	Player
+	Book *OpeningBook
+}
+
+// Think plays a book move without thinking when the book has one.
+func (b *BookPlayer) Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error) {
+	if move, ok := b.Book.Probe(&g.position, side); ok {
+		return SearchResult{BestMove: move, PV: []Move{move}}, nil
+	}
+	return b.Player.Think(ctx, g, side, limits)
+}
+
+// Close closes the player the book plays for.
+func (b *BookPlayer) Close() error {
+	closePlayer(b.Player)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestPolyglotKey checks the keys published with the Polyglot book format.
+func TestPolyglotKey(t *testing.T) {
+	tests := []struct {
+		fen string
+		key uint64
+	}{
+		{StartFEN, 0x463b96181691fc9c},
+		{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", 0x823c9b50fd114196},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", 0x0756b94461c50fb0},
+		{"rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2", 0x662fafb965db29d4},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", 0x22a48b5a8e47ff78},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR b kq - 0 3", 0x652a607ca3f242c1},
+		{"rnbq1bnr/ppp1pkpp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR w - - 0 4", 0x00fdd303c946bdd9},
+		{"rnbqkbnr/p1pppppp/8/8/PpP4P/8/1P1PPPP1/RNBQKBNR b KQkq c3 0 3", 0x3c8123ea7b067637},
+		{"rnbqkbnr/p1pppppp/8/8/P6P/R1p5/1P1PPPP1/1NBQKBNR b Kkq - 0 4", 0x5c3f9b829b279560},
+	}
+	for _, test := range tests {
+		p, side, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := PolyglotKey(p, side); key != test.key {
+			t.Errorf("%s: key %016x, want %016x", test.fen, key, test.key)
+		}
+	}
+}
+
+// TestPolyglotMove checks the encoding of moves, in which castling is the king taking its own rook.
+func TestPolyglotMove(t *testing.T) {
+	tests := []struct {
+		fen, move string
+		encoded   uint16
+	}{
+		{StartFEN, "e2e4", 1<<9 | 4<<6 | 3<<3 | 4},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", 4<<6 | 7},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", 4<<6 | 0},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8g8", 7<<9 | 4<<6 | 7<<3 | 7},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", 7<<9 | 4<<6 | 7<<3 | 0},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1f1", 4<<6 | 5},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8q", 4<<12 | 6<<9 | 1<<6 | 7<<3 | 1},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8n", 1<<12 | 6<<9 | 1<<6 | 7<<3 | 1},
+	}
+	for _, test := range tests {
+		p, side, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		move, ok := findLegalMove(p, side, test.move)
+		if !ok {
+			t.Fatalf("%s in %s isn't legal", test.move, test.fen)
+		}
+		if encoded := encodePolyglotMove(p, move); encoded != test.encoded {
+			t.Errorf("%s in %s: encoded %#04x, want %#04x", test.move, test.fen, encoded, test.encoded)
+		}
+		if decoded, ok := decodePolyglotMove(p, side, test.encoded); !ok || decoded != move {
+			t.Errorf("%s in %s: decoded %v (%v)", test.move, test.fen, decoded, ok)
+		}
+	}
+}
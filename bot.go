@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BotTransport is how a Bot talks to an online chess platform with a Lichess-style bot API. The streams are
+// newline-delimited JSON, and blank lines are sent to keep them alive.
+type BotTransport interface {
+	// StreamEvents streams incoming events for the bot's account, such as challenges and game starts.
+	StreamEvents(ctx context.Context) (io.ReadCloser, error)
+	// StreamGame streams the state of a game: a gameFull event followed by a gameState event after each move.
+	StreamGame(ctx context.Context, gameID string) (io.ReadCloser, error)
+	AcceptChallenge(ctx context.Context, challengeID string) error
+	DeclineChallenge(ctx context.Context, challengeID string) error
+	// MakeMove plays a move, given in long algebraic notation, in a game.
+	MakeMove(ctx context.Context, gameID string, move string) error
+}
+
+// HTTPBotTransport is a BotTransport speaking to a platform's HTTP API, authorized by an API token.
+type HTTPBotTransport struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+// NewHTTPBotTransport creates a transport for the platform at baseURL, e.g. https://lichess.org.
+func NewHTTPBotTransport(baseURL, token string) *HTTPBotTransport {
+	return &HTTPBotTransport{strings.TrimRight(baseURL, "/"), token, http.DefaultClient}
+}
+
+// StreamEvents implements BotTransport.
+func (t *HTTPBotTransport) StreamEvents(ctx context.Context) (io.ReadCloser, error) {
+	return t.do(ctx, http.MethodGet, "/api/stream/event")
+}
+
+// StreamGame implements BotTransport.
+func (t *HTTPBotTransport) StreamGame(ctx context.Context, gameID string) (io.ReadCloser, error) {
+	return t.do(ctx, http.MethodGet, "/api/bot/game/stream/"+gameID)
+}
+
+// AcceptChallenge implements BotTransport.
+func (t *HTTPBotTransport) AcceptChallenge(ctx context.Context, challengeID string) error {
+	return t.post(ctx, "/api/challenge/"+challengeID+"/accept")
+}
+
+// DeclineChallenge implements BotTransport.
+func (t *HTTPBotTransport) DeclineChallenge(ctx context.Context, challengeID string) error {
+	return t.post(ctx, "/api/challenge/"+challengeID+"/decline")
+}
+
+// MakeMove implements BotTransport.
+func (t *HTTPBotTransport) MakeMove(ctx context.Context, gameID string, move string) error {
+	return t.post(ctx, "/api/bot/game/"+gameID+"/move/"+move)
+}
+
+func (t *HTTPBotTransport) post(ctx context.Context, path string) error {
+	body, err := t.do(ctx, http.MethodPost, path)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+func (t *HTTPBotTransport) do(ctx context.Context, method, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, t.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// botEvent is an event from the account's event stream.
+type botEvent struct {
+	Type      string `json:"type"`
+	Challenge struct {
+		ID      string `json:"id"`
+		Variant struct {
+			Key string `json:"key"`
+		} `json:"variant"`
+	} `json:"challenge"`
+	Game struct {
+		ID     string `json:"id"`
+		GameID string `json:"gameId"`
+	} `json:"game"`
+}
+
+// botGameEvent is an event from a game's stream. gameFull events carry the players and initial position along with
+// the current state, while gameState events carry the state alone.
+type botGameEvent struct {
+	Type       string        `json:"type"`
+	White      botPlayer     `json:"white"`
+	Black      botPlayer     `json:"black"`
+	InitialFEN string        `json:"initialFen"`
+	State      *botGameState `json:"state"`
+	botGameState
+}
+
+type botPlayer struct {
+	ID     string `json:"id"`
+	Rating int    `json:"rating"`
+}
+
+type botGameState struct {
+	Moves  string `json:"moves"`
+	WTime  int    `json:"wtime"` // Milliseconds
+	BTime  int    `json:"btime"`
+	WInc   int    `json:"winc"`
+	BInc   int    `json:"binc"`
+	Status string `json:"status"`
+}
+
+// Bot plays games on an online platform through a BotTransport.
+type Bot struct {
+	Transport BotTransport
+	// ID is the bot's account id, used to tell which color it plays.
+	ID string
+	// MaxMoveTime caps the time spent on any one move.
+	MaxMoveTime time.Duration
+	// Logf, if not nil, receives progress messages.
+	Logf func(format string, args ...interface{})
+	// Book, if not nil, supplies moves without searching while the game is in it.
+	Book *OpeningBook
+}
+
+// Run accepts standard chess challenges and plays the games which start until the event stream ends or ctx is done.
+// It returns once all games in progress have finished.
+func (b *Bot) Run(ctx context.Context) error {
+	events, err := b.Transport.StreamEvents(ctx)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	var wg sync.WaitGroup
+	err = readNDJSON(events, func(line []byte) error {
+		var event botEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("reading event %s: %v", line, err)
+		}
+		switch event.Type {
+		case "challenge":
+			b.handleChallenge(ctx, event.Challenge.ID, event.Challenge.Variant.Key)
+		case "gameStart":
+			gameID := event.Game.GameID
+			if gameID == "" {
+				gameID = event.Game.ID
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := b.PlayGame(ctx, gameID); err != nil {
+					b.logf("game %s: %v", gameID, err)
+				}
+			}()
+		}
+		return nil
+	})
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (b *Bot) handleChallenge(ctx context.Context, id, variant string) {
+	var err error
+	if variant == "standard" || variant == "" {
+		b.logf("accepting challenge %s", id)
+		err = b.Transport.AcceptChallenge(ctx, id)
+	} else {
+		b.logf("declining challenge %s for variant %s", id, variant)
+		err = b.Transport.DeclineChallenge(ctx, id)
+	}
+	if err != nil {
+		b.logf("challenge %s: %v", id, err)
+	}
+}
+
+// PlayGame follows a game's stream, replying with a move whenever it is the bot's turn, until the game ends.
+func (b *Bot) PlayGame(ctx context.Context, gameID string) error {
+	stream, err := b.Transport.StreamGame(ctx, gameID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	var botSide Side
+	var opponentRating int
+	initialFEN := StartFEN
+	return readNDJSON(stream, func(line []byte) error {
+		var event botGameEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("reading game event %s: %v", line, err)
+		}
+
+		state := &event.botGameState
+		switch event.Type {
+		case "gameFull":
+			botSide, opponentRating = White, event.Black.Rating
+			if strings.EqualFold(event.Black.ID, b.ID) {
+				botSide, opponentRating = Black, event.White.Rating
+			}
+			if event.InitialFEN != "" && event.InitialFEN != "startpos" {
+				initialFEN = event.InitialFEN
+			}
+			if event.State == nil {
+				return nil
+			}
+			state = event.State
+		case "gameState":
+		default:
+			return nil
+		}
+
+		if state.Status != "" && state.Status != "started" && state.Status != "created" {
+			b.logf("game %s finished: %s", gameID, state.Status)
+			return io.EOF
+		}
+		g, side, err := replayGame(initialFEN, strings.Fields(state.Moves))
+		if err != nil {
+			return err
+		}
+		if side != botSide {
+			return nil
+		}
+
+		if move, ok := b.Book.probe(&g.position, side); ok {
+			b.logf("game %s: playing %v from the book", gameID, move)
+			return b.Transport.MakeMove(ctx, gameID, move.String())
+		}
+		// The opponent's rating lets the contempt depend on their strength.
+		engine := &EnginePlayer{OpponentRating: opponentRating}
+		result, err := engine.Think(ctx, *g, side, b.limits(state, side))
+		if err != nil {
+			return fmt.Errorf("searching: %v", err)
+		}
+		if len(result.PV) == 0 {
+			return nil
+		}
+		b.logf("game %s: playing %v (%.2f at depth %d)", gameID, result.BestMove, result.Score, result.Depth)
+		return b.Transport.MakeMove(ctx, gameID, result.BestMove.String())
+	})
+}
+
+// limits budgets the time for a move from the clock.
+func (b *Bot) limits(state *botGameState, side Side) SearchLimits {
+	remaining, inc := state.WTime, state.WInc
+	if side == Black {
+		remaining, inc = state.BTime, state.BInc
+	}
+	if remaining <= 0 {
+		return SearchLimits{Depth: defaultDepth, MoveTime: b.MaxMoveTime}
+	}
+	limits := ClockLimits(time.Duration(remaining)*time.Millisecond, time.Duration(inc)*time.Millisecond)
+	if b.MaxMoveTime > 0 && limits.MoveTime > b.MaxMoveTime {
+		limits.MoveTime = b.MaxMoveTime
+	}
+	return limits
+}
+
+func (b *Bot) logf(format string, args ...interface{}) {
+	if b.Logf != nil {
+		b.Logf(format, args...)
+	}
+}
+
+// replayGame plays moves in long algebraic notation from the position given by fen. It returns the game along with
+// the side to move.
+func replayGame(fen string, moves []string) (*GameContext, Side, error) {
+	p, side, err := ParseFEN(fen)
+	if err != nil {
+		return nil, White, err
+	}
+	g := NewGameFromPosition(p)
+	for _, moveStr := range moves {
+		move, ok := findLegalMove(&g.position, side, moveStr)
+		if !ok {
+			return nil, White, fmt.Errorf("illegal move %q in %s", moveStr, g.position.FEN(side))
+		}
+		g.MakeMove(move)
+		side = side.OppSide()
+	}
+	return g, side, nil
+}
+
+// readNDJSON calls handle with each non-blank line of r until r ends or handle returns an error. Returning io.EOF
+// from handle stops reading without error.
+func readNDJSON(r io.Reader, handle func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err := handle(line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// RunBot plays as a bot on the platform at baseURL until interrupted. The token is the bot account's API token.
+func RunBot(baseURL, token, id string, book *OpeningBook) error {
+	bot := &Bot{NewHTTPBotTransport(baseURL, token), id, 0, log.Printf, book}
+	return bot.Run(context.Background())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBotAgainstMockPlatform(t *testing.T) {
+	mock := NewMockBotPlatform("testdata/bot", "robchess")
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	bot := &Bot{
+		Transport:   NewHTTPBotTransport(server.URL, ""),
+		ID:          "robchess",
+		MaxMoveTime: 100 * time.Millisecond,
+		Logf:        t.Logf,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := bot.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mock.Accepted(); !reflect.DeepEqual(got, []string{"stdchal1"}) {
+		t.Errorf("accepted %v, want [stdchal1]", got)
+	}
+	if got := mock.Declined(); !reflect.DeepEqual(got, []string{"c960chal"}) {
+		t.Errorf("declined %v, want [c960chal]", got)
+	}
+
+	// The bot plays White, so its nth move answers the recording's first 2(n-1) moves.
+	moves := recordedMoves(t, "testdata/bot/game-stdchal1.ndjson")
+	played, _ := mock.Played("stdchal1")
+	if len(played) == 0 {
+		t.Fatal("the bot played no moves")
+	}
+	for i, move := range played {
+		g, side, err := replayGame(StartFEN, moves[:2*i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := findLegalMove(&g.position, side, move); !ok {
+			t.Errorf("move %d: %s is illegal after %s", i+1, move, strings.Join(moves[:2*i], " "))
+		}
+	}
+}
+
+// recordedMoves returns the moves of the last game state in a recorded game stream.
+func recordedMoves(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var moves string
+	err = readNDJSON(f, func(line []byte) error {
+		var event botGameEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		if event.Type == "gameState" {
+			moves = event.Moves
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(moves)
+}
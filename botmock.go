@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockMoveTimeout bounds how long MockBotPlatform waits for the bot to reply to a position.
+const mockMoveTimeout = time.Minute
+
+// MockBotPlatform is an in-process stand-in for a Lichess-style bot platform which replays recorded streams, so that
+// a Bot can be run entirely offline. A recording is a directory holding events.ndjson, the account's event stream,
+// and game-<id>.ndjson, the stream of each game. Before replaying a game state which ends with one of the bot's
+// moves, the platform waits for the bot to post its own move, which is recorded next to the one in the recording.
+type MockBotPlatform struct {
+	dir   string
+	botID string
+
+	mu       sync.Mutex
+	accepted []string
+	declined []string
+	posted   map[string]chan string
+	played   map[string][]string
+	recorded map[string][]string
+}
+
+// NewMockBotPlatform creates a platform replaying the recording in dir to the bot with account id botID.
+func NewMockBotPlatform(dir, botID string) *MockBotPlatform {
+	return &MockBotPlatform{
+		dir:      dir,
+		botID:    botID,
+		posted:   make(map[string]chan string),
+		played:   make(map[string][]string),
+		recorded: make(map[string][]string),
+	}
+}
+
+func (m *MockBotPlatform) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/stream/event":
+		m.replayFile(w, filepath.Join(m.dir, "events.ndjson"), nil)
+	case r.Method == http.MethodGet && len(parts) == 5 && strings.HasPrefix(r.URL.Path, "/api/bot/game/stream/"):
+		m.replayGame(w, r.Context(), parts[4])
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "challenge":
+		m.mu.Lock()
+		if parts[3] == "accept" {
+			m.accepted = append(m.accepted, parts[2])
+		} else {
+			m.declined = append(m.declined, parts[2])
+		}
+		m.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case r.Method == http.MethodPost && len(parts) == 6 && parts[1] == "bot" && parts[4] == "move":
+		select {
+		case m.moves(parts[3]) <- parts[5]:
+			writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Errorf("not your turn"))
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// moves returns the channel on which the bot's moves in a game are delivered.
+func (m *MockBotPlatform) moves(gameID string) chan string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.posted[gameID] == nil {
+		m.posted[gameID] = make(chan string, 1)
+	}
+	return m.posted[gameID]
+}
+
+func (m *MockBotPlatform) replayGame(w http.ResponseWriter, ctx context.Context, gameID string) {
+	botSide := White
+	startSide := White
+	replayed := 0
+	m.replayFile(w, filepath.Join(m.dir, "game-"+gameID+".ndjson"), func(line []byte) error {
+		var event botGameEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		if event.Type == "gameFull" {
+			if strings.EqualFold(event.Black.ID, m.botID) {
+				botSide = Black
+			}
+			if event.InitialFEN != "" && event.InitialFEN != "startpos" {
+				_, side, err := ParseFEN(event.InitialFEN)
+				if err != nil {
+					return err
+				}
+				startSide = side
+			}
+			if event.State != nil {
+				replayed = len(strings.Fields(event.State.Moves))
+			}
+			return nil
+		}
+		if event.Type != "gameState" {
+			return nil
+		}
+
+		// Wait for the bot's reply when the recorded state adds a move of the bot's.
+		moves := strings.Fields(event.Moves)
+		lastSide := startSide
+		if len(moves)%2 == 0 {
+			lastSide = startSide.OppSide()
+		}
+		if len(moves) == replayed || lastSide != botSide {
+			replayed = len(moves)
+			return nil
+		}
+		replayed = len(moves)
+		select {
+		case move := <-m.moves(gameID):
+			m.mu.Lock()
+			m.played[gameID] = append(m.played[gameID], move)
+			m.recorded[gameID] = append(m.recorded[gameID], moves[len(moves)-1])
+			m.mu.Unlock()
+			return nil
+		case <-time.After(mockMoveTimeout):
+			return fmt.Errorf("game %s: timed out waiting for the bot to move", gameID)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// replayFile streams the lines of a recording. before, if not nil, is called ahead of each line and may delay it.
+func (m *MockBotPlatform) replayFile(w http.ResponseWriter, path string, before func(line []byte) error) {
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	readNDJSON(f, func(line []byte) error {
+		if before != nil {
+			if err := before(line); err != nil {
+				return err
+			}
+		}
+		w.Write(append(line, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// Accepted returns the ids of the challenges the bot accepted.
+func (m *MockBotPlatform) Accepted() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.accepted...)
+}
+
+// Declined returns the ids of the challenges the bot declined.
+func (m *MockBotPlatform) Declined() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.declined...)
+}
+
+// Played returns the moves the bot played in a game, alongside the moves played in the recording.
+func (m *MockBotPlatform) Played(gameID string) (played, recorded []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.played[gameID]...), append([]string(nil), m.recorded[gameID]...)
+}
+
+// Games returns the ids of the games in which the bot played a move.
+func (m *MockBotPlatform) Games() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.played))
+	for id := range m.played {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ReplayBot runs the bot against a MockBotPlatform replaying the recording in dir and prints what it did.
+func ReplayBot(dir, botID string) error {
+	mock := NewMockBotPlatform(dir, botID)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	bot := &Bot{NewHTTPBotTransport(server.URL, ""), botID, time.Second, nil, nil}
+	if err := bot.Run(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Accepted challenges: %v\n", mock.Accepted())
+	fmt.Printf("Declined challenges: %v\n", mock.Declined())
+	for _, id := range mock.Games() {
+		played, recorded := mock.Played(id)
+		fmt.Printf("Game %s\n", id)
+		for i := range played {
+			fmt.Printf("  played %s, recorded %s\n", played[i], recorded[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Contempt sets how the engine judges draws. With positive contempt it takes a draw to be worse than an even
+// position for itself, so it plays on in level positions and avoids repetitions, which suits games against weaker
+// opponents; with negative contempt it seeks draws against stronger ones.
+type Contempt struct {
+	// White and Black are the contempt in centipawns when the engine plays each side.
+	White, Black int
+	// Rating is the engine's own rating, and PerHundredElo the contempt added for every 100 points an opponent is
+	// rated below it, or taken away for every 100 above. Opponents of unknown rating are taken to be its equal.
+	Rating, PerHundredElo int
+	// Analysis scores draws as even for both sides whatever the other settings, so that a position's score doesn't
+	// depend on which side the engine plays.
+	Analysis bool
+}
+
+// contempt is the contempt the engine plays with unless a player is given its own.
+var contempt Contempt
+
+// UseContempt has the engine play with c.
+func UseContempt(c Contempt) {
+	contempt = c
+}
+
+// For returns the contempt in centipawns when the engine plays side against an opponent rated opponentRating, or 0
+// when the rating isn't known.
+func (c Contempt) For(side Side, opponentRating int) int {
+	if c.Analysis {
+		return 0
+	}
+	cp := c.White
+	if side == Black {
+		cp = c.Black
+	}
+	if opponentRating > 0 && c.Rating > 0 {
+		cp += (c.Rating - opponentRating) * c.PerHundredElo / 100
+	}
+	return cp
+}
+
+// SetSides sets the contempt for each side from centipawns given once for both, e.g. "20", or for White and Black
+// separated by a colon, e.g. "30:0".
+func (c *Contempt) SetSides(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("contempt %q: expected one value or two separated by a colon", value)
+	}
+	var sides [2]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("contempt %q: %v", value, err)
+		}
+		sides[i] = n
+	}
+	if len(parts) == 1 {
+		sides[Black] = sides[White]
+	}
+	c.White, c.Black = sides[White], sides[Black]
+	return nil
+}
+
+// drawScore returns the score of a draw for side, the side to move, in pawns.
+func (s *searcher) drawScore(side Side) float64 {
+	if side == s.side {
+		return -float64(s.contempt) / 100
+	}
+	return float64(s.contempt) / 100
+}
+
+// isRepetition reports whether the position with key, reached at the end of the positions of s.keys, has already
+// occurred in the game or the search. Only positions since the last capture or pawn move can repeat, and a single
+// repetition is scored as a draw, since whatever was played to get back to the position can be played again.
+func (s *searcher) isRepetition(key uint64, halfMoveClock int) bool {
+	last := len(s.keys) - halfMoveClock
+	for i := len(s.keys) - 2; i >= 0 && i >= last; i -= 2 {
+		if s.keys[i] == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataRecord is a position of a generated dataset: the position, the score and best move of a search of it, and the
+// result of the game it was played in.
+type DataRecord struct {
+	Position *Position
+	Side     Side
+	// Score is the search's score in centipawns, from White's perspective.
+	Score int
+	Move  Move
+	// Result is White's score in the game: 1 for a win, 0.5 for a draw and 0 for a loss.
+	Result float64
+}
+
+// dataRecordSize is the size of an encoded DataRecord. Records are stored one after another, little-endian:
+//
+//	occupied       uint64    a bit for each occupied square, a1 first
+//	pieces         [16]byte  a nibble for each occupied square in order, low nibble first: color<<3 | piece
+//	flags          byte      bit 0 set with Black to move, bits 1-4 the castling rights K, Q, k and q
+//	enPassant      byte      the file of the en passant square plus one, or 0
+//	halfMoveClock  byte      capped at 255
+//	fullMoveNumber uint16
+//	score          int16     centipawns from White's perspective, capped at 32767 either way
+//	move           uint16    from square | to square<<6 | promotion<<12, squares rank*8+file from a1 and promotions
+//	                         numbered knight 1, bishop 2, rook 3, queen 4
+//	result         byte      0 when Black won, 1 for a draw, 2 when White won
+//
+// Pieces are numbered pawn, rook, knight, bishop, queen, king.
+const dataRecordSize = 34
+
+// promotionCodes numbers the promotion pieces of encoded moves.
+var promotionCodes = [...]string{"", "n", "b", "r", "q"}
+
+// encode writes the record to buf, which holds dataRecordSize bytes.
+func (d *DataRecord) encode(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	p := d.Position
+	var occupied uint64
+	n := 0
+	for sq := 0; sq < 64; sq++ {
+		piece := p.board[sq/8][sq%8]
+		if piece.piece == None {
+			continue
+		}
+		occupied |= 1 << uint(sq)
+		buf[8+n/2] |= byte(int(piece.color)<<3|int(piece.piece)) << uint(4*(n%2))
+		n++
+	}
+	binary.LittleEndian.PutUint64(buf, occupied)
+
+	var flags byte
+	if d.Side == Black {
+		flags |= 1
+	}
+	for i, ok := range [...]bool{p.canCastleShortWhite, p.canCastleLongWhite, p.canCastleShortBlack,
+		p.canCastleLongBlack} {
+		if ok {
+			flags |= 2 << uint(i)
+		}
+	}
+	buf[24] = flags
+	if p.enPassant != noSquare {
+		buf[25] = byte(p.enPassant.file + 1)
+	}
+	clock := p.halfMoveClock
+	if clock > 255 {
+		clock = 255
+	}
+	buf[26] = byte(clock)
+	binary.LittleEndian.PutUint16(buf[27:], uint16(p.fullMoveNumber))
+	score := d.Score
+	if score > math.MaxInt16 {
+		score = math.MaxInt16
+	} else if score < -math.MaxInt16 {
+		score = -math.MaxInt16
+	}
+	binary.LittleEndian.PutUint16(buf[29:], uint16(int16(score)))
+
+	move := d.Move
+	code := uint16(move.oRank*8+move.oFile) | uint16(move.nRank*8+move.nFile)<<6
+	for i, promo := range promotionCodes {
+		if i > 0 && promo == move.promoPiece {
+			code |= uint16(i) << 12
+		}
+	}
+	binary.LittleEndian.PutUint16(buf[31:], code)
+	buf[33] = byte(math.Round(d.Result * 2))
+}
+
+// decodeDataRecord reads a record written by encode.
+func decodeDataRecord(buf []byte) (DataRecord, error) {
+	var d DataRecord
+	p := NewPosition()
+	occupied := binary.LittleEndian.Uint64(buf)
+	n := 0
+	for sq := 0; sq < 64; sq++ {
+		p.board[sq/8][sq%8] = GamePiece{None, White}
+		if occupied&(1<<uint(sq)) == 0 {
+			continue
+		}
+		if n == 32 {
+			return d, fmt.Errorf("more than 32 pieces")
+		}
+		nibble := buf[8+n/2] >> uint(4*(n%2)) & 0xf
+		if nibble&7 > byte(King) {
+			return d, fmt.Errorf("bad piece %d", nibble)
+		}
+		p.board[sq/8][sq%8] = GamePiece{Piece(nibble & 7), Side(nibble >> 3)}
+		n++
+	}
+
+	flags := buf[24]
+	d.Side = White
+	if flags&1 != 0 {
+		d.Side = Black
+	}
+	p.canCastleShortWhite, p.canCastleLongWhite = flags&2 != 0, flags&4 != 0
+	p.canCastleShortBlack, p.canCastleLongBlack = flags&8 != 0, flags&16 != 0
+	p.enPassant = noSquare
+	if file := int(buf[25]); file > 0 {
+		if file > 8 {
+			return d, fmt.Errorf("bad en passant file %d", file)
+		}
+		rank := 5
+		if d.Side == Black {
+			rank = 2
+		}
+		p.enPassant = Square{file - 1, rank}
+	}
+	p.halfMoveClock = int(buf[26])
+	p.fullMoveNumber = int(binary.LittleEndian.Uint16(buf[27:]))
+	d.Position = p
+	d.Score = int(int16(binary.LittleEndian.Uint16(buf[29:])))
+
+	code := binary.LittleEndian.Uint16(buf[31:])
+	from, to, promo := int(code&63), int(code>>6&63), int(code>>12)
+	if promo >= len(promotionCodes) {
+		return d, fmt.Errorf("bad promotion %d", promo)
+	}
+	d.Move = Move{from % 8, from / 8, to % 8, to / 8, promotionCodes[promo]}
+	if buf[33] > 2 {
+		return d, fmt.Errorf("bad result %d", buf[33])
+	}
+	d.Result = float64(buf[33]) / 2
+	return d, nil
+}
+
+// ReadDataRecords reads every record of a file written by the datagen command. A partial record at the end, left by
+// an interrupted run, is ignored.
+func ReadDataRecords(path string, f func(DataRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	buf := make([]byte, dataRecordSize)
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		record, err := decodeDataRecord(buf)
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %v", path, i, err)
+		}
+		if err := f(record); err != nil {
+			return err
+		}
+	}
+}
+
+// EPD returns the record as an EPD line: the position with its clocks, the best move, the score from the side to
+// move's perspective as the ce operation, and the game's result as the c9 operation, which tune reads.
+func (d *DataRecord) EPD() string {
+	fields := strings.Fields(d.Position.FEN(d.Side))
+	score := d.Score
+	if d.Side == Black {
+		score = -score
+	}
+	result := "1/2-1/2"
+	switch d.Result {
+	case 1:
+		result = "1-0"
+	case 0:
+		result = "0-1"
+	}
+	return fmt.Sprintf("%s hmvc %s; fmvn %s; bm %s; ce %d; c9 \"%s\";", strings.Join(fields[:4], " "), fields[4],
+		fields[5], d.Position.SAN(d.Move), score, result)
+}
+
+// DatagenConfig configures the datagen command's self-play.
+type DatagenConfig struct {
+	Limits SearchLimits
+	// Openings are FENs to start games from, chosen at random. Games start from the starting position without them.
+	Openings []string
+	// Book, if not nil, plays the first moves of each game after the opening.
+	Book *OpeningBook
+	// RandomPlies random moves are played after the opening and book moves, so that games differ.
+	RandomPlies int
+	// MaxPlies adjudicates a game as a draw once that many plies have been searched. Zero means no limit.
+	MaxPlies int
+	// ResignScore and ResignPlies adjudicate a game as won once the score has been at least ResignScore pawns for
+	// one side for ResignPlies plies in a row. A zero ResignScore never adjudicates.
+	ResignScore float64
+	ResignPlies int
+	// MaxScore leaves out positions scored at least this many pawns for either side, such as mates. Zero keeps them.
+	MaxScore float64
+}
+
+// PlayDataGame plays a self-play game and returns the positions worth training on, labelled with the game's result.
+// Positions with the side to move in check, or after a capture or with a capture or promotion as the best move, are
+// left out, as their scores depend on the exchange under way rather than on the position. It returns no positions
+// when the opening leaves no game to play.
+func PlayDataGame(ctx context.Context, cfg DatagenConfig, rng *rand.Rand) []DataRecord {
+	fen := StartFEN
+	if len(cfg.Openings) > 0 {
+		fen = cfg.Openings[rng.Intn(len(cfg.Openings))]
+	}
+	p, side, err := ParseFEN(fen)
+	if err != nil {
+		return nil
+	}
+	for cfg.Book != nil {
+		move, ok := cfg.Book.Probe(p, side)
+		if !ok {
+			break
+		}
+		p.makeMove(move)
+		side = side.OppSide()
+	}
+	for i := 0; i < cfg.RandomPlies; i++ {
+		moves := p.GetMoves(side)
+		if len(moves) == 0 {
+			return nil
+		}
+		p.makeMove(moves[rng.Intn(len(moves))])
+		side = side.OppSide()
+	}
+
+	g := NewGameFromPosition(p)
+	repetitions := map[string]int{repetitionKey(&g.position, side): 1}
+	player := &EnginePlayer{}
+	var records []DataRecord
+	result := 0.5
+	resignPlies, captured := 0, false
+	for plies := 0; ; plies++ {
+		moves := g.position.GetMoves(side)
+		if len(moves) == 0 {
+			if g.position.InCheck(side) {
+				result = float64(side)
+			}
+			break
+		}
+		if g.position.halfMoveClock >= 100 || repetitions[repetitionKey(&g.position, side)] >= 3 ||
+			g.position.InsufficientMaterial() || cfg.MaxPlies > 0 && plies >= cfg.MaxPlies || ctx.Err() != nil {
+			break
+		}
+
+		searched, _ := player.Think(ctx, *g, side, cfg.Limits)
+		if ctx.Err() != nil {
+			return nil
+		}
+		move := searched.BestMove
+		score := searched.Score
+		if side == Black {
+			score = -score
+		}
+		quiet := !captured && move.promoPiece == "" && !g.position.InCheck(side) &&
+			(cfg.MaxScore == 0 || math.Abs(score) < cfg.MaxScore)
+		undo := g.position.makeMove(move)
+		captured = undo.captured.piece != None
+		g.position.unmakeMove(undo)
+		if quiet && !captured {
+			records = append(records, DataRecord{g.position.Copy(), side, int(math.Round(score * 100)), move, 0})
+		}
+
+		// resignPlies counts up while White is winning and down while Black is.
+		switch {
+		case cfg.ResignScore > 0 && score >= cfg.ResignScore:
+			if resignPlies < 0 {
+				resignPlies = 0
+			}
+			resignPlies++
+		case cfg.ResignScore > 0 && score <= -cfg.ResignScore:
+			if resignPlies > 0 {
+				resignPlies = 0
+			}
+			resignPlies--
+		default:
+			resignPlies = 0
+		}
+		if cfg.ResignPlies > 0 && (resignPlies >= cfg.ResignPlies || resignPlies <= -cfg.ResignPlies) {
+			result = 0
+			if resignPlies > 0 {
+				result = 1
+			}
+			break
+		}
+
+		g.MakeMove(move)
+		side = side.OppSide()
+		repetitions[repetitionKey(&g.position, side)]++
+	}
+	for i := range records {
+		records[i].Result = result
+	}
+	return records
+}
+
+// dataWriter appends the positions of finished games to the dataset's files, leaving out positions already written.
+type dataWriter struct {
+	mu      sync.Mutex
+	bin     *os.File
+	epd     *os.File
+	seen    map[uint64]bool
+	written int
+}
+
+// openDataWriter opens the dataset's files. With resume, the positions already in them are kept and remembered so
+// that they aren't written again, and anything left partly written by an interrupted run is cut off. Without it,
+// existing files are an error.
+func openDataWriter(binPath, epdPath string, resume bool) (*dataWriter, error) {
+	w := &dataWriter{seen: map[uint64]bool{}}
+	flags := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if resume {
+		flags = os.O_RDWR | os.O_CREATE
+	}
+	var err error
+	if w.bin, err = os.OpenFile(binPath, flags, 0644); err != nil {
+		if os.IsExist(err) {
+			err = fmt.Errorf("%s already exists; use -resume to add to it", binPath)
+		}
+		return nil, err
+	}
+	if epdPath != "" {
+		if w.epd, err = os.OpenFile(epdPath, flags, 0644); err != nil {
+			w.bin.Close()
+			if os.IsExist(err) {
+				err = fmt.Errorf("%s already exists; use -resume to add to it", epdPath)
+			}
+			return nil, err
+		}
+	}
+	if !resume {
+		return w, nil
+	}
+
+	err = ReadDataRecords(binPath, func(d DataRecord) error {
+		w.seen[PolyglotKey(d.Position, d.Side)] = true
+		w.written++
+		return nil
+	})
+	if err == nil {
+		err = truncateAfter(w.bin, int64(w.written*dataRecordSize))
+	}
+	if err == nil && w.epd != nil {
+		err = truncateLines(w.epd, w.written)
+	}
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// truncateAfter cuts a file off at size and moves to its end.
+func truncateAfter(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekEnd)
+	return err
+}
+
+// truncateLines cuts a text file off after at most n whole lines, so that it holds no more positions than the binary
+// file, and moves to its end.
+func truncateLines(f *os.File, n int) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	size := 0
+	for ; n > 0; n-- {
+		end := bytes.IndexByte(data[size:], '\n')
+		if end < 0 {
+			break
+		}
+		size += end + 1
+	}
+	return truncateAfter(f, int64(size))
+}
+
+// write appends the records which haven't been seen before and returns how many it wrote.
+func (w *dataWriter) write(records []DataRecord) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var bin []byte
+	var epd strings.Builder
+	buf := make([]byte, dataRecordSize)
+	n := 0
+	for i := range records {
+		key := PolyglotKey(records[i].Position, records[i].Side)
+		if w.seen[key] {
+			continue
+		}
+		w.seen[key] = true
+		records[i].encode(buf)
+		bin = append(bin, buf...)
+		if w.epd != nil {
+			epd.WriteString(records[i].EPD())
+			epd.WriteByte('\n')
+		}
+		n++
+	}
+	// Each game's positions are written at once, so an interrupted run loses at most a partial game.
+	if _, err := w.bin.Write(bin); err != nil {
+		return 0, err
+	}
+	if w.epd != nil {
+		if _, err := w.epd.WriteString(epd.String()); err != nil {
+			return 0, err
+		}
+	}
+	w.written += n
+	return n, nil
+}
+
+// Close closes the dataset's files.
+func (w *dataWriter) Close() error {
+	err := w.bin.Close()
+	if w.epd != nil {
+		if epdErr := w.epd.Close(); err == nil {
+			err = epdErr
+		}
+	}
+	return err
+}
+
+// DatagenCommand runs the datagen command, which plays self-play games across several goroutines until a dataset
+// holds the number of positions asked for.
+func DatagenCommand(args []string) error {
+	fs := flag.NewFlagSet("datagen", flag.ExitOnError)
+	out := fs.String("out", "data.bin", "`file` to write the positions to in the binary format")
+	epdPath := fs.String("epd", "", "also write the positions to this EPD `file`")
+	positions := fs.Int("positions", 100000, "number of positions the dataset should hold")
+	resume := fs.Bool("resume", false, "add to existing files, skipping the positions already in them")
+	threads := fs.Int("threads", runtime.NumCPU(), "number of games to play at once")
+	depth := fs.Int("depth", 0, "depth limit per move")
+	nodes := fs.Int("nodes", 0, "node limit per move")
+	moveTime := fs.Duration("movetime", 0, "time limit per move")
+	openingsPath := fs.String("openings", "", "`file` of FEN or EPD opening positions, or of PGN games")
+	bookPath := fs.String("book", "", "play each game's first moves from the Polyglot book `file`")
+	bookDepth := fs.Int("book-depth", 16, "stop using the book after this many plies")
+	randomPlies := fs.Int("random-plies", 8, "number of random moves played after the opening and book")
+	maxPlies := fs.Int("max-plies", 400, "adjudicate a draw after this many plies")
+	resignScore := fs.Int("resign-score", 1000, "adjudicate a win once the score reaches this many centipawns")
+	resignPlies := fs.Int("resign-plies", 8, "number of plies in a row the resign score must hold for")
+	maxScore := fs.Int("max-score", 2000, "leave out positions scored at least this many centipawns (0 keeps all)")
+	seed := fs.Int64("seed", 0, "seed of the random openings (0 for the current time)")
+	fs.Parse(args)
+
+	cfg := DatagenConfig{
+		Limits:      SearchLimits{*depth, *nodes, *moveTime},
+		RandomPlies: *randomPlies,
+		MaxPlies:    *maxPlies,
+		ResignScore: float64(*resignScore) / 100,
+		ResignPlies: *resignPlies,
+		MaxScore:    float64(*maxScore) / 100,
+	}
+	if cfg.Limits == (SearchLimits{}) {
+		cfg.Limits.Depth = defaultDepth
+	}
+	if *openingsPath != "" {
+		var err error
+		if cfg.Openings, err = LoadOpenings(*openingsPath); err != nil {
+			return err
+		}
+	}
+	if *bookPath != "" {
+		var err error
+		if cfg.Book, err = LoadBook(*bookPath); err != nil {
+			return err
+		}
+		cfg.Book.MaxPly = *bookDepth
+	}
+	if *threads < 1 {
+		*threads = 1
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	w, err := openDataWriter(*out, *epdPath, *resume)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	resumed := w.written
+	if resumed > 0 {
+		fmt.Printf("Resuming with %d positions\n", resumed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	var mu sync.Mutex
+	var firstErr error
+	games, duplicates := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < *threads; i++ {
+		wg.Add(1)
+		// Resumed runs seed differently so that they don't replay the games already played.
+		rng := rand.New(rand.NewSource(*seed + int64(i) + int64(resumed)*int64(*threads)))
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				records := PlayDataGame(ctx, cfg, rng)
+				if len(records) == 0 {
+					continue
+				}
+				n, err := w.write(records)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				games++
+				duplicates += len(records) - n
+				w.mu.Lock()
+				written := w.written
+				w.mu.Unlock()
+				if games%10 == 0 || written >= *positions {
+					elapsed := time.Since(start)
+					fmt.Printf("%d games, %d positions (%d duplicates skipped), %.0f positions/s\n", games, written,
+						duplicates, float64(written-resumed)/elapsed.Seconds())
+				}
+				mu.Unlock()
+				if err != nil || written >= *positions {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return w.Close()
+}
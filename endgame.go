@@ -0,0 +1,341 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// knownWin is added to the evaluation of endings known to be won, so that the search heads for them and, once there,
+// plays for progress rather than material.
+const knownWin = 1000
+
+// endgameEval evaluates a specific ending with side to move, from the perspective of the stronger side.
+type endgameEval func(p *Position, strong, side Side) int
+
+// endgameEvals holds the evaluations of specific endings, by their material with the stronger side first. They
+// replace the general evaluation.
+var endgameEvals = map[string]endgameEval{
+	"KPvK":  evaluateKPK,
+	"KQvK":  evaluateKXK,
+	"KRvK":  evaluateKXK,
+	"KBNvK": evaluateKBNK,
+}
+
+// evaluateEndgame evaluates the position with side to move when it's an ending with an evaluation of its own.
+func evaluateEndgame(p *Position, side Side) (int, bool) {
+	material, mirror := syzygyMaterial(p)
+	strong := White
+	if mirror {
+		strong = Black
+	}
+	score := 0
+	if eval := endgameEvals[material]; eval != nil {
+		score = eval(p, strong, side)
+	} else if !wrongBishopDraw(p, material, strong) {
+		return 0, false
+	}
+	if side != strong {
+		score = -score
+	}
+	return score, true
+}
+
+// normalScale is the scale of the general evaluation, which endgameScale reduces in drawish endings.
+const normalScale = 64
+
+// endgameScale returns the scale, out of normalScale, of the general evaluation of an ending which is harder to win
+// than its material suggests.
+func endgameScale(p *Position) int {
+	if oppositeBishops(p) {
+		return normalScale / 2
+	}
+	return normalScale
+}
+
+// squareDistance returns the number of king moves between two squares.
+func squareDistance(f1, r1, f2, r2 int) int {
+	return maxInt(absInt(f1-f2), absInt(r1-r2))
+}
+
+// centerDistance returns the number of files and ranks between a square and the four center squares, from 0 to 6.
+func centerDistance(f, r int) int {
+	return maxInt(3-f, f-4) + maxInt(3-r, r-4)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// findPiece returns the square of a piece, which must be on the board.
+func findPiece(p *Position, piece GamePiece) (f, r int) {
+	for r := range p.board {
+		for f := range p.board[r] {
+			if p.board[r][f] == piece {
+				return f, r
+			}
+		}
+	}
+	return -1, -1
+}
+
+// pushToEdge rewards driving the weak king to the edge of the board and bringing the strong king up to it, which is
+// how a lone king is mated.
+func pushToEdge(p *Position, strong Side) int {
+	sf, sr := findPiece(p, GamePiece{King, strong})
+	wf, wr := findPiece(p, GamePiece{King, strong.OppSide()})
+	return 20*centerDistance(wf, wr) + 10*(7-squareDistance(sf, sr, wf, wr))
+}
+
+// evaluateKXK evaluates a queen or rook against a lone king, which wins by driving the king to the edge.
+func evaluateKXK(p *Position, strong, side Side) int {
+	material := 0
+	for _, piece := range p.GetPieces(strong) {
+		material += pieceValues[piece.piece].EG
+	}
+	return knownWin + material + pushToEdge(p, strong)
+}
+
+// evaluateKBNK evaluates bishop and knight against a lone king, which can only be mated in a corner of the bishop's
+// color.
+func evaluateKBNK(p *Position, strong, side Side) int {
+	bf, br := findPiece(p, GamePiece{Bishop, strong})
+	wf, wr := findPiece(p, GamePiece{King, strong.OppSide()})
+	// a1 and h8 are dark, a8 and h1 light.
+	corner := squareDistance(wf, wr, 0, 0)
+	if (bf+br)%2 == 0 {
+		corner = minInt(corner, squareDistance(wf, wr, 7, 7))
+	} else {
+		corner = minInt(squareDistance(wf, wr, 0, 7), squareDistance(wf, wr, 7, 0))
+	}
+	return knownWin + pieceValues[Bishop].EG + pieceValues[Knight].EG + pushToEdge(p, strong) + 30*(7-corner)
+}
+
+// evaluateKPK evaluates a pawn against a lone king by the KPK bitbase: won positions are worth a queen's promise,
+// drawn ones nothing.
+func evaluateKPK(p *Position, strong, side Side) int {
+	pf, pr := findPiece(p, GamePiece{Pawn, strong})
+	sf, sr := findPiece(p, GamePiece{King, strong})
+	wf, wr := findPiece(p, GamePiece{King, strong.OppSide()})
+	// The bitbase is for a white pawn moving up the board, so a black pawn's ranks are reversed.
+	if strong == Black {
+		pr, sr, wr = 7-pr, 7-sr, 7-wr
+	}
+	stm := White
+	if side != strong {
+		stm = Black
+	}
+	if !probeKPK(sf, sr, wf, wr, pf, pr, stm) {
+		return 0
+	}
+	return knownWin + pieceValues[Pawn].EG + 10*pr
+}
+
+// wrongBishopDraw reports whether the stronger side's bishop and rook pawns are drawn against a lone king: the
+// bishop can't cover the promotion square, so the king there can't be driven out.
+func wrongBishopDraw(p *Position, material string, strong Side) bool {
+	if !strings.HasPrefix(material, "KBP") || !strings.HasSuffix(material, "vK") ||
+		strings.Trim(material[2:len(material)-2], "P") != "" {
+		return false
+	}
+	file := -1
+	for r := range p.board {
+		for f := range p.board[r] {
+			if p.board[r][f] == (GamePiece{Pawn, strong}) {
+				if f != 0 && f != 7 || file >= 0 && f != file {
+					return false
+				}
+				file = f
+			}
+		}
+	}
+	promotionRank := 7
+	if strong == Black {
+		promotionRank = 0
+	}
+	bf, br := findPiece(p, GamePiece{Bishop, strong})
+	if (bf+br)%2 == (file+promotionRank)%2 {
+		return false
+	}
+	wf, wr := findPiece(p, GamePiece{King, strong.OppSide()})
+	return squareDistance(wf, wr, file, promotionRank) <= 1
+}
+
+// oppositeBishops reports whether each side has only a bishop, on squares of opposite colors, and pawns.
+func oppositeBishops(p *Position) bool {
+	bishops := [2]int{}
+	colors := [2]int{}
+	for r := range p.board {
+		for f := range p.board[r] {
+			switch piece := p.board[r][f]; piece.piece {
+			case Rook, Knight, Queen:
+				return false
+			case Bishop:
+				bishops[piece.color]++
+				colors[piece.color] = (f + r) % 2
+			}
+		}
+	}
+	return bishops[White] == 1 && bishops[Black] == 1 && colors[White] != colors[Black]
+}
+
+// The KPK bitbase records, for each position of king and pawn against king with a white pawn on the a-d files, whether
+// White wins. Positions are indexed by the side to move, the pawn's square and the kings' squares.
+var (
+	kpkOnce   sync.Once
+	kpkWins   []uint64
+	kingMoves [64]uint64
+)
+
+// The classification of positions while the KPK bitbase is built.
+const (
+	kpkInvalid = 0
+	kpkUnknown = 1 << iota
+	kpkDraw
+	kpkWin
+)
+
+func kpkIndex(stm Side, wk, bk, psq int) int {
+	pawn := (psq/8-1)*4 + psq%8
+	return ((int(stm)*24+pawn)*64+wk)*64 + bk
+}
+
+// probeKPK reports whether White wins with its king on (sf, sr) and pawn on (pf, pr) against the black king on
+// (wf, wr), with stm to move.
+func probeKPK(sf, sr, wf, wr, pf, pr int, stm Side) bool {
+	kpkOnce.Do(buildKPK)
+	// The bitbase only holds pawns on the a-d files, the others being their reflections.
+	if pf > 3 {
+		sf, wf, pf = 7-sf, 7-wf, 7-pf
+	}
+	idx := kpkIndex(stm, sr*8+sf, wr*8+wf, pr*8+pf)
+	return kpkWins[idx/64]&(1<<uint(idx%64)) != 0
+}
+
+// pawnAttacks returns the squares a white pawn attacks.
+func pawnAttacks(psq int) uint64 {
+	var attacks uint64
+	if f := psq % 8; f > 0 {
+		attacks |= 1 << uint(psq+7)
+	}
+	if f := psq % 8; f < 7 {
+		attacks |= 1 << uint(psq+9)
+	}
+	return attacks
+}
+
+// buildKPK works out the KPK bitbase: positions known to be won or drawn at once are classified first, then the rest
+// from the positions their moves lead to, until no more can be.
+func buildKPK() {
+	for sq := range kingMoves {
+		for to := range kingMoves {
+			if to != sq && squareDistance(sq%8, sq/8, to%8, to/8) == 1 {
+				kingMoves[sq] |= 1 << uint(to)
+			}
+		}
+	}
+
+	db := make([]uint8, 2*24*64*64)
+	for stm := White; stm <= Black; stm++ {
+		for pawn := 0; pawn < 24; pawn++ {
+			psq := (pawn/4+1)*8 + pawn%4
+			for wk := 0; wk < 64; wk++ {
+				for bk := 0; bk < 64; bk++ {
+					db[kpkIndex(stm, wk, bk, psq)] = kpkClassify(stm, wk, bk, psq)
+				}
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for idx, result := range db {
+			if result != kpkUnknown {
+				continue
+			}
+			bk, wk := idx%64, idx/64%64
+			pawn, stm := idx/4096%24, Side(idx/4096/24)
+			psq := (pawn/4+1)*8 + pawn%4
+			if result = kpkReclassify(db, stm, wk, bk, psq); result != kpkUnknown {
+				db[idx] = result
+				changed = true
+			}
+		}
+	}
+
+	kpkWins = make([]uint64, len(db)/64)
+	for idx, result := range db {
+		if result == kpkWin {
+			kpkWins[idx/64] |= 1 << uint(idx%64)
+		}
+	}
+}
+
+// kpkClassify classifies the positions which are invalid or decided at once.
+func kpkClassify(stm Side, wk, bk, psq int) uint8 {
+	switch {
+	case squareDistance(wk%8, wk/8, bk%8, bk/8) <= 1 || wk == psq || bk == psq ||
+		stm == White && pawnAttacks(psq)&(1<<uint(bk)) != 0:
+		return kpkInvalid
+	case stm == White && psq/8 == 6 && wk != psq+8 &&
+		(squareDistance(bk%8, bk/8, psq%8, 7) > 1 || squareDistance(wk%8, wk/8, psq%8, 7) == 1):
+		// The pawn promotes and the queen can't be taken.
+		return kpkWin
+	case stm == Black:
+		moves := kingMoves[bk] &^ (kingMoves[wk] | pawnAttacks(psq))
+		switch {
+		case moves == 0 && pawnAttacks(psq)&(1<<uint(bk)) != 0:
+			return kpkWin
+		case moves == 0:
+			return kpkDraw
+		case kingMoves[bk]&(1<<uint(psq)) != 0 && kingMoves[wk]&(1<<uint(psq)) == 0:
+			// The king takes the pawn.
+			return kpkDraw
+		}
+	}
+	return kpkUnknown
+}
+
+// kpkReclassify classifies a position by the positions its moves lead to: the side to move picks the best of them.
+func kpkReclassify(db []uint8, stm Side, wk, bk, psq int) uint8 {
+	var results uint8
+	if stm == White {
+		for moves := kingMoves[wk]; moves != 0; moves &= moves - 1 {
+			results |= db[kpkIndex(Black, bits.TrailingZeros64(moves), bk, psq)]
+		}
+		if psq/8 < 6 {
+			results |= db[kpkIndex(Black, wk, bk, psq+8)]
+			if psq/8 == 1 && psq+8 != wk && psq+8 != bk {
+				results |= db[kpkIndex(Black, wk, bk, psq+16)]
+			}
+		}
+		switch {
+		case results&kpkWin != 0:
+			return kpkWin
+		case results&kpkUnknown != 0:
+			return kpkUnknown
+		}
+		return kpkDraw
+	}
+
+	for moves := kingMoves[bk]; moves != 0; moves &= moves - 1 {
+		results |= db[kpkIndex(White, wk, bits.TrailingZeros64(moves), psq)]
+	}
+	switch {
+	case results&kpkDraw != 0:
+		return kpkDraw
+	case results&kpkUnknown != 0:
+		return kpkUnknown
+	}
+	return kpkWin
+}
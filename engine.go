@@ -1,12 +1,21 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"math"
 	"sort"
+	"time"
 )
 
-var numEvals = 0
+// mateScore is the evaluation of a position in which the side to move has been checkmated. Mates found deeper in the
+// search score one less per ply, so that the engine prefers the quickest mate.
+const mateScore = 1000.0
+
+// maxSearchDepth bounds iterative deepening when a search is limited only by time or nodes.
+const maxSearchDepth = 64
+
+// defaultDepth is the depth searched when no limit is given.
+const defaultDepth = 3
 
 // GameTree represents our series of calculations thus far. The tree also indexes its nodes for quick lookups.
 type GameTree struct {
@@ -21,19 +30,38 @@ type GameContext struct {
 	position Position
 	moves    []Move
 	gameTree *GameTree
+	// history holds the key of the position before each move, for the search to tell when a position repeats.
+	history []uint64
+	// start is the position the game started from, before moves.
+	start *Position
 }
 
 // NewGame creates a new chess game.
 func NewGame() *GameContext {
+	return NewGameFromPosition(NewPosition())
+}
+
+// NewGameFromPosition creates a chess game which starts from the given position.
+func NewGameFromPosition(p *Position) *GameContext {
 	gameTree := GameTree{nil, make([]*GameTree, 0), Move{}, 0}
-	return &GameContext{*NewPosition(), make([]Move, 0), &gameTree}
+	return &GameContext{*p, make([]Move, 0), &gameTree, nil, p.Copy()}
+}
+
+// startFEN returns the FEN of the position the game started from, given side, the side to move now.
+func (g *GameContext) startFEN(side Side) string {
+	if len(g.moves)%2 == 1 {
+		side = side.OppSide()
+	}
+	return g.start.FEN(side)
 }
 
 // MakeMove makes a move in the game and records it.
 func (g *GameContext) MakeMove(move Move) bool {
+	key := PolyglotKey(&g.position, g.position.board[move.oRank][move.oFile].color)
 	if ok := g.position.MakeMove(move); ok {
 		// Add move
 		g.moves = append(g.moves, move)
+		g.history = append(g.history, key)
 
 		// Throw away unused game tree.
 		for _, child := range g.gameTree.children {
@@ -47,33 +75,123 @@ func (g *GameContext) MakeMove(move Move) bool {
 	return false
 }
 
-// Evaluate uses various heuristics to create a numeric evaluation of the position.
-func Evaluate(p *Position, side Side) float64 {
-	numEvals++
-	// For now, let's play like a child. Maximize material.
-	sidePieces := p.GetPieces(side)
-	sideSum := p.SumMaterial(sidePieces)
+// SearchLimits bounds a search. A zero field places no limit. When no limits are given at all, the search stops at
+// defaultDepth.
+type SearchLimits struct {
+	Depth    int
+	Nodes    int
+	MoveTime time.Duration
+}
 
-	oppPieces := p.GetPieces(side.OppSide())
-	oppSum := p.SumMaterial(oppPieces)
+// minMoveTime is the least time budgeted for a move when playing on a clock.
+const minMoveTime = 50 * time.Millisecond
 
-	centralControl := p.CentralControl(side) * .1
-	oppCentralControl := p.CentralControl(side.OppSide()) * .1
+// ClockLimits budgets the time for a move when playing on a clock: a fortieth of the remaining time plus half the
+// increment.
+func ClockLimits(remaining, inc time.Duration) SearchLimits {
+	moveTime := remaining/40 + inc/2
+	if moveTime < minMoveTime {
+		moveTime = minMoveTime
+	}
+	if moveTime > remaining && remaining > 0 {
+		moveTime = remaining / 2
+	}
+	return SearchLimits{MoveTime: moveTime}
+}
+
+// SearchResult describes a completed iteration of the search. The score is from the perspective of the side to move.
+type SearchResult struct {
+	BestMove Move
+	Score    float64
+	Depth    int
+	Nodes    int
+	Time     time.Duration
+	PV       []Move
+}
+
+// MateIn returns the number of moves until mate when the score is a mate score. It is negative when the side to move
+// is being mated.
+func (r SearchResult) MateIn() (int, bool) {
+	if math.Abs(r.Score) < mateScore-maxSearchDepth*2 {
+		return 0, false
+	}
+	plies := int(mateScore - math.Abs(r.Score))
+	if r.Score < 0 {
+		return -(plies + 1) / 2, true
+	}
+	return (plies + 1) / 2, true
+}
+
+// searcher holds the state of a single search, so that several searches can run at once.
+type searcher struct {
+	ctx    context.Context
+	limits SearchLimits
+	eval   *EvalParams
+	net    *Network
+	nnue   *nnueState
+	// pawns caches the pawn structures of the search's positions, when it evaluates them with eval.
+	pawns *pawnTable
+	// side is the side the search is for, and contempt how much worse than even a draw is for it, in centipawns.
+	side     Side
+	contempt int
+	// keys holds the keys of the positions of the game and of the search down to the node being searched.
+	keys    []uint64
+	start   time.Time
+	nodes   int
+	stopped bool
+}
 
-	return sideSum - oppSum + centralControl - oppCentralControl
+// checkStop reports whether the search should stop because it was cancelled or has hit its limits.
+func (s *searcher) checkStop() bool {
+	if s.stopped {
+		return true
+	}
+	if s.limits.Nodes > 0 && s.nodes >= s.limits.Nodes {
+		s.stopped = true
+	} else if s.nodes%256 == 0 {
+		if s.ctx.Err() != nil || s.limits.MoveTime > 0 && time.Since(s.start) >= s.limits.MoveTime {
+			s.stopped = true
+		}
+	}
+	return s.stopped
 }
 
-// Calculate is an implementation of negaMax. Perhaps someday it will implement negaScout.
+// calculate is an implementation of negaMax. Perhaps someday it will implement negaScout.
 /* Alpha is like a higher order bestSoFar variable. For the maximizer, it is the minimum score we are assured in other branches that we have calculated in parent nodes.
 Therefore, if the minimizer in the current branch assures a worse score for us with any of its replies, we can give up on the current branch altogether as the maximizer.
 This logic is somewhat muddied by the negamax take on minimax. Alpha typically tracks the maximizer's assured score and beta typically tracks the
-minimizer's assured score. In negaMax, we negate the minimizer's result in the call to Calculate() which allows us to share the calculate function
+minimizer's assured score. In negaMax, we negate the minimizer's result in the call to calculate() which allows us to share the calculate function
 between the two. In order for alpha and beta to work, their values must match with whether the minimizer or the maximizer is evaluating. Now, when
-we pass from the maximizer to the minimizer, we give the minimizer beta as its alpha and vice versa. */
-func Calculate(p *Position, side Side, depth int, alpha, beta float64, node *GameTree) float64 {
+we pass from the maximizer to the minimizer, we give the minimizer beta as its alpha and vice versa.
+The principal variation found below this node is written to pv. */
+func (s *searcher) calculate(p *Position, side Side, depth, ply int, alpha, beta float64, node *GameTree, pv *[]Move) float64 {
+	s.nodes++
+	*pv = (*pv)[:0]
+	if s.checkStop() {
+		return 0
+	}
+
+	// Draws by repetition, the fifty-move rule or lack of material are scored by the contempt.
+	key := PolyglotKey(p, side)
+	if s.isRepetition(key, p.halfMoveClock) || p.InsufficientMaterial() {
+		return s.drawScore(side)
+	}
+	if p.halfMoveClock >= 100 {
+		// Mate on the last move before the fifty-move rule still counts.
+		if p.InCheck(side) && len(p.GetMoves(side)) == 0 {
+			return -mateScore + float64(ply)
+		}
+		return s.drawScore(side)
+	}
+
+	// The tablebase knows the outcome of small endgames better than any search.
+	if score, ok := probeTablebaseScore(p, side, ply); ok {
+		return score
+	}
+
 	// Evaluate the position if we're at the max depth.
 	if depth == 0 {
-		return Evaluate(p, side)
+		return float64(s.evaluate(p, side)) / 100
 	}
 
 	// Check if there are moves on the node. If not, retrieve them and add them to the node.
@@ -84,62 +202,160 @@ func Calculate(p *Position, side Side, depth int, alpha, beta float64, node *Gam
 		}
 	}
 
+	// With no moves we have been checkmated or stalemated.
+	if len(node.children) == 0 {
+		if p.InCheck(side) {
+			return -mateScore + float64(ply)
+		}
+		return s.drawScore(side)
+	}
+
 	// Calculate possible moves
 	bestSoFar := math.Inf(-1)
+	childPV := make([]Move, 0, depth)
 	for _, child := range node.children {
 		move := child.move
 
-		// Keep track of move details so we can roll back.
-		oldPiece := p.board[move.oRank][move.oFile]
-		capturedPiece := p.board[move.nRank][move.nFile]
-
-		p.MakeMove(move)
-		child.eval = -Calculate(p, side.OppSide(), depth-1, -beta, -alpha, child)
-		bestSoFar = math.Max(bestSoFar, child.eval)
+		undo := p.makeMove(move)
+		s.keys = append(s.keys, key)
+		child.eval = -s.calculate(p, side.OppSide(), depth-1, ply+1, -beta, -alpha, child, &childPV)
+		s.keys = s.keys[:len(s.keys)-1]
+		p.unmakeMove(undo)
+		if s.stopped {
+			return 0
+		}
 
-		// Roll back move
-		p.board[move.oRank][move.oFile] = oldPiece
-		p.board[move.nRank][move.nFile] = capturedPiece
+		if child.eval > bestSoFar {
+			bestSoFar = child.eval
+			*pv = append(append((*pv)[:0], move), childPV...)
+		}
 
 		alpha = math.Max(alpha, bestSoFar)
 		if alpha >= beta {
 			node.children = sortMoves(node.children)
 			return bestSoFar
 		}
-
-		// if depth == 1 {
-		// fmt.Printf("%v - %f\n", move, -Calculate(newPos, oppSide, depth-1))
-		// }
-		// if depth == 2 {
-		// fmt.Printf("\t%v - %f\n", move, bestSoFar)
-		// fmt.Println(newPos)
-		// }
 	}
 
 	// From possible moves, choose optimal move. Return the optimal move with its evaluation.
 	node.children = sortMoves(node.children)
 	return bestSoFar
-	// The problem isn't sorting.
-	// The problem isn't making moves in the game context.
-	// Somehow the originally calculated getMoves for the position isn't the same as the newly calculated moves for the same position.
-	// This could somehow have to do with *visualizing* the board wrong when the engine is thinking multiple ply in the future.
+}
+
+// Search finds the best move for side by iterative deepening until a limit is reached or ctx is done. report, if not
+// nil, is called after each completed depth. The result of the deepest completed depth is returned.
+func Search(ctx context.Context, g GameContext, side Side, limits SearchLimits, report func(SearchResult)) SearchResult {
+	return searchWith(ctx, g, side, limits, evalParams, evalNetwork, contempt.For(side, 0), report)
+}
+
+// searchWith searches as Search does, evaluating positions with the network net, or with the weights of params eval
+// when net is nil, and scoring draws as contempt centipawns worse than even for side.
+func searchWith(ctx context.Context, g GameContext, side Side, limits SearchLimits, eval *EvalParams, net *Network,
+	contempt int, report func(SearchResult)) SearchResult {
+	s := &searcher{ctx: ctx, limits: limits, eval: eval, net: net, side: side, contempt: contempt, start: time.Now()}
+	if net != nil {
+		s.nnue = newNNUEState(net)
+	} else {
+		s.pawns = new(pawnTable)
+	}
+
+	// In a position the tablebase covers, its move is played without searching.
+	if result, ok := tablebaseRootMove(&g.position, side); ok {
+		result.Nodes, result.Time = 1, time.Since(s.start)
+		if report != nil {
+			report(result)
+		}
+		return result
+	}
+
+	maxDepth := limits.Depth
+	if maxDepth <= 0 {
+		maxDepth = maxSearchDepth
+		if limits.Nodes == 0 && limits.MoveTime == 0 {
+			maxDepth = defaultDepth
+		}
+	}
+
+	var result SearchResult
+	for depth := 1; depth <= maxDepth; depth++ {
+		move, score, pv := s.thinkDepth(g, side, depth)
+
+		// A partial iteration is only used when we have nothing better.
+		if s.stopped && depth > 1 {
+			break
+		}
+		result = SearchResult{move, score, depth, s.nodes, time.Since(s.start), pv}
+		if report != nil {
+			report(result)
+		}
+		if _, mate := result.MateIn(); s.stopped || mate || len(g.gameTree.children) == 0 {
+			break
+		}
+	}
+	result.Nodes = s.nodes
+	result.Time = time.Since(s.start)
+	return result
+}
+
+// Player chooses moves in a game. RobChess's own search and external engines are both players.
+type Player interface {
+	// Think finds a move for side within limits. When side has no moves, the result's PV is empty.
+	Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error)
+}
+
+// EnginePlayer is a Player using RobChess's search.
+type EnginePlayer struct {
+	// Report, if not nil, is called after each completed depth.
+	Report func(SearchResult)
+	// Eval, if not nil, replaces the evaluation weights the engine was started with.
+	Eval *EvalParams
+	// Network, if not nil, replaces the network the engine was started with.
+	Network *Network
+	// Classical evaluates with the weights even when the engine was started with a network.
+	Classical bool
+	// Contempt, if not nil, replaces the contempt the engine was started with.
+	Contempt *Contempt
+	// OpponentRating is the rating of the opponent, which the contempt may depend on, or 0 when it isn't known.
+	OpponentRating int
 }
 
 // Think finds the best move according to the evaluation function.
-func Think(g GameContext, side Side) Move {
-	// Get an initial move
-	strongestMove := thinkDepth(g, side, 1)
-	for i := 2; i < 3; i++ {
-		fmt.Printf("Thinking to depth %d\n", i)
-		strongestMove = thinkDepth(g, side, i)
+func (e *EnginePlayer) Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error) {
+	eval := e.Eval
+	if eval == nil {
+		eval = evalParams
+	}
+	net := e.Network
+	if net == nil && !e.Classical {
+		net = evalNetwork
+	}
+	c := contempt
+	if e.Contempt != nil {
+		c = *e.Contempt
+	}
+	return searchWith(ctx, g, side, limits, eval, net, c.For(side, e.OpponentRating), e.Report), nil
+}
+
+// evaluate returns the static evaluation of the position for side in centipawns, from the network when the search
+// has one. Specific endings are evaluated by their own rules either way.
+func (s *searcher) evaluate(p *Position, side Side) int {
+	if p.nnue == nil {
+		return evaluateCentipawns(s.eval, s.pawns, p, side)
+	}
+	if score, ok := evaluateEndgame(p, side); ok {
+		return score
 	}
-	fmt.Printf("Evaluated %d positions\n", numEvals)
-	numEvals = 0
-	return strongestMove
+	return p.nnue.evaluate(side)
 }
 
-func thinkDepth(g GameContext, side Side, depth int) Move {
+func (s *searcher) thinkDepth(g GameContext, side Side, depth int) (Move, float64, []Move) {
 	p := g.position
+	if s.nnue != nil {
+		s.nnue.refresh(&p)
+		p.nnue = s.nnue
+	}
+	s.keys = append(s.keys[:0], g.history...)
+	key := PolyglotKey(&p, side)
 
 	// Check if there are moves on the node. If not, retrieve them and add them to the node.
 	if len(g.gameTree.children) == 0 {
@@ -148,40 +364,50 @@ func thinkDepth(g GameContext, side Side, depth int) Move {
 			g.gameTree.children = append(g.gameTree.children, &GameTree{g.gameTree, make([]*GameTree, 0), move, 0})
 		}
 	}
-
-	startMoves := make([]Move, 0)
-	for _, child := range g.gameTree.children {
-		startMoves = append(startMoves, child.move)
+	if len(g.gameTree.children) == 0 {
+		if p.InCheck(side) {
+			return Move{}, -mateScore, nil
+		}
+		return Move{}, 0, nil
 	}
-	fmt.Printf("thinkDepth start: I think my moves are %v\n", startMoves)
 
 	// Calculate possible moves
 	alpha := math.Inf(-1)
 	beta := math.Inf(1)
 	bestSoFar := math.Inf(-1)
-	var bestMoveSoFar Move
+	bestMoveSoFar := g.gameTree.children[0].move
+	var pv []Move
+	childPV := make([]Move, 0, depth)
 	for _, child := range g.gameTree.children {
 		move := child.move
 
-		// Keep track of move details so we can roll back.
-		oldPiece := p.board[move.oRank][move.oFile]
-		capturedPiece := p.board[move.nRank][move.nFile]
+		undo := p.makeMove(move)
+		s.keys = append(s.keys, key)
+		child.eval = -s.calculate(&p, side.OppSide(), depth-1, 1, -beta, -alpha, child, &childPV)
+		s.keys = s.keys[:len(s.keys)-1]
+		p.unmakeMove(undo)
+		if s.stopped {
+			break
+		}
 
-		p.MakeMove(move)
-		eval := -Calculate(&p, side.OppSide(), depth, -beta, -alpha, child)
-		if eval > bestSoFar {
+		if child.eval > bestSoFar {
 			bestMoveSoFar = move
-			bestSoFar = eval
+			bestSoFar = child.eval
+			pv = append([]Move{move}, childPV...)
 		}
 
 		alpha = math.Max(alpha, bestSoFar)
-
-		// Roll back move.
-		p.board[move.oRank][move.oFile] = oldPiece
-		p.board[move.nRank][move.nFile] = capturedPiece
+	}
+	if !s.stopped {
+		g.gameTree.children = sortMoves(g.gameTree.children)
+	}
+	if pv == nil {
+		// We were stopped before any move was searched, so we know nothing about the position.
+		bestSoFar = 0
+		pv = []Move{bestMoveSoFar}
 	}
 
-	return bestMoveSoFar
+	return bestMoveSoFar, bestSoFar, pv
 }
 
 func sortMoves(nodes []*GameTree) []*GameTree {
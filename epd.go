@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EPDOp is an operation of an EPD record, e.g. bm Qg6;
+type EPDOp struct {
+	Opcode   string
+	Operands []string
+}
+
+// EPD is a position in Extended Position Description along with its operations. The operations RobChess understands
+// are checked when the record is parsed: bm and am give best moves and moves to avoid in SAN, dm the number of moves
+// to a direct mate, id a name and c0 a comment.
+type EPD struct {
+	// FEN is the position, with the clocks given by the hmvc and fmvn operations when they're present.
+	FEN        string
+	Ops        []EPDOp
+	BestMoves  []Move
+	AvoidMoves []Move
+	DirectMate int
+}
+
+// Op returns the operands of the first operation with the opcode.
+func (e *EPD) Op(opcode string) ([]string, bool) {
+	for _, op := range e.Ops {
+		if op.Opcode == opcode {
+			return op.Operands, true
+		}
+	}
+	return nil, false
+}
+
+func (e *EPD) operand(opcode string) string {
+	operands, _ := e.Op(opcode)
+	return strings.Join(operands, " ")
+}
+
+// ID returns the record's id operation, or "" when it has none.
+func (e *EPD) ID() string {
+	return e.operand("id")
+}
+
+// Comment returns the record's c0 operation, or "" when it has none.
+func (e *EPD) Comment() string {
+	return e.operand("c0")
+}
+
+// ParseEPD parses an EPD record: the first four fields of a FEN followed by operations, each an opcode and operands
+// ending in a semicolon. Operands containing spaces or semicolons are quoted.
+func ParseEPD(line string) (*EPD, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("EPD %q: expected a position of four fields", line)
+	}
+	e := &EPD{}
+	rest := line
+	for i := 0; i < 4; i++ {
+		rest = strings.TrimSpace(rest)
+		rest = rest[len(fields[i]):]
+	}
+	ops, err := parseEPDOps(rest)
+	if err != nil {
+		return nil, err
+	}
+	e.Ops = ops
+
+	clocks := [2]string{"0", "1"}
+	if hmvc := e.operand("hmvc"); hmvc != "" {
+		clocks[0] = hmvc
+	}
+	if fmvn := e.operand("fmvn"); fmvn != "" {
+		clocks[1] = fmvn
+	}
+	p, side, err := ParseFEN(strings.Join(append(fields[:4:4], clocks[:]...), " "))
+	if err != nil {
+		return nil, err
+	}
+	e.FEN = p.FEN(side)
+
+	for _, op := range e.Ops {
+		switch op.Opcode {
+		case "bm", "am":
+			for _, san := range op.Operands {
+				move, err := p.ParseSAN(san, side)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", op.Opcode, err)
+				}
+				if op.Opcode == "bm" {
+					e.BestMoves = append(e.BestMoves, move)
+				} else {
+					e.AvoidMoves = append(e.AvoidMoves, move)
+				}
+			}
+		case "dm":
+			if len(op.Operands) != 1 {
+				return nil, fmt.Errorf("dm: expected one operand")
+			}
+			if e.DirectMate, err = strconv.Atoi(op.Operands[0]); err != nil || e.DirectMate <= 0 {
+				return nil, fmt.Errorf("dm: %q is not a number of moves", op.Operands[0])
+			}
+		}
+	}
+	return e, nil
+}
+
+// parseEPDOps splits EPD operations into opcodes and operands.
+func parseEPDOps(s string) ([]EPDOp, error) {
+	var ops []EPDOp
+	var op *EPDOp
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == ';':
+			if op == nil {
+				return nil, fmt.Errorf("EPD: empty operation")
+			}
+			op = nil
+			i++
+			continue
+		}
+
+		var token string
+		if c == '"' {
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("EPD: unterminated string")
+			}
+			token, i = s[i+1:i+1+end], i+end+2
+		} else {
+			end := strings.IndexAny(s[i:], " \t\r\n;")
+			if end < 0 {
+				end = len(s) - i
+			}
+			token, i = s[i:i+end], i+end
+		}
+
+		if op == nil {
+			ops = append(ops, EPDOp{Opcode: token})
+			op = &ops[len(ops)-1]
+		} else {
+			op.Operands = append(op.Operands, token)
+		}
+	}
+	if op != nil {
+		return nil, fmt.Errorf("EPD: operation %s is missing its semicolon", op.Opcode)
+	}
+	return ops, nil
+}
+
+// LoadEPD reads the records of an EPD file. Blank lines and lines starting with # are skipped.
+func LoadEPD(path string) ([]*EPD, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*EPD
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		e, err := ParseEPD(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		records = append(records, e)
+	}
+	return records, scanner.Err()
+}
+
+// EPDResult is the outcome of searching an EPD record.
+type EPDResult struct {
+	ID       string `json:"id"`
+	FEN      string `json:"fen"`
+	Expected string `json:"expected"`
+	Move     string `json:"move"`
+	Score    int    `json:"score"`
+	Depth    int    `json:"depth"`
+	Nodes    int    `json:"nodes"`
+	TimeMs   int64  `json:"timeMs"`
+	Solved   bool   `json:"solved"`
+	// SolvedMs and SolvedDepth are the time and depth at which the search found the solution and kept it.
+	SolvedMs    int64 `json:"solvedMs,omitempty"`
+	SolvedDepth int   `json:"solvedDepth,omitempty"`
+}
+
+// EPDReport is the outcome of running a test suite.
+type EPDReport struct {
+	Depth    int         `json:"depth,omitempty"`
+	Nodes    int         `json:"nodes,omitempty"`
+	MoveTime string      `json:"movetime,omitempty"`
+	Solved   int         `json:"solved"`
+	Total    int         `json:"total"`
+	Results  []EPDResult `json:"results"`
+}
+
+// Expected describes what a search must find to solve the record, e.g. "bm Qg6".
+func (e *EPD) Expected() string {
+	var parts []string
+	for _, opcode := range []string{"bm", "am", "dm"} {
+		if operands, ok := e.Op(opcode); ok {
+			parts = append(parts, opcode+" "+strings.Join(operands, " "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Solves reports whether a search result meets the record's expectations. A record without expectations can't be
+// solved.
+func (e *EPD) Solves(r SearchResult) bool {
+	if len(r.PV) == 0 || len(e.BestMoves) == 0 && len(e.AvoidMoves) == 0 && e.DirectMate == 0 {
+		return false
+	}
+	if len(e.BestMoves) > 0 && !containsMove(e.BestMoves, r.BestMove) || containsMove(e.AvoidMoves, r.BestMove) {
+		return false
+	}
+	if e.DirectMate > 0 {
+		if moves, mate := r.MateIn(); !mate || moves <= 0 || moves > e.DirectMate {
+			return false
+		}
+	}
+	return true
+}
+
+// RunEPD searches a record's position within limits.
+func RunEPD(ctx context.Context, e *EPD, limits SearchLimits) EPDResult {
+	p, side, _ := ParseFEN(e.FEN)
+	g := NewGameFromPosition(p)
+
+	var solvedAt *SearchResult
+	result := Search(ctx, *g, side, limits, func(r SearchResult) {
+		if !e.Solves(r) {
+			solvedAt = nil
+		} else if solvedAt == nil {
+			solvedAt = &r
+		}
+	})
+
+	out := EPDResult{ID: e.ID(), FEN: e.FEN, Expected: e.Expected(), Depth: result.Depth, Nodes: result.Nodes,
+		TimeMs: result.Time.Milliseconds(), Score: int(math.Round(result.Score * 100))}
+	if len(result.PV) > 0 {
+		out.Move = p.SAN(result.BestMove)
+	}
+	if e.Solves(result) {
+		out.Solved = true
+		if solvedAt == nil {
+			solvedAt = &result
+		}
+		out.SolvedMs, out.SolvedDepth = solvedAt.Time.Milliseconds(), solvedAt.Depth
+	}
+	return out
+}
+
+// diffEPDReports prints the records solved in one report but not the other, matching records by id, or by position
+// when they have none.
+func diffEPDReports(previous, current *EPDReport) {
+	key := func(r EPDResult) string {
+		if r.ID != "" {
+			return r.ID
+		}
+		return r.FEN
+	}
+	before := make(map[string]EPDResult)
+	for _, r := range previous.Results {
+		before[key(r)] = r
+	}
+	for _, r := range current.Results {
+		old, ok := before[key(r)]
+		switch {
+		case !ok:
+			fmt.Printf("New:          %s\n", key(r))
+		case r.Solved && !old.Solved:
+			fmt.Printf("Newly solved: %s (%s)\n", key(r), r.Move)
+		case !r.Solved && old.Solved:
+			fmt.Printf("Newly failed: %s (%s, was %s)\n", key(r), r.Move, old.Move)
+		case r.Solved && old.Solved && r.SolvedMs != old.SolvedMs:
+			fmt.Printf("Time changed: %s %dms -> %dms\n", key(r), old.SolvedMs, r.SolvedMs)
+		}
+	}
+	fmt.Printf("Solved %d/%d, previously %d/%d\n", current.Solved, current.Total, previous.Solved, previous.Total)
+}
+
+// EPDCommand runs the epd command: it searches each position of EPD test suites and reports which it solves.
+func EPDCommand(args []string) error {
+	fs := flag.NewFlagSet("epd", flag.ExitOnError)
+	depth := fs.Int("depth", 0, "depth to search each position to")
+	nodes := fs.Int("nodes", 0, "nodes to search in each position")
+	movetime := fs.Duration("movetime", 0, "time to search each position for (default 1s when no limit is given)")
+	jsonPath := fs.String("json", "", "`file` to write the report to as JSON")
+	comparePath := fs.String("compare", "", "JSON report `file` from a previous run to compare against")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("epd: no EPD files given")
+	}
+
+	limits := SearchLimits{Depth: *depth, Nodes: *nodes, MoveTime: *movetime}
+	if limits == (SearchLimits{}) {
+		limits.MoveTime = time.Second
+	}
+	report := &EPDReport{Depth: limits.Depth, Nodes: limits.Nodes}
+	if limits.MoveTime > 0 {
+		report.MoveTime = limits.MoveTime.String()
+	}
+
+	var previous *EPDReport
+	if *comparePath != "" {
+		data, err := os.ReadFile(*comparePath)
+		if err != nil {
+			return err
+		}
+		previous = &EPDReport{}
+		if err := json.Unmarshal(data, previous); err != nil {
+			return fmt.Errorf("%s: %v", *comparePath, err)
+		}
+	}
+
+	for _, path := range fs.Args() {
+		records, err := LoadEPD(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range records {
+			r := RunEPD(context.Background(), e, limits)
+			report.Results = append(report.Results, r)
+			report.Total++
+			status := "failed"
+			if r.Solved {
+				report.Solved++
+				status = fmt.Sprintf("solved at depth %d in %dms", r.SolvedDepth, r.SolvedMs)
+			}
+			name := r.ID
+			if name == "" {
+				name = r.FEN
+			}
+			line := fmt.Sprintf("%s: %s (%s), played %s at depth %d, score %d", name, status, r.Expected, r.Move,
+				r.Depth, r.Score)
+			if comment := e.Comment(); comment != "" {
+				line += " - " + comment
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Printf("Solved %d/%d\n", report.Solved, report.Total)
+
+	if previous != nil {
+		diffEPDReports(previous, report)
+	}
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*jsonPath, append(data, '\n'), 0644)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+// Score is an evaluation in centipawns for the middlegame and for the endgame. A position's evaluation interpolates
+// between the two by its phase.
+type Score struct {
+	MG int `json:"mg"`
+	EG int `json:"eg"`
+}
+
+// Add returns the sum of two scores.
+func (s Score) Add(t Score) Score {
+	return Score{s.MG + t.MG, s.EG + t.EG}
+}
+
+// Sub returns the difference of two scores.
+func (s Score) Sub(t Score) Score {
+	return Score{s.MG - t.MG, s.EG - t.EG}
+}
+
+// Mul returns the score multiplied by n.
+func (s Score) Mul(n int) Score {
+	return Score{s.MG * n, s.EG * n}
+}
+
+// Taper interpolates between the middlegame and endgame scores by the phase, which runs from 0 in a bare endgame to
+// maxPhase with all pieces on the board.
+func (s Score) Taper(phase int) int {
+	return (s.MG*phase + s.EG*(maxPhase-phase)) / maxPhase
+}
+
+// maxPhase is the phase of a position with all pieces on the board, by phaseWeights.
+const maxPhase = 24
+
+// phaseWeights is the weight of each Piece in the phase of the game. Pawns and kings don't count.
+var phaseWeights = [...]int{Pawn: 0, Rook: 2, Knight: 1, Bishop: 1, Queen: 4, King: 0}
+
+// pieceValues is the material value of each Piece.
+var pieceValues = [...]Score{
+	Pawn:   {100, 120},
+	Rook:   {500, 540},
+	Knight: {320, 300},
+	Bishop: {330, 320},
+	Queen:  {900, 950},
+	King:   {0, 0},
+}
+
+// The piece-square tables give the value of a piece on each square for the middlegame and endgame, from White's
+// perspective, with the eighth rank first so that they read like a board. Black's pieces use them reflected.
+var pieceSquareTables = [...][2][64]int{
+	Pawn: {{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}, {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		80, 80, 80, 80, 80, 80, 80, 80,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		30, 30, 30, 30, 30, 30, 30, 30,
+		15, 15, 15, 15, 15, 15, 15, 15,
+		5, 5, 5, 5, 5, 5, 5, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}},
+	Rook: {{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	}, {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		10, 10, 10, 10, 10, 10, 10, 10,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}},
+	Knight: {{
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	}, {
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	}},
+	Bishop: {{
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	}, {
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	}},
+	Queen: {{
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	}, {
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-10, 5, 10, 10, 10, 10, 5, -10,
+		-5, 5, 10, 15, 15, 10, 5, -5,
+		-5, 5, 10, 15, 15, 10, 5, -5,
+		-10, 5, 10, 10, 10, 10, 5, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	}},
+	King: {{
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	}, {
+		-50, -40, -30, -20, -20, -30, -40, -50,
+		-30, -20, -10, 0, 0, -10, -20, -30,
+		-30, -10, 20, 30, 30, 20, -10, -30,
+		-30, -10, 30, 40, 40, 30, -10, -30,
+		-30, -10, 30, 40, 40, 30, -10, -30,
+		-30, -10, 20, 30, 30, 20, -10, -30,
+		-30, -30, 0, 0, 0, 0, -30, -30,
+		-50, -30, -30, -30, -30, -30, -30, -50,
+	}},
+}
+
+// pieceSquare returns the value of a piece on the square at file f and rank r, from its own side's perspective.
+func pieceSquare(w *EvalParams, piece GamePiece, f, r int) Score {
+	sq := (7-r)*8 + f
+	if piece.color == Black {
+		sq = r*8 + f
+	}
+	tables := &w.PieceSquare[piece.piece]
+	return Score{tables[0][sq], tables[1][sq]}
+}
+
+// The terms of the evaluation, which EvaluateTrace breaks it down by.
+const (
+	termMaterial = iota
+	termPieceSquare
+	termPawns
+	termPassers
+	termKingShelter
+	termKingAttacks
+	termMobility
+	termPieces
+	numTerms
+)
+
+// termNames names the terms of the evaluation.
+var termNames = [numTerms]string{
+	termMaterial:    "Material",
+	termPieceSquare: "Piece-square",
+	termPawns:       "Pawns",
+	termPassers:     "Passed pawns",
+	termKingShelter: "King shelter",
+	termKingAttacks: "King attacks",
+	termMobility:    "Mobility",
+	termPieces:      "Pieces",
+}
+
+// EvaluateCentipawns evaluates the position for side in centipawns: material, piece placement, pawn structure, king
+// safety and piece activity, tapered between the middlegame and endgame by the material left on the board.
+func EvaluateCentipawns(p *Position, side Side) int {
+	return evaluateCentipawns(evalParams, nil, p, side)
+}
+
+// evaluateCentipawns evaluates the position for side in centipawns with the weights of params w, caching pawn
+// structures in pawns unless it's nil.
+func evaluateCentipawns(w *EvalParams, pawns *pawnTable, p *Position, side Side) int {
+	// Some endings are known better than any general rule can tell.
+	if score, ok := evaluateEndgame(p, side); ok {
+		return score
+	}
+
+	terms, phase := evaluateTerms(w, pawns, p)
+	var score Score
+	for _, term := range terms {
+		score = score.Add(term[White]).Sub(term[Black])
+	}
+	eval := score.Taper(phase) * endgameScale(p) / normalScale
+	if side == Black {
+		eval = -eval
+	}
+	return eval
+}
+
+// evaluateTerms evaluates each term of the evaluation for each side, from that side's perspective, and works out the
+// phase of the position. Pawn structures are cached in pawns unless it's nil.
+func evaluateTerms(w *EvalParams, pawns *pawnTable, p *Position) (terms [numTerms][2]Score, phase int) {
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			if piece.piece == None {
+				continue
+			}
+			terms[termMaterial][piece.color] = terms[termMaterial][piece.color].Add(w.PieceValues[piece.piece])
+			terms[termPieceSquare][piece.color] = terms[termPieceSquare][piece.color].Add(pieceSquare(w, piece, f, r))
+			phase += phaseWeights[piece.piece]
+		}
+	}
+	if phase > maxPhase {
+		// Promotions can leave more material than the game starts with.
+		phase = maxPhase
+	}
+
+	structure := pawns.probe(w, p)
+	info := newAttackInfo(p)
+	ranks := pawnRanks(p)
+	for side := White; side <= Black; side++ {
+		terms[termPawns][side] = structure.score[side]
+		terms[termPassers][side] = evaluatePassers(w, p, structure.passed[side], side)
+		terms[termKingShelter][side] = evaluateShelter(w, info, &ranks, side)
+		terms[termKingAttacks][side] = evaluateKingAttacks(w, p, info, side)
+		terms[termMobility][side], terms[termPieces][side] = evaluateActivity(w, p, info, &ranks, side)
+	}
+	return terms, phase
+}
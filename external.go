@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExternalTimeout is how long an external engine may take to answer, beyond any time it was told to think.
+const defaultExternalTimeout = 10 * time.Second
+
+// ExternalEngine is a Player backed by an engine speaking the Universal Chess Interface, run as a subprocess.
+type ExternalEngine struct {
+	// Name is the engine's name as it reports it, or its path when it reports none.
+	Name string
+	// Timeout bounds how long the engine may take to answer beyond any time it was told to think. Once it has
+	// passed, the engine is told to stop, and it is killed if it still doesn't answer.
+	Timeout time.Duration
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string // The engine's output. Closed when the engine exits.
+	exitErr error       // Set before lines is closed.
+	mu      sync.Mutex  // Held for the duration of each exchange with the engine.
+}
+
+// StartExternalEngine starts the UCI engine at path and waits for it to become ready.
+func StartExternalEngine(path string, args ...string) (*ExternalEngine, error) {
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	e := &ExternalEngine{Name: path, Timeout: defaultExternalTimeout, cmd: cmd, stdin: stdin, lines: make(chan string, 64)}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			e.lines <- scanner.Text()
+		}
+		e.exitErr = cmd.Wait()
+		close(e.lines)
+	}()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.send("uci"); err != nil {
+		e.kill()
+		return nil, err
+	}
+	err = e.waitFor(e.Timeout, func(line string) bool {
+		if strings.HasPrefix(line, "id name ") {
+			e.Name = strings.TrimPrefix(line, "id name ")
+		}
+		return line == "uciok"
+	})
+	if err == nil {
+		err = e.sync()
+	}
+	if err != nil {
+		e.kill()
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetOption sets one of the engine's options.
+func (e *ExternalEngine) SetOption(name, value string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.send("setoption name " + name + " value " + value); err != nil {
+		return err
+	}
+	return e.sync()
+}
+
+// NewGame tells the engine that the next position is from a new game.
+func (e *ExternalEngine) NewGame() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.send("ucinewgame"); err != nil {
+		return err
+	}
+	return e.sync()
+}
+
+// Think implements Player. The engine is given the game's starting position and moves, so that it can tell when
+// positions repeat. The result is built from the engine's last info line which carries a score.
+func (e *ExternalEngine) Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := time.Now()
+	if err := e.send(positionCommand(g, side)); err != nil {
+		return SearchResult{}, err
+	}
+	if err := e.send(goCommand(limits)); err != nil {
+		return SearchResult{}, err
+	}
+
+	var result SearchResult
+	timer := time.NewTimer(limits.MoveTime + e.Timeout)
+	defer timer.Stop()
+	stopped := false
+	stop := func() error {
+		if stopped {
+			e.kill()
+			return fmt.Errorf("%s did not answer after being told to stop", e.Name)
+		}
+		stopped = true
+		timer.Reset(e.Timeout)
+		return e.send("stop")
+	}
+
+	done := ctx.Done()
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return result, e.exited()
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "info":
+				parseInfo(fields[1:], &result)
+			case "bestmove":
+				result.Time = time.Since(start)
+				if len(fields) < 2 || fields[1] == "(none)" || fields[1] == "0000" {
+					result.PV = nil
+					return result, nil
+				}
+				move, ok := findLegalMove(&g.position, side, fields[1])
+				if !ok {
+					return result, fmt.Errorf("%s played illegal move %q in %s", e.Name, fields[1], g.position.FEN(side))
+				}
+				result.BestMove = move
+				if len(result.PV) == 0 || result.PV[0] != move {
+					result.PV = []Move{move}
+				}
+				return result, nil
+			}
+		case <-done:
+			// Stop only once, then wait for the engine's answer.
+			done = nil
+			if err := stop(); err != nil {
+				return result, err
+			}
+		case <-timer.C:
+			if err := stop(); err != nil {
+				return result, err
+			}
+		}
+	}
+}
+
+// Close asks the engine to quit, killing it if it doesn't.
+func (e *ExternalEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.send("quit")
+	timer := time.NewTimer(e.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-e.lines:
+			if !ok {
+				return nil
+			}
+		case <-timer.C:
+			e.kill()
+			return fmt.Errorf("%s did not quit", e.Name)
+		}
+	}
+}
+
+func (e *ExternalEngine) send(command string) error {
+	if _, err := io.WriteString(e.stdin, command+"\n"); err != nil {
+		return fmt.Errorf("writing to %s: %v", e.Name, err)
+	}
+	return nil
+}
+
+// sync waits for the engine to finish processing the commands sent so far.
+func (e *ExternalEngine) sync() error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	return e.waitFor(e.Timeout, func(line string) bool { return line == "readyok" })
+}
+
+// waitFor reads the engine's output until done returns true for a line.
+func (e *ExternalEngine) waitFor(timeout time.Duration, done func(line string) bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return e.exited()
+			}
+			if done(strings.TrimSpace(line)) {
+				return nil
+			}
+		case <-timer.C:
+			e.kill()
+			return fmt.Errorf("%s did not answer within %v", e.Name, timeout)
+		}
+	}
+}
+
+func (e *ExternalEngine) exited() error {
+	if e.exitErr != nil {
+		return fmt.Errorf("%s exited: %v", e.Name, e.exitErr)
+	}
+	return fmt.Errorf("%s exited", e.Name)
+}
+
+func (e *ExternalEngine) kill() {
+	if e.cmd.Process != nil {
+		e.cmd.Process.Kill()
+	}
+}
+
+// positionCommand builds the UCI position command for the game, with side to move.
+func positionCommand(g GameContext, side Side) string {
+	command := "position fen " + g.startFEN(side)
+	if len(g.moves) > 0 {
+		command += " moves"
+		for _, move := range g.moves {
+			command += " " + move.String()
+		}
+	}
+	return command
+}
+
+// goCommand builds the UCI go command for limits.
+func goCommand(limits SearchLimits) string {
+	command := "go"
+	if limits.Depth > 0 {
+		command += " depth " + strconv.Itoa(limits.Depth)
+	}
+	if limits.Nodes > 0 {
+		command += " nodes " + strconv.Itoa(limits.Nodes)
+	}
+	if limits.MoveTime > 0 {
+		command += " movetime " + strconv.FormatInt(limits.MoveTime.Milliseconds(), 10)
+	}
+	if command == "go" {
+		command += " depth " + strconv.Itoa(defaultDepth)
+	}
+	return command
+}
+
+// parseInfo reads the fields of a UCI info line into result. Lines without a score, such as "info string", and lines
+// for secondary variations are ignored.
+func parseInfo(fields []string, result *SearchResult) {
+	var info SearchResult
+	hasScore := false
+	for i := 0; i < len(fields); i++ {
+		next := func() int {
+			if i+1 >= len(fields) {
+				return 0
+			}
+			i++
+			n, _ := strconv.Atoi(fields[i])
+			return n
+		}
+		switch fields[i] {
+		case "string":
+			return
+		case "multipv":
+			if next() > 1 {
+				return
+			}
+		case "depth":
+			info.Depth = next()
+		case "nodes":
+			info.Nodes = next()
+		case "score":
+			if i+2 >= len(fields) {
+				return
+			}
+			kind := fields[i+1]
+			i++
+			n := next()
+			switch kind {
+			case "cp":
+				info.Score = float64(n) / 100
+			case "mate":
+				if n > 0 {
+					info.Score = mateScore - float64(2*n-1)
+				} else {
+					info.Score = -mateScore + float64(-2*n)
+				}
+			default:
+				return
+			}
+			hasScore = true
+		case "pv":
+			for _, moveStr := range fields[i+1:] {
+				move, ok := algebraicToMove(moveStr)
+				if !ok {
+					break
+				}
+				info.PV = append(info.PV, move)
+			}
+			i = len(fields)
+		}
+	}
+	if !hasScore {
+		return
+	}
+	if len(info.PV) > 0 {
+		info.BestMove = info.PV[0]
+	}
+	*result = info
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEngineFlag makes the test binary run as a fake UCI engine instead of running the tests, with the behavior named
+// by the argument after it.
+const fakeEngineFlag = "-fake-uci-engine"
+
+func TestMain(m *testing.M) {
+	for i, arg := range os.Args {
+		if arg == fakeEngineFlag && i+1 < len(os.Args) {
+			runFakeEngine(os.Args[i+1])
+			os.Exit(0)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeEngine speaks just enough UCI for ExternalEngine. It plays the first legal move and reports the number of
+// moves it was given after the position as its score in centipawns, so that tests can see the game history arrive.
+// The behaviors break it in different ways once it's told to go: "hang" never answers, "crash" exits, and "illegal"
+// and "garbage" answer with a move which can't be played.
+func runFakeEngine(behavior string) {
+	var p *Position
+	var side Side
+	var moves int
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name Fake Engine")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "position":
+			// position fen <six fields> [moves ...]
+			fen := strings.Join(fields[2:8], " ")
+			var err error
+			if p, side, err = ParseFEN(fen); err != nil {
+				os.Exit(2)
+			}
+			moves = 0
+			if len(fields) > 9 {
+				for _, moveStr := range fields[9:] {
+					move, ok := findLegalMove(p, side, moveStr)
+					if !ok {
+						os.Exit(2)
+					}
+					p.makeMove(move)
+					side = side.OppSide()
+					moves++
+				}
+			}
+		case "go":
+			switch behavior {
+			case "hang":
+				continue
+			case "crash":
+				os.Exit(3)
+			case "illegal":
+				fmt.Println("bestmove e2e5")
+				continue
+			case "garbage":
+				fmt.Println("bestmove banana")
+				continue
+			}
+			move := p.GetMoves(side)[0]
+			fmt.Printf("info depth 1 score cp %d pv %v\n", moves, move)
+			fmt.Printf("bestmove %v\n", move)
+		case "quit":
+			return
+		}
+	}
+}
+
+// startFakeEngine starts the test binary as a fake engine with the given behavior.
+func startFakeEngine(t *testing.T, behavior string) *ExternalEngine {
+	e, err := StartExternalEngine(os.Args[0], fakeEngineFlag, behavior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Timeout = 200 * time.Millisecond
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestExternalEngineHandshake(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+	if e.Name != "Fake Engine" {
+		t.Errorf("name %q, want %q", e.Name, "Fake Engine")
+	}
+	if err := e.NewGame(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalEngineGetsHistory(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+	g := NewGame()
+	side := White
+	for _, moveStr := range []string{"g1f3", "g8f6", "f3g1"} {
+		move, _ := findLegalMove(&g.position, side, moveStr)
+		g.MakeMove(move)
+		side = side.OppSide()
+	}
+	result, err := e.Think(context.Background(), *g, side, SearchLimits{Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Score != 0.03 {
+		t.Errorf("the engine was given %v moves, want 3", result.Score*100)
+	}
+	if !containsMove(g.position.GetMoves(side), result.BestMove) {
+		t.Errorf("best move %v isn't legal", result.BestMove)
+	}
+}
+
+func TestExternalEngineFailures(t *testing.T) {
+	for _, behavior := range []string{"hang", "crash", "illegal", "garbage"} {
+		t.Run(behavior, func(t *testing.T) {
+			e := startFakeEngine(t, behavior)
+			start := time.Now()
+			_, err := e.Think(context.Background(), *NewGame(), White, SearchLimits{Depth: 1})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %v to fail", elapsed)
+			}
+			t.Log(err)
+			if behavior == "hang" {
+				// The engine ignored stop, so it must have been killed.
+				select {
+				case _, ok := <-e.lines:
+					for ok {
+						_, ok = <-e.lines
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("the hung engine is still running")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StartFEN is the Forsyth-Edwards Notation for the starting position.
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// fenPieces holds the FEN letter for each Piece, indexed by the Piece's value. White's letters are upper case.
+const fenPieces = "PRNBQK"
+
+// ParseFEN parses a position in Forsyth-Edwards Notation. It returns the position along with the side to move. The
+// halfmove clock and fullmove number may be omitted, in which case they default to 0 and 1.
+func ParseFEN(fen string) (*Position, Side, error) {
+	fields := strings.Fields(fen)
+	if len(fields) != 4 && len(fields) != 6 {
+		return nil, White, fmt.Errorf("fen %q: expected 4 or 6 fields, found %d", fen, len(fields))
+	}
+
+	p := NewPosition()
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, White, fmt.Errorf("fen %q: expected 8 ranks, found %d", fen, len(ranks))
+	}
+	kings := [2]int{}
+	for i, rankStr := range ranks {
+		// FEN lists ranks from the eighth down to the first.
+		r := 7 - i
+		f := 0
+		for _, c := range rankStr {
+			if f > 7 || c >= '1' && c <= '8' && f+int(c-'0') > 8 {
+				return nil, White, fmt.Errorf("fen %q: rank %d describes more than 8 squares", fen, r+1)
+			}
+			if c >= '1' && c <= '8' {
+				for n := 0; n < int(c-'0'); n++ {
+					p.board[r][f] = GamePiece{None, White}
+					f++
+				}
+				continue
+			}
+			idx := strings.IndexRune(fenPieces, c)
+			color := White
+			if idx < 0 {
+				idx = strings.IndexRune(strings.ToLower(fenPieces), c)
+				color = Black
+			}
+			if idx < 0 {
+				return nil, White, fmt.Errorf("fen %q: unknown piece %q", fen, c)
+			}
+			if Piece(idx) == Pawn && (r == 0 || r == 7) {
+				return nil, White, fmt.Errorf("fen %q: pawn on rank %d", fen, r+1)
+			}
+			p.board[r][f] = GamePiece{Piece(idx), color}
+			if Piece(idx) == King {
+				kings[color]++
+			}
+			f++
+		}
+		if f != 8 {
+			return nil, White, fmt.Errorf("fen %q: rank %d does not describe 8 squares", fen, r+1)
+		}
+	}
+	if kings[White] != 1 || kings[Black] != 1 {
+		return nil, White, fmt.Errorf("fen %q: each side must have exactly one king", fen)
+	}
+
+	var side Side
+	switch fields[1] {
+	case "w":
+		side = White
+	case "b":
+		side = Black
+	default:
+		return nil, White, fmt.Errorf("fen %q: unknown side to move %q", fen, fields[1])
+	}
+	if p.InCheck(side.OppSide()) {
+		return nil, White, fmt.Errorf("fen %q: the side not to move is in check", fen)
+	}
+
+	p.canCastleLongWhite, p.canCastleShortWhite = false, false
+	p.canCastleLongBlack, p.canCastleShortBlack = false, false
+	if fields[2] != "-" {
+		for _, c := range fields[2] {
+			switch c {
+			case 'K':
+				p.canCastleShortWhite = true
+			case 'Q':
+				p.canCastleLongWhite = true
+			case 'k':
+				p.canCastleShortBlack = true
+			case 'q':
+				p.canCastleLongBlack = true
+			default:
+				return nil, White, fmt.Errorf("fen %q: unknown castling right %q", fen, c)
+			}
+		}
+	}
+
+	p.enPassant = noSquare
+	if fields[3] != "-" {
+		square, ok := parseSquare(fields[3])
+		if !ok {
+			return nil, White, fmt.Errorf("fen %q: invalid en passant square %q", fen, fields[3])
+		}
+		p.enPassant = square
+	}
+
+	p.halfMoveClock, p.fullMoveNumber = 0, 1
+	if len(fields) == 6 {
+		var err error
+		if p.halfMoveClock, err = strconv.Atoi(fields[4]); err != nil || p.halfMoveClock < 0 {
+			return nil, White, fmt.Errorf("fen %q: invalid halfmove clock %q", fen, fields[4])
+		}
+		if p.fullMoveNumber, err = strconv.Atoi(fields[5]); err != nil || p.fullMoveNumber < 1 {
+			return nil, White, fmt.Errorf("fen %q: invalid fullmove number %q", fen, fields[5])
+		}
+	}
+
+	return p, side, nil
+}
+
+// FEN returns the Forsyth-Edwards Notation for the position with side to move.
+func (p *Position) FEN(side Side) string {
+	var sb strings.Builder
+	for r := 7; r >= 0; r-- {
+		empty := 0
+		for f := 0; f < 8; f++ {
+			piece := p.board[r][f]
+			if piece.piece == None {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			letter := fenPieces[piece.piece : piece.piece+1]
+			if piece.color == Black {
+				letter = strings.ToLower(letter)
+			}
+			sb.WriteString(letter)
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+		if r > 0 {
+			sb.WriteString("/")
+		}
+	}
+
+	if side == White {
+		sb.WriteString(" w ")
+	} else {
+		sb.WriteString(" b ")
+	}
+
+	castling := ""
+	if p.canCastleShortWhite {
+		castling += "K"
+	}
+	if p.canCastleLongWhite {
+		castling += "Q"
+	}
+	if p.canCastleShortBlack {
+		castling += "k"
+	}
+	if p.canCastleLongBlack {
+		castling += "q"
+	}
+	if castling == "" {
+		castling = "-"
+	}
+	sb.WriteString(castling)
+
+	if p.enPassant == noSquare {
+		sb.WriteString(" -")
+	} else {
+		sb.WriteString(" " + p.enPassant.String())
+	}
+
+	sb.WriteString(fmt.Sprintf(" %d %d", p.halfMoveClock, p.fullMoveNumber))
+	return sb.String()
+}
+
+// parseSquare parses a square name such as "e4".
+func parseSquare(s string) (Square, bool) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return noSquare, false
+	}
+	return Square{int(s[0] - 'a'), int(s[1] - '1')}, true
+}
+
+func (s Square) String() string {
+	return string(rune('a'+s.file)) + string(rune('1'+s.rank))
+}
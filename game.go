@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -8,37 +9,56 @@ import (
 
 var moveExp = *regexp.MustCompile("(?P<file1>[a-h])(?P<rank1>[1-8])(?P<file2>[a-h])(?P<rank2>[1-8])(?P<promotionPiece>[bnrq])?")
 
-// StartUserSession initiates a chess game with RobChess.
-func StartUserSession() {
-	fmt.Println("Welcome to RobChess! When entering moves, please use long algebraic chess notation.")
+// StartUserSession initiates a chess game with RobChess, or with another engine when opponent is not nil. When book is
+// not nil, the opponent plays its moves while the game is in the book.
+func StartUserSession(opponent Player, book *OpeningBook) {
+	fmt.Println("Welcome to RobChess! When entering moves, use standard (Nf3) or long (g1f3) algebraic notation.")
 
+	if opponent == nil {
+		opponent = &EnginePlayer{Report: func(r SearchResult) {
+			fmt.Printf("Thought to depth %d: %.2f %v\n", r.Depth, r.Score, r.PV)
+		}, OpponentRating: *opponentRating}
+	}
+	if book != nil {
+		opponent = &BookPlayer{opponent, book}
+	}
 	game := *NewGame()
 	side := promptColor()
 
 	fmt.Println(game.position)
-	gameLoop(White, side, game)
+	gameLoop(White, side, game, opponent)
 }
 
-func gameLoop(side Side, playerSide Side, g GameContext) {
+func gameLoop(side Side, playerSide Side, g GameContext, opponent Player) {
 	var oppSide = side.OppSide()
 
 	if side == playerSide {
-		move := readMove()
+		move := readMove(&g.position, side)
 		if ok := g.MakeMove(move); !ok {
 			fmt.Printf("Something went wrong processing move: %+v\n", move)
 			return
 		}
 		fmt.Println(g.position)
-		gameLoop(oppSide, playerSide, g)
+		gameLoop(oppSide, playerSide, g, opponent)
 	} else {
 		fmt.Printf("I think my moves are %v\n", g.position.GetMoves(side))
-		engineMove := Think(g, side)
-		fmt.Printf("Engine Move: %v\n", engineMove)
+		result, err := opponent.Think(context.Background(), g, side, SearchLimits{Depth: defaultDepth})
+		if err != nil {
+			fmt.Printf("Something went wrong thinking: %v\n", err)
+			return
+		}
+		if len(result.PV) == 0 {
+			fmt.Println("Game over.")
+			return
+		}
+		engineMove := result.BestMove
+		fmt.Printf("Evaluated %d positions\n", result.Nodes)
+		fmt.Printf("Engine Move: %s\n", g.position.SAN(engineMove))
 		g.MakeMove(engineMove)
-		fmt.Printf("Moves so far: %v\n", g.moves)
+		fmt.Printf("Moves so far: %s\n", Movetext(NewPosition(), White, g.moves))
 		fmt.Println(g.position)
 		fmt.Printf("I think your moves are %v\n", g.position.GetMoves(oppSide))
-		gameLoop(oppSide, playerSide, g)
+		gameLoop(oppSide, playerSide, g, opponent)
 	}
 }
 
@@ -57,14 +77,17 @@ func promptColor() Side {
 		return promptColor()
 	}
 }
-func readMove() Move {
+
+// readMove reads the player's move, in standard or long algebraic notation, until they enter a legal one.
+func readMove(p *Position, side Side) Move {
 	fmt.Print("Move: ")
 	moveStr := scanMove()
-	if move, ok := algebraicToMove(moveStr); ok {
+	move, err := p.ParseSAN(moveStr, side)
+	if err == nil {
 		return move
 	}
-	fmt.Println("The move entered could not be understood. Please enter a move in long algrebraic chess notation.")
-	return readMove()
+	fmt.Printf("The move entered could not be understood: %v. Please enter a legal move, e.g. Nf3 or g1f3.\n", err)
+	return readMove(p, side)
 }
 
 func scanMove() string {
@@ -111,7 +134,6 @@ type Move struct {
 
 // NewMove creates and initializes a new Move object.
 func NewMove(oFile, oRank, nFile, nRank int, promoPiece string) (Move, bool) {
-	fmt.Println(oFile, oRank, nFile, nRank, promoPiece)
 	if oRank < 0 || oFile > 7 ||
 		nRank < 0 || nFile > 7 {
 		return Move{}, false
@@ -126,9 +148,5 @@ func NewMove(oFile, oRank, nFile, nRank int, promoPiece string) (Move, bool) {
 }
 
 func (m Move) String() string {
-	oFile := string(m.oFile + 48 + 48 + 1)
-	oRank := string(m.oRank + 48 + 1)
-	nFile := string(m.nFile + 48 + 48 + 1)
-	nRank := string(m.nRank + 48 + 1)
-	return oFile + oRank + nFile + nRank + m.promoPiece
+	return Square{m.oFile, m.oRank}.String() + Square{m.nFile, m.nRank}.String() + m.promoPiece
 }
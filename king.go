@@ -0,0 +1,132 @@
+package main
+
+import "math/bits"
+
+// The pawn shelter terms, for the middlegame and endgame. pawnShield is indexed by how many ranks ahead of the king
+// its nearest pawn on a file is, 0 being none within three. pawnStorm is indexed by the same for the nearest enemy
+// pawn, and blockedStorm is used instead when one of the king's pawns stands in front of it.
+var (
+	pawnShield       = [4]Score{{-36, 0}, {0, 0}, {-10, 0}, {-24, 0}}
+	pawnStorm        = [4]Score{{}, {-10, 0}, {-40, -5}, {-20, 0}}
+	blockedStorm     = [4]Score{{}, {}, {-12, 0}, {-6, 0}}
+	kingSemiOpenFile = Score{-14, 0}
+	kingOpenFile     = Score{-28, -5}
+)
+
+// attackWeight is the attack units an enemy piece adds for each square of the king zone it attacks, and safeCheck
+// those a piece adds when it can give check on a square which isn't defended.
+var (
+	attackWeight = [...]int{Pawn: 0, Rook: 3, Knight: 2, Bishop: 2, Queen: 5, King: 0}
+	safeCheck    = [...]int{Pawn: 0, Rook: 8, Knight: 8, Bishop: 5, Queen: 7, King: 0}
+)
+
+// The penalty for attacks on the king grows with the square of the attack units divided by kingDangerDivisor, up to
+// maxKingDanger.
+const (
+	kingDangerDivisor = 4
+	maxKingDanger     = 500
+)
+
+// kingDanger returns the penalty for the attack units against a king.
+func kingDanger(w *EvalParams, units int) Score {
+	return Score{-minInt(units*units/w.KingDangerDivisor, w.MaxKingDanger), -units}
+}
+
+// kingZone returns the squares around the king on (f, r) and the three in front of those, which attacks on make the
+// king unsafe.
+func kingZone(f, r int, side Side) uint64 {
+	zone := stepAttacks(f, r, kingSteps[:]) | squareBit(f, r)
+	ahead := r + 2
+	if side == Black {
+		ahead = r - 2
+	}
+	for af := f - 1; af <= f+1; af++ {
+		if onBoard(af, ahead) {
+			zone |= squareBit(af, ahead)
+		}
+	}
+	return zone
+}
+
+// evaluateShelter evaluates the pawns sheltering side's king and storming it, on the king's file and those either
+// side of it, and the files among them which are open. The score is from side's perspective.
+func evaluateShelter(w *EvalParams, info *attackInfo, ranks *[2][8]uint8, side Side) Score {
+	opp := side.OppSide()
+	kf, kr := info.king[side][0], info.king[side][1]
+	ahead := ranksAhead(side, kr)
+	var score Score
+	for f := maxInt(kf-1, 0); f <= minInt(kf+1, 7); f++ {
+		own := nearestRank(ranks[side][f]&ahead, side)
+		shield := 0
+		if own >= 0 && absInt(own-kr) < len(w.PawnShield) {
+			shield = absInt(own - kr)
+		}
+		score = score.Add(w.PawnShield[shield])
+
+		if enemy := nearestRank(ranks[opp][f]&ahead, side); enemy >= 0 && absInt(enemy-kr) < len(w.PawnStorm) {
+			storm := absInt(enemy - kr)
+			if own >= 0 && absInt(own-kr) == storm-1 {
+				score = score.Add(w.BlockedStorm[storm])
+			} else {
+				score = score.Add(w.PawnStorm[storm])
+			}
+		}
+
+		switch {
+		case ranks[side][f] == 0 && ranks[opp][f] == 0:
+			score = score.Add(w.KingOpenFile)
+		case ranks[side][f] == 0:
+			score = score.Add(w.KingSemiOpenFile)
+		}
+	}
+	return score
+}
+
+// nearestRank returns the rank of the mask nearest to side's first rank, or -1 for an empty mask.
+func nearestRank(mask uint8, side Side) int {
+	if mask == 0 {
+		return -1
+	}
+	if side == White {
+		return bits.TrailingZeros8(mask)
+	}
+	return 7 - bits.LeadingZeros8(mask)
+}
+
+// evaluateKingAttacks counts attack units against side's king: for each enemy piece attacking the king zone, by the
+// kind of piece and the squares it attacks, once two pieces take part in the attack, and for each kind of piece
+// which can give a safe check. The score is from side's perspective.
+func evaluateKingAttacks(w *EvalParams, p *Position, info *attackInfo, side Side) Score {
+	opp := side.OppSide()
+	kf, kr := info.king[side][0], info.king[side][1]
+	zone := kingZone(kf, kr, side)
+
+	units, attackers := 0, 0
+	for i := 0; i < info.count[opp]; i++ {
+		attacker := &info.pieces[opp][i]
+		if attacks := attacker.attacks & zone; attacks != 0 {
+			attackers++
+			units += w.AttackWeight[attacker.piece] * bits.OnesCount64(attacks)
+		}
+	}
+	if attackers < 2 {
+		units = 0
+	}
+
+	// The squares the enemy can check from without the piece being taken.
+	safe := ^(info.all[side] | info.occupied[opp])
+	diagonals := slideAttacks(p, kf, kr, bishopDirection[:])
+	lines := slideAttacks(p, kf, kr, rookDirection[:])
+	checks := [...]uint64{
+		Knight: stepAttacks(kf, kr, knightSteps[:]),
+		Bishop: diagonals,
+		Rook:   lines,
+		Queen:  diagonals | lines,
+	}
+	for piece, squares := range checks {
+		if squares&info.byPiece[opp][piece]&safe != 0 {
+			units += w.SafeCheck[piece]
+		}
+	}
+	return kingDanger(w, units)
+}
@@ -2,15 +2,37 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
-	_ "net/http/pprof"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"time"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+var serve = flag.String("serve", "", "serve the HTTP/JSON API on `address` (e.g. :8080) instead of starting a game")
+var serveTimeout = flag.Duration("serve-timeout", 30*time.Second, "maximum time spent on any one HTTP request")
+var botURL = flag.String("bot", "", "play as a bot on the Lichess-style platform at `url` (e.g. https://lichess.org)")
+var botID = flag.String("bot-id", "robchess", "account id of the bot")
+var botToken = flag.String("bot-token", os.Getenv("ROBCHESS_BOT_TOKEN"), "API token of the bot account (default $ROBCHESS_BOT_TOKEN)")
+var engineCmd = flag.String("engine", "", "play against the UCI engine started by `command` instead of RobChess")
+var botReplay = flag.String("bot-replay", "", "run the bot offline against the recorded streams in `dir`")
+var bookPath = flag.String("book", "", "play openings from the Polyglot book `file` in games, as a bot and when serving")
+var bookDepth = flag.Int("book-depth", 0, "stop using the book after this many plies (0 for no limit)")
+var bookBest = flag.Bool("book-best", false, "play the book's highest weighted move instead of a weighted random one")
+var tablesPath = flag.String("tables", "", "probe the endgame tables generated by tbgen in `dir`")
+var evalParamsPath = flag.String("evalparams", "", "evaluate with the weights in the JSON `file`")
+var dumpEvalParams = flag.String("dump-evalparams", "", "write the evaluation weights to the JSON `file` and exit")
+var nnuePath = flag.String("nnue", "", "evaluate with the neural network in `file` instead of the classical evaluation")
+var contemptFlag = flag.String("contempt", "0", "centipawns worse than even the engine judges a draw, for both sides or as white:black")
+var rating = flag.Int("rating", 0, "the engine's own rating, for contempt depending on the opponent's")
+var contemptElo = flag.Int("contempt-elo", 0, "contempt added for every 100 points an opponent is rated below -rating")
+var opponentRating = flag.Int("opponent-rating", 0, "rating of the player the engine plays on the command line")
+var analysisMode = flag.Bool("analysis", false, "score draws as even for both sides, whatever the contempt")
+var syzygyPath = flag.String("syzygy", "", "probe the Syzygy tablebases in `dir`")
 
 func main() {
 	flag.Parse()
@@ -22,7 +44,82 @@ func main() {
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
-	StartUserSession()
+	var book *OpeningBook
+	if *bookPath != "" {
+		var err error
+		if book, err = LoadBook(*bookPath); err != nil {
+			log.Fatal(err)
+		}
+		book.MaxPly, book.Best = *bookDepth, *bookBest
+	}
+	if *evalParamsPath != "" {
+		params, err := LoadEvalParams(*evalParamsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		UseEvalParams(params)
+	}
+	if *nnuePath != "" {
+		net, err := LoadNetwork(*nnuePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		UseNetwork(net)
+	}
+	c := Contempt{Rating: *rating, PerHundredElo: *contemptElo, Analysis: *analysisMode}
+	if err := c.SetSides(*contemptFlag); err != nil {
+		log.Fatal(err)
+	}
+	UseContempt(c)
+	if *dumpEvalParams != "" {
+		if err := evalParams.Save(*dumpEvalParams); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *syzygyPath != "" {
+		tb, err := OpenSyzygy(*syzygyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Found %d Syzygy tables of up to %d pieces", len(tb.WDL), tb.MaxPieces())
+		UseTablebase(tb)
+	}
+	if *tablesPath != "" {
+		if *syzygyPath != "" {
+			log.Fatal("-tables and -syzygy can't be used together")
+		}
+		tb, err := OpenRetrogradeTables(*tablesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		UseTablebase(tb)
+	}
+
+	switch {
+	case flag.Arg(0) != "":
+		if err := runCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+	case *serve != "":
+		log.Fatal(Serve(*serve, *serveTimeout, book))
+	case *botURL != "":
+		log.Fatal(RunBot(*botURL, *botToken, *botID, book))
+	case *botReplay != "":
+		if err := ReplayBot(*botReplay, *botID); err != nil {
+			log.Fatal(err)
+		}
+	case *engineCmd != "":
+		args := strings.Fields(*engineCmd)
+		engine, err := StartExternalEngine(args[0], args[1:]...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer engine.Close()
+		StartUserSession(engine, book)
+	default:
+		StartUserSession(nil, book)
+	}
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -36,3 +133,33 @@ func main() {
 		f.Close()
 	}
 }
+
+// runCommand runs one of the commands given after the flags, e.g. "RobChess match -games 10".
+func runCommand(name string, args []string) error {
+	switch name {
+	case "match":
+		return MatchCommand(args)
+	case "epd":
+		return EPDCommand(args)
+	case "makebook":
+		return MakeBookCommand(args)
+	case "sprt":
+		return SPRTCommand(args)
+	case "tournament":
+		return TournamentCommand(args)
+	case "tbgen":
+		return TBGenCommand(args)
+	case "tbverify":
+		return TBVerifyCommand(args)
+	case "eval":
+		return EvalCommand(args)
+	case "tune":
+		return TuneCommand(args)
+	case "nnue":
+		return NNUECommand(args)
+	case "datagen":
+		return DatagenCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BookBuilder collects the moves played in games into an opening book.
+type BookBuilder struct {
+	// MinRating skips games unless both players are rated at least this much.
+	MinRating int
+	// Results holds the results of the games to include. When empty, games with any result but "*" are included.
+	Results []string
+	// MinPly skips games shorter than this many plies, and MaxPly stops collecting moves after that many plies.
+	MinPly, MaxPly int
+	// Player, when not empty, only collects the moves played by the player of that name, e.g. for a student's
+	// repertoire.
+	Player string
+
+	positions map[uint64]*bookPosition
+}
+
+// bookPosition is a position of a book being built.
+type bookPosition struct {
+	FEN   string
+	Ply   int
+	Moves map[uint16]*BookMoveStats
+}
+
+// BookMoveStats describes how a move fared in the games it was played in, from the perspective of the side playing
+// it.
+type BookMoveStats struct {
+	SAN    string `json:"san"`
+	UCI    string `json:"uci"`
+	Weight int    `json:"weight"`
+	Games  int    `json:"games"`
+	Wins   int    `json:"wins"`
+	Draws  int    `json:"draws"`
+	Losses int    `json:"losses"`
+}
+
+// accepts reports whether a game passes the builder's filters.
+func (b *BookBuilder) accepts(game *PGNGame) bool {
+	if game.Result == "*" || len(b.Results) > 0 && !containsString(b.Results, game.Result) {
+		return false
+	}
+	if len(game.Moves) < b.MinPly {
+		return false
+	}
+	if b.MinRating > 0 {
+		for _, tag := range []string{"WhiteElo", "BlackElo"} {
+			if rating, err := strconv.Atoi(game.Tag(tag)); err != nil || rating < b.MinRating {
+				return false
+			}
+		}
+	}
+	if b.Player != "" && game.Tag("White") != b.Player && game.Tag("Black") != b.Player {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Add collects the moves of a game, reporting whether the game passed the filters.
+func (b *BookBuilder) Add(game *PGNGame) bool {
+	if !b.accepts(game) {
+		return false
+	}
+	p, side, err := game.StartPosition()
+	if err != nil {
+		return false
+	}
+	if b.positions == nil {
+		b.positions = make(map[uint64]*bookPosition)
+	}
+
+	for ply, move := range game.Moves {
+		if b.MaxPly > 0 && ply >= b.MaxPly {
+			break
+		}
+		mover := game.Tag("White")
+		if side == Black {
+			mover = game.Tag("Black")
+		}
+		if b.Player == "" || mover == b.Player {
+			key := PolyglotKey(p, side)
+			pos := b.positions[key]
+			if pos == nil {
+				pos = &bookPosition{FEN: p.FEN(side), Ply: ply, Moves: make(map[uint16]*BookMoveStats)}
+				b.positions[key] = pos
+			}
+			encoded := encodePolyglotMove(p, move)
+			stats := pos.Moves[encoded]
+			if stats == nil {
+				stats = &BookMoveStats{SAN: p.SAN(move), UCI: move.String()}
+				pos.Moves[encoded] = stats
+			}
+			stats.Games++
+			switch {
+			case game.Result == "1/2-1/2":
+				stats.Draws++
+			case game.Result == "1-0" && side == White, game.Result == "0-1" && side == Black:
+				stats.Wins++
+			default:
+				stats.Losses++
+			}
+		}
+		p.makeMove(move)
+		side = side.OppSide()
+	}
+	return true
+}
+
+// weigh sets the weight of each move the way Polyglot does, two points per win and one per draw, scaled down if need
+// be to fit the book's 16-bit weights.
+func (b *BookBuilder) weigh() {
+	max := 0
+	for _, pos := range b.positions {
+		for _, stats := range pos.Moves {
+			stats.Weight = 2*stats.Wins + stats.Draws
+			if stats.Weight > max {
+				max = stats.Weight
+			}
+		}
+	}
+	if max > 0xffff {
+		for _, pos := range b.positions {
+			for _, stats := range pos.Moves {
+				stats.Weight = stats.Weight * 0xffff / max
+			}
+		}
+	}
+}
+
+// sortedMoves returns a position's moves in order of decreasing weight, then of decreasing games.
+func (pos *bookPosition) sortedMoves() []uint16 {
+	moves := make([]uint16, 0, len(pos.Moves))
+	for move := range pos.Moves {
+		moves = append(moves, move)
+	}
+	sort.Slice(moves, func(i, j int) bool {
+		a, b := pos.Moves[moves[i]], pos.Moves[moves[j]]
+		if a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return moves[i] < moves[j]
+	})
+	return moves
+}
+
+// sortedKeys returns the keys of the book's positions in increasing order, as a Polyglot book is sorted.
+func (b *BookBuilder) sortedKeys() []uint64 {
+	keys := make([]uint64, 0, len(b.positions))
+	for key := range b.positions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// WritePolyglot writes the book in Polyglot's .bin format.
+func (b *BookBuilder) WritePolyglot(w io.Writer) error {
+	b.weigh()
+	bw := bufio.NewWriter(w)
+	entry := make([]byte, polyglotEntrySize)
+	for _, key := range b.sortedKeys() {
+		pos := b.positions[key]
+		for _, move := range pos.sortedMoves() {
+			binary.BigEndian.PutUint64(entry, key)
+			binary.BigEndian.PutUint16(entry[8:], move)
+			binary.BigEndian.PutUint16(entry[10:], uint16(pos.Moves[move].Weight))
+			binary.BigEndian.PutUint32(entry[12:], 0)
+			bw.Write(entry)
+		}
+	}
+	return bw.Flush()
+}
+
+// bookDumpPosition is a position of a book in a JSON dump.
+type bookDumpPosition struct {
+	FEN   string          `json:"fen"`
+	Key   string          `json:"key"`
+	Ply   int             `json:"ply"`
+	Moves []BookMoveStats `json:"moves"`
+}
+
+// dump returns the book's positions in the order of the ply they were first seen at, then of their FEN.
+func (b *BookBuilder) dump() []bookDumpPosition {
+	b.weigh()
+	positions := make([]bookDumpPosition, 0, len(b.positions))
+	for key, pos := range b.positions {
+		dumped := bookDumpPosition{FEN: pos.FEN, Key: fmt.Sprintf("%016x", key), Ply: pos.Ply}
+		for _, move := range pos.sortedMoves() {
+			dumped.Moves = append(dumped.Moves, *pos.Moves[move])
+		}
+		positions = append(positions, dumped)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Ply != positions[j].Ply {
+			return positions[i].Ply < positions[j].Ply
+		}
+		return positions[i].FEN < positions[j].FEN
+	})
+	return positions
+}
+
+// WriteJSON writes the book's positions and the statistics of their moves as JSON.
+func (b *BookBuilder) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b.dump())
+}
+
+// WriteText writes the book's positions and the statistics of their moves as text.
+func (b *BookBuilder) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, pos := range b.dump() {
+		fmt.Fprintf(bw, "%s (%s)\n", pos.FEN, pos.Key)
+		for _, move := range pos.Moves {
+			fmt.Fprintf(bw, "  %-8s %-6s weight %5d  games %5d  +%d =%d -%d\n", move.SAN, move.UCI, move.Weight,
+				move.Games, move.Wins, move.Draws, move.Losses)
+		}
+	}
+	return bw.Flush()
+}
+
+// MakeBookCommand runs the makebook command: it builds a Polyglot book from the games in PGN files.
+func MakeBookCommand(args []string) error {
+	fs := flag.NewFlagSet("makebook", flag.ExitOnError)
+	out := fs.String("out", "book.bin", "Polyglot `file` to write the book to")
+	dumpPath := fs.String("dump", "", "`file` to write the book's statistics to, as JSON if it ends in .json and as text otherwise")
+	minRating := fs.Int("min-rating", 0, "only use games between players rated at least this")
+	results := fs.String("results", "", "comma-separated results of the games to use, e.g. 1-0,1/2-1/2 (default all)")
+	minPly := fs.Int("min-ply", 0, "only use games at least this many plies long")
+	maxPly := fs.Int("max-ply", 30, "number of plies of each game to use")
+	player := fs.String("player", "", "only use the moves played by the player of this `name`")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("makebook: no PGN files given")
+	}
+
+	b := &BookBuilder{MinRating: *minRating, MinPly: *minPly, MaxPly: *maxPly, Player: *player}
+	if *results != "" {
+		b.Results = strings.Split(*results, ",")
+	}
+	read, used := 0, 0
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		reader := NewPGNReader(bufio.NewReader(f))
+		for {
+			game, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				continue
+			}
+			read++
+			if b.Add(game) {
+				used++
+			}
+		}
+		f.Close()
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := b.WritePolyglot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Used %d of %d games, writing %d positions to %s\n", used, read, len(b.positions), *out)
+
+	if *dumpPath != "" {
+		f, err := os.Create(*dumpPath)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(*dumpPath, ".json") {
+			err = b.WriteJSON(f)
+		} else {
+			err = b.WriteText(f)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
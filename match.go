@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adjudication decides games early once their outcome is clear. A zero score or move count disables that rule.
+type Adjudication struct {
+	// A game is resigned once both players have agreed for ResignMoves moves each that one side is ahead by at least
+	// ResignScore pawns.
+	ResignScore float64
+	ResignMoves int
+	// A game is drawn once both players have agreed for DrawMoves moves each, from move DrawMoveNumber on, that the
+	// score is within DrawScore pawns.
+	DrawScore      float64
+	DrawMoves      int
+	DrawMoveNumber int
+	// A game is drawn once it reaches MaxMoves moves.
+	MaxMoves int
+}
+
+// MatchConfig configures a match between two players.
+type MatchConfig struct {
+	// Players are specs for the two players, as accepted by NewPlayer.
+	Players [2]string
+	// Games is the number of games to play. Colors alternate each game, and each opening is played twice.
+	Games       int
+	Concurrency int
+	// Base and Inc are the time control. Without a time control, moves are bounded by Limits alone.
+	Base, Inc time.Duration
+	Limits    SearchLimits
+	// Openings are the FENs of the positions to start games from. Without openings, games start from the starting
+	// position.
+	Openings     []string
+	Adjudication Adjudication
+	Event        string
+}
+
+// MatchGame is the outcome of a game in a match.
+type MatchGame struct {
+	Round int
+	// White is the index in MatchConfig.Players of the player with the white pieces.
+	White       int
+	Result      string
+	Termination string
+	PGN         *PGNGame
+}
+
+// Players returns the names of the match's two players, in the order of MatchConfig.Players.
+func (g MatchGame) Players() [2]string {
+	names := [2]string{g.PGN.Tag("White"), g.PGN.Tag("Black")}
+	if g.White == 1 {
+		names[0], names[1] = names[1], names[0]
+	}
+	return names
+}
+
+// Score returns the points scored by the match's first player.
+func (g MatchGame) Score() float64 {
+	switch {
+	case g.Result == "1/2-1/2":
+		return .5
+	case g.Result == "1-0" && g.White == 0, g.Result == "0-1" && g.White == 1:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// MatchScore tallies a match from the first player's perspective.
+type MatchScore struct {
+	Wins, Draws, Losses int
+}
+
+// Add counts a game's result.
+func (s *MatchScore) Add(g MatchGame) {
+	switch g.Score() {
+	case 1:
+		s.Wins++
+	case .5:
+		s.Draws++
+	default:
+		s.Losses++
+	}
+}
+
+func (s MatchScore) String() string {
+	games := s.Wins + s.Draws + s.Losses
+	points := float64(s.Wins) + float64(s.Draws)/2
+	return fmt.Sprintf("+%d =%d -%d (%.1f/%d)", s.Wins, s.Draws, s.Losses, points, games)
+}
+
+// NewPlayer creates a player from a spec. "robchess" is RobChess's own search, optionally followed by a colon and
+// comma-separated options which override the match's limits: "robchess:depth=4,name=Deep". The options book,
+// bookdepth and bookbest give it a Polyglot opening book. evalparams loads its evaluation weights from a file, and
+// options named "eval." followed by the name of a weight set it, e.g. "eval.DoubledPawn=-12:-24". nnue evaluates
+// with the network in a file, or classically when it's "off". contempt, rating, contemptelo, opponentrating and
+// analysis set its contempt as the flags of the same names do, starting from the engine's. "uci:<command>" starts an
+// external UCI engine with the given command line. The returned name identifies the player in results.
+func NewPlayer(spec string) (Player, string, error) {
+	kind, rest := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, rest = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "robchess":
+		name := "RobChess"
+		var limits SearchLimits
+		var bookPath string
+		var bookDepth int
+		var bookBest bool
+		var eval *EvalParams
+		var engine EnginePlayer
+		c := contempt
+		for _, option := range strings.Split(rest, ",") {
+			if option == "" {
+				continue
+			}
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) != 2 {
+				return nil, "", fmt.Errorf("player %q: option %q is not of the form name=value", spec, option)
+			}
+			var err error
+			switch kv[0] {
+			case "name":
+				name = kv[1]
+			case "depth":
+				limits.Depth, err = strconv.Atoi(kv[1])
+			case "nodes":
+				limits.Nodes, err = strconv.Atoi(kv[1])
+			case "movetime":
+				limits.MoveTime, err = time.ParseDuration(kv[1])
+			case "book":
+				bookPath = kv[1]
+			case "bookdepth":
+				bookDepth, err = strconv.Atoi(kv[1])
+			case "bookbest":
+				bookBest, err = strconv.ParseBool(kv[1])
+			case "evalparams":
+				eval, err = LoadEvalParams(kv[1])
+			case "contempt":
+				err = c.SetSides(kv[1])
+			case "rating":
+				c.Rating, err = strconv.Atoi(kv[1])
+			case "contemptelo":
+				c.PerHundredElo, err = strconv.Atoi(kv[1])
+			case "opponentrating":
+				engine.OpponentRating, err = strconv.Atoi(kv[1])
+			case "analysis":
+				c.Analysis, err = strconv.ParseBool(kv[1])
+			case "nnue":
+				engine.Network, engine.Classical = nil, kv[1] == "off"
+				if !engine.Classical {
+					engine.Network, err = LoadNetwork(kv[1])
+				}
+			default:
+				if !strings.HasPrefix(kv[0], "eval.") {
+					err = fmt.Errorf("unknown option")
+					break
+				}
+				if eval == nil {
+					params := *evalParams
+					eval = &params
+				}
+				err = eval.Set(strings.TrimPrefix(kv[0], "eval."), kv[1])
+			}
+			if err != nil {
+				return nil, "", fmt.Errorf("player %q: option %q: %v", spec, option, err)
+			}
+		}
+		engine.Eval, engine.Contempt = eval, &c
+		var player Player = &limitedPlayer{&engine, limits}
+		if bookPath != "" {
+			book, err := LoadBook(bookPath)
+			if err != nil {
+				return nil, "", fmt.Errorf("player %q: %v", spec, err)
+			}
+			book.MaxPly, book.Best = bookDepth, bookBest
+			player = &BookPlayer{player, book}
+		}
+		return player, name, nil
+	case "uci":
+		args := strings.Fields(rest)
+		if len(args) == 0 {
+			return nil, "", fmt.Errorf("player %q: missing engine command", spec)
+		}
+		engine, err := StartExternalEngine(args[0], args[1:]...)
+		if err != nil {
+			return nil, "", err
+		}
+		return engine, engine.Name, nil
+	default:
+		return nil, "", fmt.Errorf("player %q: unknown kind %q", spec, kind)
+	}
+}
+
+// limitedPlayer overrides the limits a player is given with its own, where they are set.
+type limitedPlayer struct {
+	Player
+	limits SearchLimits
+}
+
+func (l *limitedPlayer) Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error) {
+	if l.limits.Depth > 0 {
+		limits.Depth = l.limits.Depth
+	}
+	if l.limits.Nodes > 0 {
+		limits.Nodes = l.limits.Nodes
+	}
+	if l.limits.MoveTime > 0 {
+		limits.MoveTime = l.limits.MoveTime
+	}
+	return l.Player.Think(ctx, g, side, limits)
+}
+
+// closePlayer releases any resources a player holds, such as an external engine's process.
+func closePlayer(p Player) {
+	if c, ok := p.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// RunMatch plays a match, calling played with each game as it finishes, in the order the games finish. played
+// returns false to stop the match early, in which case games in progress are abandoned.
+func RunMatch(ctx context.Context, cfg MatchConfig, played func(MatchGame) bool) error {
+	names := [2]string{}
+	for i, spec := range cfg.Players {
+		player, name, err := NewPlayer(spec)
+		if err != nil {
+			return err
+		}
+		closePlayer(player)
+		names[i] = name
+	}
+	if names[0] == names[1] {
+		names[0] += " 1"
+		names[1] += " 2"
+	}
+	openings := cfg.Openings
+	if len(openings) == 0 {
+		openings = []string{StartFEN}
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rounds := make(chan int)
+	games := make(chan MatchGame)
+	errs := make(chan error, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker has its own players, since external engines play one game at a time.
+			var players [2]Player
+			for i, spec := range cfg.Players {
+				player, _, err := NewPlayer(spec)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				defer closePlayer(player)
+				players[i] = player
+			}
+			for round := range rounds {
+				white := round % 2
+				opening := openings[(round/2)%len(openings)]
+				game := playMatchGame(ctx, cfg, round+1, opening, white,
+					[2]Player{players[white], players[1-white]}, [2]string{names[white], names[1-white]})
+				select {
+				case games <- game:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(rounds)
+		for round := 0; round < cfg.Games; round++ {
+			select {
+			case rounds <- round:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(games)
+	}()
+
+	for game := range games {
+		if ctx.Err() != nil {
+			continue
+		}
+		if !played(game) {
+			cancel()
+		}
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// playMatchGame plays a game between two players, given in the order white, black.
+func playMatchGame(ctx context.Context, cfg MatchConfig, round int, fen string, white int, players [2]Player,
+	names [2]string) MatchGame {
+	game := MatchGame{Round: round, White: white, PGN: &PGNGame{}}
+	pgn := game.PGN
+	pgn.SetTag("Event", cfg.Event)
+	pgn.SetTag("Site", "?")
+	pgn.SetTag("Date", time.Now().Format("2006.01.02"))
+	pgn.SetTag("Round", strconv.Itoa(round))
+	pgn.SetTag("White", names[0])
+	pgn.SetTag("Black", names[1])
+	pgn.SetTag("Result", "*")
+	if fen != StartFEN {
+		pgn.SetTag("SetUp", "1")
+		pgn.SetTag("FEN", fen)
+	}
+	if cfg.Base > 0 {
+		pgn.SetTag("TimeControl", fmt.Sprintf("%g+%g", cfg.Base.Seconds(), cfg.Inc.Seconds()))
+	} else {
+		pgn.SetTag("TimeControl", "-")
+	}
+
+	result, termination := playMatchMoves(ctx, cfg, fen, players, pgn)
+	game.Result, game.Termination = result, termination
+	pgn.SetTag("Result", result)
+	pgn.SetTag("Termination", termination)
+	pgn.Result = result
+	return game
+}
+
+// playMatchMoves plays out a game, recording its moves, and returns its result and how it ended.
+func playMatchMoves(ctx context.Context, cfg MatchConfig, fen string, players [2]Player, pgn *PGNGame) (string, string) {
+	p, side, err := ParseFEN(fen)
+	if err != nil {
+		return "*", err.Error()
+	}
+	g := NewGameFromPosition(p)
+	clocks := [2]time.Duration{cfg.Base, cfg.Base}
+	repetitions := map[string]int{repetitionKey(&g.position, side): 1}
+	adj := cfg.Adjudication
+	resignPlies, drawPlies := 0, 0
+	wins := func(s Side) string {
+		if s == White {
+			return "1-0"
+		}
+		return "0-1"
+	}
+
+	for plies := 0; ; plies++ {
+		moves := g.position.GetMoves(side)
+		switch {
+		case len(moves) == 0 && g.position.InCheck(side):
+			return wins(side.OppSide()), "checkmate"
+		case len(moves) == 0:
+			return "1/2-1/2", "stalemate"
+		case g.position.halfMoveClock >= 100:
+			return "1/2-1/2", "fifty-move rule"
+		case repetitions[repetitionKey(&g.position, side)] >= 3:
+			return "1/2-1/2", "threefold repetition"
+		case g.position.InsufficientMaterial():
+			return "1/2-1/2", "insufficient material"
+		case adj.MaxMoves > 0 && plies >= adj.MaxMoves*2:
+			return "1/2-1/2", "adjudication: maximum moves"
+		case ctx.Err() != nil:
+			return "*", "abandoned"
+		}
+
+		limits := cfg.Limits
+		if cfg.Base > 0 {
+			clockLimits := ClockLimits(clocks[side], cfg.Inc)
+			limits.MoveTime = clockLimits.MoveTime
+		}
+		start := time.Now()
+		result, err := players[side].Think(ctx, *g, side, limits)
+		elapsed := time.Since(start)
+		if ctx.Err() != nil {
+			return "*", "abandoned"
+		}
+		if err != nil {
+			return wins(side.OppSide()), "forfeit: " + err.Error()
+		}
+		if cfg.Base > 0 {
+			clocks[side] -= elapsed
+			if clocks[side] < 0 {
+				if !g.position.hasMatingMaterial(side.OppSide()) {
+					return "1/2-1/2", "time forfeit"
+				}
+				return wins(side.OppSide()), "time forfeit"
+			}
+			clocks[side] += cfg.Inc
+		}
+		if !containsMove(moves, result.BestMove) || len(result.PV) == 0 {
+			return wins(side.OppSide()), "forfeit: illegal move " + result.BestMove.String()
+		}
+
+		// Adjudicate on the score from white's perspective.
+		score := result.Score
+		if side == Black {
+			score = -score
+		}
+		// resignPlies counts up while white is winning and down while black is.
+		switch {
+		case adj.ResignScore > 0 && score >= adj.ResignScore:
+			if resignPlies < 0 {
+				resignPlies = 0
+			}
+			resignPlies++
+		case adj.ResignScore > 0 && score <= -adj.ResignScore:
+			if resignPlies > 0 {
+				resignPlies = 0
+			}
+			resignPlies--
+		default:
+			resignPlies = 0
+		}
+		if adj.DrawScore > 0 && g.position.fullMoveNumber >= adj.DrawMoveNumber &&
+			score <= adj.DrawScore && score >= -adj.DrawScore {
+			drawPlies++
+		} else {
+			drawPlies = 0
+		}
+
+		g.MakeMove(result.BestMove)
+		pgn.Moves = append(pgn.Moves, result.BestMove)
+		side = side.OppSide()
+		repetitions[repetitionKey(&g.position, side)]++
+
+		switch {
+		case adj.ResignMoves > 0 && resignPlies >= adj.ResignMoves*2:
+			return "1-0", "adjudication: resignation"
+		case adj.ResignMoves > 0 && resignPlies <= -adj.ResignMoves*2:
+			return "0-1", "adjudication: resignation"
+		case adj.DrawMoves > 0 && drawPlies >= adj.DrawMoves*2:
+			return "1/2-1/2", "adjudication: draw"
+		}
+	}
+}
+
+// repetitionKey identifies a position for detecting repetitions: the FEN without its move counters.
+func repetitionKey(p *Position, side Side) string {
+	fields := strings.Fields(p.FEN(side))
+	return strings.Join(fields[:4], " ")
+}
+
+func containsMove(moves []Move, move Move) bool {
+	for _, m := range moves {
+		if m == move {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadOpenings reads opening positions from a file of FEN or EPD lines. Blank lines and lines starting with # are
+// skipped, as are any EPD operations. A file ending in .pgn is read as games instead, each giving the position at the
+// end of its main line.
+func LoadOpenings(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(strings.ToLower(path), ".pgn") {
+		return loadPGNOpenings(f, path)
+	}
+
+	var openings []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s:%d: expected a FEN or EPD position", path, line)
+		}
+		fen := strings.Join(fields[:4], " ")
+		if len(fields) >= 6 && isNumber(fields[4]) && isNumber(fields[5]) {
+			fen = strings.Join(fields[:6], " ")
+		}
+		p, side, err := ParseFEN(fen)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		openings = append(openings, p.FEN(side))
+	}
+	return openings, scanner.Err()
+}
+
+func loadPGNOpenings(r io.Reader, path string) ([]string, error) {
+	games, err := ReadPGN(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	openings := make([]string, 0, len(games))
+	for _, game := range games {
+		p, side, err := game.StartPosition()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, move := range game.Moves {
+			p.makeMove(move)
+			side = side.OppSide()
+		}
+		openings = append(openings, p.FEN(side))
+	}
+	return openings, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// parseTimeControl parses a time control of the form base+increment in seconds, e.g. "60+0.5".
+func parseTimeControl(tc string) (base, inc time.Duration, err error) {
+	parts := strings.SplitN(tc, "+", 2)
+	seconds, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time control %q: %v", tc, err)
+	}
+	base = time.Duration(seconds * float64(time.Second))
+	if len(parts) == 2 {
+		if seconds, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return 0, 0, fmt.Errorf("time control %q: %v", tc, err)
+		}
+		inc = time.Duration(seconds * float64(time.Second))
+	}
+	return base, inc, nil
+}
+
+// matchFlags registers the flags shared by commands which play games, and returns a function building the config
+// once the flags are parsed.
+func matchFlags(fs *flag.FlagSet) func() (MatchConfig, error) {
+	concurrency := fs.Int("concurrency", 1, "number of games to play at once")
+	tc := fs.String("tc", "", "time control as base+increment in seconds, e.g. 10+0.1")
+	depth := fs.Int("depth", 0, "depth limit per move")
+	nodes := fs.Int("nodes", 0, "node limit per move")
+	moveTime := fs.Duration("movetime", 0, "time limit per move")
+	openingsPath := fs.String("openings", "", "`file` of FEN or EPD opening positions, or of PGN games")
+	resignScore := fs.Int("resign-score", 0, "adjudicate a loss once both players agree one side is down by this many centipawns")
+	resignMoves := fs.Int("resign-moves", 3, "number of moves each the resign score must hold for")
+	drawScore := fs.Int("draw-score", 0, "adjudicate a draw once both players agree the score is within this many centipawns")
+	drawMoves := fs.Int("draw-moves", 8, "number of moves each the draw score must hold for")
+	drawMoveNumber := fs.Int("draw-move-number", 40, "first move at which draws may be adjudicated")
+	maxMoves := fs.Int("max-moves", 0, "adjudicate a draw after this many moves")
+	event := fs.String("event", "RobChess match", "event name written to the PGN")
+
+	return func() (MatchConfig, error) {
+		cfg := MatchConfig{
+			Concurrency: *concurrency,
+			Limits:      SearchLimits{*depth, *nodes, *moveTime},
+			Adjudication: Adjudication{
+				float64(*resignScore) / 100, *resignMoves,
+				float64(*drawScore) / 100, *drawMoves, *drawMoveNumber,
+				*maxMoves,
+			},
+			Event: *event,
+		}
+		if *tc != "" {
+			var err error
+			if cfg.Base, cfg.Inc, err = parseTimeControl(*tc); err != nil {
+				return cfg, err
+			}
+		} else if cfg.Limits == (SearchLimits{}) {
+			cfg.Limits.Depth = defaultDepth
+		}
+		if *openingsPath != "" {
+			var err error
+			if cfg.Openings, err = LoadOpenings(*openingsPath); err != nil {
+				return cfg, err
+			}
+		}
+		return cfg, nil
+	}
+}
+
+// MatchCommand runs the match command: it plays games between two players and writes them to a PGN file.
+func MatchCommand(args []string) error {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	engine1 := fs.String("engine1", "robchess", "first player: robchess[:option=value,...] or uci:<command>")
+	engine2 := fs.String("engine2", "robchess", "second player: robchess[:option=value,...] or uci:<command>")
+	games := fs.Int("games", 2, "number of games to play")
+	pgnPath := fs.String("pgn", "match.pgn", "`file` to write the games to")
+	config := matchFlags(fs)
+	test := sprtFlags(fs)
+	fs.Parse(args)
+	sprt := test()
+
+	cfg, err := config()
+	if err != nil {
+		return err
+	}
+	cfg.Players = [2]string{*engine1, *engine2}
+	cfg.Games = *games
+
+	out, err := os.Create(*pgnPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var score MatchScore
+	var stats MatchStats
+	err = RunMatch(context.Background(), cfg, func(game MatchGame) bool {
+		score.Add(game)
+		stats.Add(game.Round, game.Score())
+		fmt.Printf("Game %d: %s - %s %s (%s)\n", game.Round, game.PGN.Tag("White"), game.PGN.Tag("Black"),
+			game.Result, game.Termination)
+		names := game.Players()
+		fmt.Printf("Score of %s vs %s: %v\n", names[0], names[1], score)
+		fmt.Println(stats.Summary(sprt))
+		if err := WritePGN(out, game.PGN); err != nil {
+			fmt.Fprintf(os.Stderr, "writing %s: %v\n", *pgnPath, err)
+		}
+		return sprt == nil || sprt.Decision(&stats) == ""
+	})
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowPlayer plays its first legal move, but only after its delay.
+type slowPlayer struct {
+	delay time.Duration
+}
+
+func (s slowPlayer) Think(ctx context.Context, g GameContext, side Side, limits SearchLimits) (SearchResult, error) {
+	time.Sleep(s.delay)
+	moves := g.position.GetMoves(side)
+	if len(moves) == 0 {
+		return SearchResult{}, nil
+	}
+	return SearchResult{BestMove: moves[0], PV: moves[:1]}, nil
+}
+
+// A flag fall loses, unless the opponent has too little material to mate.
+func TestTimeForfeit(t *testing.T) {
+	tests := []struct {
+		fen, want string
+	}{
+		{"4k2r/8/8/8/8/8/8/4K3 w - - 0 1", "0-1"},
+		{"4k3/8/8/8/8/8/8/3QK3 b - - 0 1", "1-0"},
+		{"4k3/8/8/8/8/8/8/3QK3 w - - 0 1", "1/2-1/2"},
+		{"4kn2/8/8/8/8/8/8/3QK3 w - - 0 1", "1/2-1/2"},
+		{"4kb2/8/8/8/8/8/8/3QK3 w - - 0 1", "1/2-1/2"},
+		{"3nkn2/8/8/8/8/8/8/3QK3 w - - 0 1", "0-1"},
+		{"4k3/p7/8/8/8/8/8/3QK3 w - - 0 1", "0-1"},
+	}
+	cfg := MatchConfig{Base: time.Millisecond}
+	players := [2]Player{slowPlayer{10 * time.Millisecond}, slowPlayer{10 * time.Millisecond}}
+	for _, test := range tests {
+		result, termination := playMatchMoves(context.Background(), cfg, test.fen, players, &PGNGame{})
+		if result != test.want || termination != "time forfeit" {
+			t.Errorf("%s: %s by %s, want %s by time forfeit", test.fen, result, termination, test.want)
+		}
+	}
+}
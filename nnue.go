@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A Network is an efficiently updatable neural network which evaluates positions in place of the classical
+// evaluation. Its inputs are the 768 features of a piece of each kind and color on each square, seen from one side's
+// perspective: the side's own pieces come first, and for Black the board is flipped so that its first rank is rank
+// 1. A hidden layer of clipped ReLUs is computed for each perspective by summing the weights of the features present,
+// which is cheap to keep up to date as moves are made, since a move only adds and removes a few features. The output
+// is a weighted sum of both sides' hidden layers, the side to move's first.
+//
+// Networks are stored little-endian in this format:
+//
+//	magic          [4]byte  "RCNN"
+//	version        uint32   1
+//	hidden         uint32   the size of each perspective's hidden layer, H
+//	scaleMul       int32    the output is scaled to centipawns by scaleMul/scaleDiv
+//	scaleDiv       int32
+//	featureWeights [768][H]int16  by feature: (own 0 or enemy 1 * 6 + piece) * 64 + square
+//	featureBias    [H]int16
+//	outputWeights  [2H]int8  for the side to move's hidden layer, then the other side's
+//	outputBias     int32
+//
+// Pieces are numbered pawn, rook, knight, bishop, queen, king, and squares rank*8+file from a1. Hidden values are
+// clipped to [0, nnueClip] before the output layer.
+type Network struct {
+	hidden             int
+	scaleMul, scaleDiv int32
+	featureWeights     []int16
+	featureBias        []int16
+	outputWeights      []int8
+	outputBias         int32
+}
+
+// evalNetwork is the network the engine evaluates with unless a player is given its own. When it's nil, the engine
+// evaluates classically.
+var evalNetwork *Network
+
+// UseNetwork has the engine evaluate with net, or classically when net is nil.
+func UseNetwork(net *Network) {
+	evalNetwork = net
+}
+
+// nnueFeatures is the number of features of each perspective.
+const nnueFeatures = 2 * 6 * 64
+
+// nnueClip is the most a hidden value contributes to the output.
+const nnueClip = 127
+
+// nnueMagic and nnueVersion begin every network file.
+const (
+	nnueMagic   = "RCNN"
+	nnueVersion = 1
+)
+
+// nnueHeader is the fixed part of a network file.
+type nnueHeader struct {
+	Magic              [4]byte
+	Version            uint32
+	Hidden             uint32
+	ScaleMul, ScaleDiv int32
+}
+
+// LoadNetwork reads a network from a file.
+func LoadNetwork(path string) (*Network, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, err := readNetwork(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+func readNetwork(r io.Reader) (*Network, error) {
+	var h nnueHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	switch {
+	case string(h.Magic[:]) != nnueMagic:
+		return nil, fmt.Errorf("not a RobChess network")
+	case h.Version != nnueVersion:
+		return nil, fmt.Errorf("unsupported network version %d", h.Version)
+	case h.Hidden == 0 || h.Hidden > 1<<16:
+		return nil, fmt.Errorf("bad hidden layer size %d", h.Hidden)
+	case h.ScaleDiv == 0:
+		return nil, fmt.Errorf("zero output scale divisor")
+	}
+	n := &Network{
+		hidden:         int(h.Hidden),
+		scaleMul:       h.ScaleMul,
+		scaleDiv:       h.ScaleDiv,
+		featureWeights: make([]int16, nnueFeatures*int(h.Hidden)),
+		featureBias:    make([]int16, h.Hidden),
+		outputWeights:  make([]int8, 2*h.Hidden),
+	}
+	for _, v := range []interface{}{n.featureWeights, n.featureBias, n.outputWeights, &n.outputBias} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after the network")
+	}
+	return n, nil
+}
+
+// Save writes the network to a file in the format LoadNetwork reads.
+func (n *Network) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	h := nnueHeader{Version: nnueVersion, Hidden: uint32(n.hidden), ScaleMul: n.scaleMul, ScaleDiv: n.scaleDiv}
+	copy(h.Magic[:], nnueMagic)
+	for _, v := range []interface{}{&h, n.featureWeights, n.featureBias, n.outputWeights, n.outputBias} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nnueFeature returns the index of the feature of piece on (f, r) from perspective's point of view.
+func nnueFeature(perspective Side, piece GamePiece, f, r int) int {
+	enemy := 0
+	if piece.color != perspective {
+		enemy = 1
+	}
+	if perspective == Black {
+		r = 7 - r
+	}
+	return (enemy*6+int(piece.piece))*64 + r*8 + f
+}
+
+// nnueChange is a piece added to or removed from a square by a move.
+type nnueChange struct {
+	piece GamePiece
+	f, r  int
+	add   bool
+}
+
+// nnueAccumulator holds the hidden layer of each perspective before clipping, along with the changes of the move
+// which led to it. Accumulators are brought up to date lazily, when a position is evaluated, since most moves made
+// are taken back unevaluated, e.g. when moves are checked for legality.
+type nnueAccumulator struct {
+	values   [2][]int16
+	changes  [4]nnueChange
+	count    int
+	computed bool
+}
+
+// nnueState keeps a network's accumulators up to date with a position as moves are made and taken back: one for the
+// position the search began from and one for each move made since.
+type nnueState struct {
+	net   *Network
+	stack []nnueAccumulator
+	top   int
+}
+
+func newNNUEState(net *Network) *nnueState {
+	return &nnueState{net: net}
+}
+
+// accumulator returns the accumulator at the given height of the stack, allocating it the first time.
+func (s *nnueState) accumulator(i int) *nnueAccumulator {
+	for len(s.stack) <= i {
+		s.stack = append(s.stack, nnueAccumulator{values: [2][]int16{
+			make([]int16, s.net.hidden), make([]int16, s.net.hidden),
+		}})
+	}
+	return &s.stack[i]
+}
+
+// refresh computes the accumulators of the position from scratch, emptying the stack.
+func (s *nnueState) refresh(p *Position) {
+	s.top = 0
+	acc := s.accumulator(0)
+	for perspective := White; perspective <= Black; perspective++ {
+		copy(acc.values[perspective], s.net.featureBias)
+	}
+	for r := range p.board {
+		for f := range p.board[r] {
+			if piece := p.board[r][f]; piece.piece != None {
+				s.apply(acc, nnueChange{piece, f, r, true})
+			}
+		}
+	}
+	acc.computed = true
+}
+
+// apply adds or removes a feature of both perspectives.
+func (s *nnueState) apply(acc *nnueAccumulator, c nnueChange) {
+	h := s.net.hidden
+	for perspective := White; perspective <= Black; perspective++ {
+		idx := nnueFeature(perspective, c.piece, c.f, c.r) * h
+		weights := s.net.featureWeights[idx : idx+h]
+		values := acc.values[perspective]
+		if c.add {
+			for i, w := range weights {
+				values[i] += w
+			}
+		} else {
+			for i, w := range weights {
+				values[i] -= w
+			}
+		}
+	}
+}
+
+// push records the changes of a move just made by makeMove.
+func (s *nnueState) push(p *Position, undo moveUndo) {
+	s.top++
+	acc := s.accumulator(s.top)
+	acc.computed = false
+	move := undo.move
+	acc.changes[0] = nnueChange{undo.piece, move.oFile, move.oRank, false}
+	acc.changes[1] = nnueChange{p.board[move.nRank][move.nFile], move.nFile, move.nRank, true}
+	acc.count = 2
+	if undo.captured.piece != None {
+		acc.changes[2] = nnueChange{undo.captured, undo.captureSquare.file, undo.captureSquare.rank, false}
+		acc.count = 3
+	}
+	if undo.piece.piece == King && (move.nFile-move.oFile == 2 || move.oFile-move.nFile == 2) {
+		from, to := 7, 5
+		if move.nFile < move.oFile {
+			from, to = 0, 3
+		}
+		rook := p.board[move.oRank][to]
+		acc.changes[2] = nnueChange{rook, from, move.oRank, false}
+		acc.changes[3] = nnueChange{rook, to, move.oRank, true}
+		acc.count = 4
+	}
+}
+
+// pop forgets the move just taken back by unmakeMove.
+func (s *nnueState) pop() {
+	s.top--
+}
+
+// evaluate returns the network's evaluation of the position for side in centipawns, bringing the accumulators up to
+// date first.
+func (s *nnueState) evaluate(side Side) int {
+	i := s.top
+	for !s.stack[i].computed {
+		i--
+	}
+	for i++; i <= s.top; i++ {
+		acc, prev := &s.stack[i], &s.stack[i-1]
+		for perspective := White; perspective <= Black; perspective++ {
+			copy(acc.values[perspective], prev.values[perspective])
+		}
+		for _, c := range acc.changes[:acc.count] {
+			s.apply(acc, c)
+		}
+		acc.computed = true
+	}
+	return s.net.output(&s.stack[s.top], side)
+}
+
+// output computes the output layer from the accumulators, for side to move.
+func (n *Network) output(acc *nnueAccumulator, side Side) int {
+	sum := n.outputBias
+	for half, perspective := range [2]Side{side, side.OppSide()} {
+		weights := n.outputWeights[half*n.hidden : (half+1)*n.hidden]
+		for i, v := range acc.values[perspective] {
+			if v > 0 {
+				if v > nnueClip {
+					v = nnueClip
+				}
+				sum += int32(v) * int32(weights[i])
+			}
+		}
+	}
+	return int(int64(sum) * int64(n.scaleMul) / int64(n.scaleDiv))
+}
+
+// EvaluateNetwork evaluates the position for side with the network from scratch, in centipawns.
+func EvaluateNetwork(net *Network, p *Position, side Side) int {
+	s := newNNUEState(net)
+	s.refresh(p)
+	return s.evaluate(side)
+}
+
+// pieceSquareNetworkScale is how many hidden units a centipawn of the piece-square network is worth.
+const pieceSquareNetworkScale = 3
+
+// PieceSquareNetwork builds a network computing material and piece-square tables, halfway between the middlegame
+// and endgame values, with hidden layers of the given size. Each perspective sums the value of its own pieces,
+// spread evenly across the hidden layer, and the output is the difference between the two. It's exact until a side's
+// pieces are worth more than the hidden layer can hold, about 5300 centipawns with 128 units.
+func PieceSquareNetwork(w *EvalParams, hidden int) *Network {
+	n := &Network{
+		hidden:         hidden,
+		scaleMul:       1,
+		scaleDiv:       pieceSquareNetworkScale,
+		featureWeights: make([]int16, nnueFeatures*hidden),
+		featureBias:    make([]int16, hidden),
+		outputWeights:  make([]int8, 2*hidden),
+	}
+	// A little bias keeps hidden values from dropping below 0 with the king on a square worth less than nothing.
+	for i := range n.featureBias {
+		n.featureBias[i] = 2
+		n.outputWeights[i] = 1
+		n.outputWeights[hidden+i] = -1
+	}
+	for piece := Pawn; piece <= King; piece++ {
+		for sq := 0; sq < 64; sq++ {
+			f, r := sq%8, sq/8
+			gp := GamePiece{piece, White}
+			value := w.PieceValues[piece].Add(pieceSquare(w, gp, f, r))
+			units := pieceSquareNetworkScale * (value.MG + value.EG) / 2
+			feature := nnueFeature(White, gp, f, r)
+			// Share the units out so that the hidden layer sums to them exactly.
+			base := units / hidden
+			if units%hidden < 0 {
+				base--
+			}
+			extra := units - base*hidden
+			for i := 0; i < hidden; i++ {
+				weight := base
+				if (i+feature)%hidden < extra {
+					weight++
+				}
+				n.featureWeights[feature*hidden+i] = int16(weight)
+			}
+		}
+	}
+	return n
+}
+
+// NNUECommand runs the nnue command, which writes the piece-square network in the network file format, as a
+// starting point for networks and a check of the incremental evaluation.
+func NNUECommand(args []string) error {
+	fs := flag.NewFlagSet("nnue", flag.ExitOnError)
+	out := fs.String("out", "pst.nnue", "`file` to write the network to")
+	hidden := fs.Int("hidden", 128, "size of each perspective's hidden layer")
+	fs.Parse(args)
+	if *hidden <= 0 {
+		return fmt.Errorf("nnue: the hidden layer needs at least one unit")
+	}
+	if err := PieceSquareNetwork(evalParams, *hidden).Save(*out); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the piece-square network with %d hidden units to %s\n", *hidden, *out)
+	return nil
+}
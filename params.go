@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EvalParams holds every weight of the evaluation, so that they can be changed without recompiling. The compiled-in
+// defaults are declared beside the terms which use them. Params are saved as JSON, by the field names below, with
+// scores as {"mg": ..., "eg": ...}.
+type EvalParams struct {
+	PieceValues [King + 1]Score
+	// PieceSquare holds the piece-square tables of each piece for the middlegame and endgame, from White's
+	// perspective with the eighth rank first.
+	PieceSquare [King + 1][2][64]int
+
+	DoubledPawn             Score
+	IsolatedPawn            Score
+	BackwardPawn            Score
+	SupportedPawn           [8]Score
+	PhalanxPawn             [8]Score
+	PassedPawn              [8]Score
+	CandidatePawn           [8]Score
+	FreePasser              [8]Score
+	PasserOwnKingDistance   int
+	PasserEnemyKingDistance int
+
+	PawnShield        [4]Score
+	PawnStorm         [4]Score
+	BlockedStorm      [4]Score
+	KingSemiOpenFile  Score
+	KingOpenFile      Score
+	AttackWeight      [King + 1]int
+	SafeCheck         [King + 1]int
+	KingDangerDivisor int
+	MaxKingDanger     int
+
+	KnightMobility   [len(knightMobility)]Score
+	BishopMobility   [len(bishopMobility)]Score
+	RookMobility     [len(rookMobility)]Score
+	QueenMobility    [len(queenMobility)]Score
+	KnightOutpost    Score
+	BishopOutpost    Score
+	BishopPair       Score
+	RookOpenFile     Score
+	RookSemiOpenFile Score
+	RookOnSeventh    Score
+	TrappedBishop    Score
+	BoxedRook        Score
+}
+
+// DefaultEvalParams returns the compiled-in evaluation weights.
+func DefaultEvalParams() *EvalParams {
+	return &EvalParams{
+		PieceValues: pieceValues,
+		PieceSquare: pieceSquareTables,
+
+		DoubledPawn:             doubledPawn,
+		IsolatedPawn:            isolatedPawn,
+		BackwardPawn:            backwardPawn,
+		SupportedPawn:           supportedPawn,
+		PhalanxPawn:             phalanxPawn,
+		PassedPawn:              passedPawn,
+		CandidatePawn:           candidatePawn,
+		FreePasser:              freePasser,
+		PasserOwnKingDistance:   passerOwnKingDistance,
+		PasserEnemyKingDistance: passerEnemyKingDistance,
+
+		PawnShield:        pawnShield,
+		PawnStorm:         pawnStorm,
+		BlockedStorm:      blockedStorm,
+		KingSemiOpenFile:  kingSemiOpenFile,
+		KingOpenFile:      kingOpenFile,
+		AttackWeight:      attackWeight,
+		SafeCheck:         safeCheck,
+		KingDangerDivisor: kingDangerDivisor,
+		MaxKingDanger:     maxKingDanger,
+
+		KnightMobility:   knightMobility,
+		BishopMobility:   bishopMobility,
+		RookMobility:     rookMobility,
+		QueenMobility:    queenMobility,
+		KnightOutpost:    knightOutpost,
+		BishopOutpost:    bishopOutpost,
+		BishopPair:       bishopPair,
+		RookOpenFile:     rookOpenFile,
+		RookSemiOpenFile: rookSemiOpenFile,
+		RookOnSeventh:    rookOnSeventh,
+		TrappedBishop:    trappedBishop,
+		BoxedRook:        boxedRook,
+	}
+}
+
+// evalParams is the weights the engine evaluates with unless a player is given its own.
+var evalParams = DefaultEvalParams()
+
+// UseEvalParams has the engine evaluate with params.
+func UseEvalParams(params *EvalParams) {
+	evalParams = params
+}
+
+// LoadEvalParams reads evaluation weights from a JSON file. Weights the file leaves out keep their defaults, but arrays
+// must be given in full, since decoding a short one would zero the rest of it.
+func LoadEvalParams(path string) (*EvalParams, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := checkArrayLengths("", raw, reflect.TypeOf(EvalParams{})); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	params := DefaultEvalParams()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(params); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := params.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return params, nil
+}
+
+// checkArrayLengths checks that every array in v, decoded JSON for a value of type t, has t's length. Fields are
+// matched as encoding/json matches them, and anything else is left for it to report.
+func checkArrayLengths(name string, v interface{}, t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Array:
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		if len(values) != t.Len() {
+			return fmt.Errorf("evaluation weight %q: expected %d values, found %d", name, t.Len(), len(values))
+		}
+		for i, value := range values {
+			if err := checkArrayLengths(name+"."+strconv.Itoa(i), value, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, value := range fields {
+			for i := 0; i < t.NumField(); i++ {
+				fieldName := t.Field(i).Name
+				if tag := t.Field(i).Tag.Get("json"); tag != "" {
+					fieldName = tag
+				}
+				if !strings.EqualFold(key, fieldName) {
+					continue
+				}
+				if name != "" {
+					fieldName = name + "." + fieldName
+				}
+				if err := checkArrayLengths(fieldName, value, t.Field(i).Type); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the weights which the evaluation divides by.
+func (w *EvalParams) validate() error {
+	if w.KingDangerDivisor <= 0 {
+		return fmt.Errorf("KingDangerDivisor must be positive")
+	}
+	return nil
+}
+
+// Save writes the weights to a JSON file which LoadEvalParams reads.
+func (w *EvalParams) Save(path string) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// EvalWeight is one of the integers the evaluation's weights are made of, named by its path in the params, e.g.
+// "PassedPawn.3.eg" for the endgame bonus of a passed pawn on its fourth rank.
+type EvalWeight struct {
+	Name  string
+	Value *int
+}
+
+// Weights lists every integer of the params, in the order they are declared.
+func (w *EvalParams) Weights() []EvalWeight {
+	var weights []EvalWeight
+	var walk func(name string, v reflect.Value)
+	walk = func(name string, v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Int:
+			weights = append(weights, EvalWeight{name, v.Addr().Interface().(*int)})
+		case reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(name+"."+strconv.Itoa(i), v.Index(i))
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+				fieldName := field.Name
+				if tag := field.Tag.Get("json"); tag != "" {
+					fieldName = tag
+				}
+				if name != "" {
+					fieldName = name + "." + fieldName
+				}
+				walk(fieldName, v.Field(i))
+			}
+		}
+	}
+	walk("", reflect.ValueOf(w).Elem())
+	return weights
+}
+
+// Set sets the weight with the given name, or every weight whose name begins with it and a dot. Values are given
+// as integers separated by colons, one per weight, e.g. "DoubledPawn=-12:-24".
+func (w *EvalParams) Set(name, value string) error {
+	var matched []EvalWeight
+	for _, weight := range w.Weights() {
+		if weight.Name == name || strings.HasPrefix(weight.Name, name+".") {
+			matched = append(matched, weight)
+		}
+	}
+	if len(matched) == 0 {
+		return fmt.Errorf("unknown evaluation weight %q", name)
+	}
+	values := strings.Split(value, ":")
+	if len(values) != len(matched) {
+		return fmt.Errorf("evaluation weight %q: expected %d values, found %d", name, len(matched), len(values))
+	}
+	for i, weight := range matched {
+		n, err := strconv.Atoi(values[i])
+		if err != nil {
+			return fmt.Errorf("evaluation weight %q: %v", name, err)
+		}
+		*weight.Value = n
+	}
+	return w.validate()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Weights a file leaves out keep their defaults.
+func TestLoadEvalParamsPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	data := `{"DoubledPawn": {"mg": -5}, "PassedPawn": [{}, {"mg": 1}, {}, {}, {}, {}, {}, {}], "MaxKingDanger": 900}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params, err := LoadEvalParams(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultEvalParams()
+	want.DoubledPawn.MG = -5
+	want.PassedPawn[1].MG = 1
+	want.MaxKingDanger = 900
+	if !reflect.DeepEqual(params, want) {
+		for i, weight := range params.Weights() {
+			if *weight.Value != *want.Weights()[i].Value {
+				t.Errorf("%s = %d, want %d", weight.Name, *weight.Value, *want.Weights()[i].Value)
+			}
+		}
+	}
+}
+
+// An array can't be given in part, since decoding it would zero the rest.
+func TestLoadEvalParamsShortArray(t *testing.T) {
+	tests := []struct {
+		data, weight string
+	}{
+		{`{"PassedPawn": [{"mg": 1}]}`, `"PassedPawn"`},
+		{`{"passedpawn": [{}, {}, {}, {}, {}, {}, {}, {}, {}]}`, `"PassedPawn"`},
+		{`{"PieceSquare": [[[1, 2, 3]]]}`, `"PieceSquare"`},
+		{`{"PieceSquare": [[[], []], [[], []], [[], []], [[], []], [[], []], [[], []]]}`, `"PieceSquare.0.0"`},
+	}
+	dir := t.TempDir()
+	for i, test := range tests {
+		path := filepath.Join(dir, "params"+string(rune('0'+i))+".json")
+		if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadEvalParams(path); err == nil || !strings.Contains(err.Error(), test.weight) {
+			t.Errorf("%s: error %v, want one about %s", test.data, err, test.weight)
+		}
+	}
+}
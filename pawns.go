@@ -0,0 +1,218 @@
+package main
+
+// The pawn structure terms, for the middlegame and endgame. Tables indexed by rank use the rank from the pawn's own
+// side, 0 being its first rank.
+var (
+	doubledPawn  = Score{-10, -20}
+	isolatedPawn = Score{-10, -15}
+	backwardPawn = Score{-8, -10}
+	// supportedPawn is the bonus for a pawn defended by another, and phalanxPawn for one beside another.
+	supportedPawn = [8]Score{{}, {}, {5, 5}, {8, 8}, {12, 15}, {20, 30}, {35, 50}, {}}
+	phalanxPawn   = [8]Score{{}, {3, 3}, {5, 5}, {8, 10}, {15, 20}, {25, 40}, {40, 60}, {}}
+	passedPawn    = [8]Score{{}, {5, 10}, {5, 15}, {10, 25}, {25, 50}, {45, 90}, {70, 140}, {}}
+	// candidatePawn is the bonus for a pawn which can become passed by advancing, its supporters outnumbering the
+	// pawns which stop it.
+	candidatePawn = [8]Score{{}, {2, 5}, {3, 8}, {5, 12}, {10, 25}, {20, 45}, {}, {}}
+	// freePasser is the bonus for a passed pawn with nothing in its way to promotion.
+	freePasser = [8]Score{{}, {}, {}, {0, 10}, {0, 20}, {0, 40}, {0, 70}, {}}
+)
+
+// The king distance terms reward a passed pawn's king for being close to the square in front of it, and punish the
+// opponent's for being close, weighted by how far the pawn has advanced.
+const (
+	passerOwnKingDistance   = -2
+	passerEnemyKingDistance = 5
+)
+
+// pawnKey returns a hash of the position's pawns alone, made from the Polyglot random numbers for pawns.
+func pawnKey(p *Position) uint64 {
+	var key uint64
+	for r := 1; r < 7; r++ {
+		for f := range p.board[r] {
+			if piece := p.board[r][f]; piece.piece == Pawn {
+				kind := 0
+				if piece.color == White {
+					kind = 1
+				}
+				key ^= polyglotRandom[64*kind+8*r+f]
+			}
+		}
+	}
+	return key
+}
+
+// pawnEntry is the evaluation of a pawn structure: the terms which depend on nothing but the pawns for each side, and
+// the squares of each side's passed pawns, whose evaluation also depends on the other pieces.
+type pawnEntry struct {
+	key    uint64
+	score  [2]Score
+	passed [2]uint64
+}
+
+// pawnTableSize is the number of entries of the pawn hash table. It's a power of two, so that keys index it by their
+// low bits.
+const pawnTableSize = 1 << 14
+
+// pawnTable caches the evaluations of pawn structures, which change much less often than positions do. Each search
+// and each of the tuner's threads has its own, so that probing it needs no locking, and a table only ever holds
+// evaluations with the weights of its owner.
+type pawnTable [pawnTableSize]pawnEntry
+
+// probe returns the evaluation of the position's pawn structure with the weights of params w, from the table when
+// it's there. A nil table evaluates it afresh every time.
+func (t *pawnTable) probe(w *EvalParams, p *Position) pawnEntry {
+	if t == nil {
+		return evaluatePawnStructure(w, p)
+	}
+	key := pawnKey(p)
+	slot := &t[key&(pawnTableSize-1)]
+	if slot.key != key {
+		*slot = evaluatePawnStructure(w, p)
+		slot.key = key
+	}
+	return *slot
+}
+
+// pawnRanks returns, for each side and file, a mask of the ranks holding the side's pawns.
+func pawnRanks(p *Position) (ranks [2][8]uint8) {
+	for r := 1; r < 7; r++ {
+		for f := range p.board[r] {
+			if piece := p.board[r][f]; piece.piece == Pawn {
+				ranks[piece.color][f] |= 1 << uint(r)
+			}
+		}
+	}
+	return ranks
+}
+
+// ranksAhead returns a mask of the ranks ahead of rank r from side's perspective.
+func ranksAhead(side Side, r int) uint8 {
+	if side == White {
+		return ^uint8(0) << uint(r+1)
+	}
+	return 1<<uint(r) - 1
+}
+
+// relativeRank returns rank r from side's perspective.
+func relativeRank(side Side, r int) int {
+	if side == White {
+		return r
+	}
+	return 7 - r
+}
+
+// adjacentPawns returns the mask of ranks holding side's pawns on the files either side of file f.
+func adjacentPawns(ranks *[2][8]uint8, side Side, f int) uint8 {
+	var mask uint8
+	if f > 0 {
+		mask |= ranks[side][f-1]
+	}
+	if f < 7 {
+		mask |= ranks[side][f+1]
+	}
+	return mask
+}
+
+// evaluatePawnStructure evaluates doubled, isolated, backward, connected, passed and candidate passed pawns.
+func evaluatePawnStructure(w *EvalParams, p *Position) pawnEntry {
+	var entry pawnEntry
+	ranks := pawnRanks(p)
+	for side := White; side <= Black; side++ {
+		opp := side.OppSide()
+		forward := 1
+		if side == Black {
+			forward = -1
+		}
+		score := &entry.score[side]
+		for f := 0; f < 8; f++ {
+			for r := 1; r < 7; r++ {
+				if ranks[side][f]&(1<<uint(r)) == 0 {
+					continue
+				}
+				rank := relativeRank(side, r)
+				ahead := ranksAhead(side, r)
+				own, enemy := adjacentPawns(&ranks, side, f), adjacentPawns(&ranks, opp, f)
+				behind := ^ahead &^ (1 << uint(r))
+
+				if ranks[side][f]&ahead != 0 {
+					*score = score.Add(w.DoubledPawn)
+				}
+				supported := own&(1<<uint(r-forward)) != 0
+				phalanx := own&(1<<uint(r)) != 0
+				switch {
+				case own == 0:
+					*score = score.Add(w.IsolatedPawn)
+				case own&^ahead == 0 && r+2*forward >= 0 && r+2*forward < 8 && enemy&(1<<uint(r+2*forward)) != 0:
+					// Its neighbors have all advanced past it and an enemy pawn guards the square in front.
+					*score = score.Add(w.BackwardPawn)
+				}
+				if supported {
+					*score = score.Add(w.SupportedPawn[rank])
+				}
+				if phalanx {
+					*score = score.Add(w.PhalanxPawn[rank])
+				}
+
+				opposed := ranks[opp][f]&ahead != 0
+				sentries := enemy & ahead
+				switch {
+				case opposed || ranks[side][f]&ahead != 0:
+					// Only the front pawn of a file may be passed.
+				case sentries == 0:
+					*score = score.Add(w.PassedPawn[rank])
+					entry.passed[side] |= 1 << uint(r*8+f)
+				case bitCount(own&(behind|1<<uint(r))) >= bitCount(sentries):
+					*score = score.Add(w.CandidatePawn[rank])
+				}
+			}
+		}
+	}
+	return entry
+}
+
+func bitCount(mask uint8) int {
+	count := 0
+	for ; mask != 0; mask &= mask - 1 {
+		count++
+	}
+	return count
+}
+
+// evaluatePassers evaluates the terms of side's passed pawns which depend on the other pieces: a free path to
+// promotion and the distance of the kings. The score is from side's perspective.
+func evaluatePassers(w *EvalParams, p *Position, passed uint64, side Side) Score {
+	var score Score
+	if passed == 0 {
+		return score
+	}
+	forward := 1
+	if side == Black {
+		forward = -1
+	}
+	kf, kr := findPiece(p, GamePiece{King, side})
+	ef, er := findPiece(p, GamePiece{King, side.OppSide()})
+	for sq := 0; sq < 64; sq++ {
+		if passed&(1<<uint(sq)) == 0 {
+			continue
+		}
+		f, r := sq%8, sq/8
+		rank := relativeRank(side, r)
+		free := true
+		for ar := r + forward; ar >= 0 && ar < 8; ar += forward {
+			if p.board[ar][f].piece != None {
+				free = false
+				break
+			}
+		}
+		if free {
+			score = score.Add(w.FreePasser[rank])
+		}
+		if weight := rank - 2; weight > 0 {
+			stop := r + forward
+			distance := w.PasserOwnKingDistance*squareDistance(kf, kr, f, stop) +
+				w.PasserEnemyKingDistance*squareDistance(ef, er, f, stop)
+			score.EG += distance * weight
+		}
+	}
+	return score
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pgnLineLength is the length movetext is wrapped to when writing PGN.
+const pgnLineLength = 80
+
+// pgnEscaper escapes the characters which may not appear as themselves in a PGN string.
+var pgnEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// sevenTagRoster lists the tags every PGN game has, in the order they're written, along with the value written when a
+// game lacks one.
+var sevenTagRoster = []PGNTag{
+	{"Event", "?"}, {"Site", "?"}, {"Date", "????.??.??"}, {"Round", "?"}, {"White", "?"}, {"Black", "?"},
+	{"Result", "*"},
+}
+
+// pgnSuffixes maps the move suffix annotations to the NAGs they stand for.
+var pgnSuffixes = map[string]int{"!": 1, "?": 2, "!!": 3, "??": 4, "!?": 5, "?!": 6}
+
+// PGNTag is a PGN tag pair, e.g. [White "RobChess"].
+type PGNTag struct {
+	Name  string
+	Value string
+}
+
+// PGNGame is a game in Portable Game Notation. When the game doesn't start from the starting position, its FEN tag
+// gives the position it starts from.
+type PGNGame struct {
+	Tags []PGNTag
+	PGNLine
+	Result string
+}
+
+// PGNLine is a sequence of moves along with their annotations: the main line of a game, or a variation.
+type PGNLine struct {
+	// Comment comes before the first move.
+	Comment string
+	Moves   []Move
+	// Annotations follow the moves of the same index. There may be fewer annotations than moves.
+	Annotations []PGNAnnotation
+}
+
+// PGNAnnotation annotates a move.
+type PGNAnnotation struct {
+	// NAGs are Numeric Annotation Glyphs, e.g. 1 for a good move, written "!" or "$1".
+	NAGs    []int
+	Comment string
+	// Variations are alternatives to the move, played from the position before it.
+	Variations []PGNLine
+}
+
+// annotation returns the annotation of the last move, adding annotations as needed.
+func (l *PGNLine) annotation() *PGNAnnotation {
+	for len(l.Annotations) < len(l.Moves) {
+		l.Annotations = append(l.Annotations, PGNAnnotation{})
+	}
+	return &l.Annotations[len(l.Moves)-1]
+}
+
+// Tag returns the value of the named tag, or "" when the game has no such tag.
+func (g *PGNGame) Tag(name string) string {
+	for _, tag := range g.Tags {
+		if tag.Name == name {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag sets the value of the named tag, adding the tag when the game has none by that name.
+func (g *PGNGame) SetTag(name, value string) {
+	for i := range g.Tags {
+		if g.Tags[i].Name == name {
+			g.Tags[i].Value = value
+			return
+		}
+	}
+	g.Tags = append(g.Tags, PGNTag{name, value})
+}
+
+// StartPosition returns the position the game starts from, along with the side to move.
+func (g *PGNGame) StartPosition() (*Position, Side, error) {
+	if fen := g.Tag("FEN"); fen != "" {
+		return ParseFEN(fen)
+	}
+	return NewPosition(), White, nil
+}
+
+// WritePGN writes a game in PGN, followed by a blank line. The seven tag roster comes first, whether or not the game
+// has those tags, and the Result tag always agrees with the game's result.
+func WritePGN(w io.Writer, game *PGNGame) error {
+	p, side, err := game.StartPosition()
+	if err != nil {
+		return err
+	}
+	result := game.Result
+	if result == "" {
+		result = "*"
+	}
+
+	bw := bufio.NewWriter(w)
+	roster := make(map[string]bool)
+	for _, tag := range sevenTagRoster {
+		roster[tag.Name] = true
+		value := game.Tag(tag.Name)
+		if tag.Name == "Result" {
+			value = result
+		} else if value == "" {
+			value = tag.Value
+		}
+		fmt.Fprintf(bw, "[%s \"%s\"]\n", tag.Name, pgnEscaper.Replace(value))
+	}
+	for _, tag := range game.Tags {
+		if !roster[tag.Name] {
+			fmt.Fprintf(bw, "[%s \"%s\"]\n", tag.Name, pgnEscaper.Replace(tag.Value))
+		}
+	}
+	bw.WriteString("\n")
+
+	tokens := game.PGNLine.tokens(p, side, p.fullMoveNumber, nil)
+	tokens = append(tokens, result)
+	lineLength := 0
+	for _, token := range tokens {
+		if lineLength > 0 && lineLength+1+len(token) > pgnLineLength {
+			bw.WriteString("\n")
+			lineLength = 0
+		} else if lineLength > 0 {
+			bw.WriteString(" ")
+			lineLength++
+		}
+		bw.WriteString(token)
+		lineLength += len(token)
+	}
+	bw.WriteString("\n\n")
+	return bw.Flush()
+}
+
+// Movetext returns moves played from a position as numbered SAN, e.g. "1. e4 e5 2. Nf3".
+func Movetext(p *Position, side Side, moves []Move) string {
+	line := PGNLine{Moves: moves}
+	return strings.Join(line.tokens(p, side, p.fullMoveNumber, nil), " ")
+}
+
+// tokens appends the line's movetext to tokens, with the line played from the position, which is left unchanged.
+// Comments are split into words so that they can be wrapped.
+func (l *PGNLine) tokens(p *Position, side Side, moveNumber int, tokens []string) []string {
+	tokens = appendComment(tokens, l.Comment)
+	// Black's moves are numbered at the start of the line and after any comment or variation.
+	numbered := false
+	undos := make([]moveUndo, 0, len(l.Moves))
+	for i, move := range l.Moves {
+		if side == White {
+			tokens = append(tokens, strconv.Itoa(moveNumber)+".")
+		} else if !numbered {
+			tokens = append(tokens, strconv.Itoa(moveNumber)+"...")
+		}
+		tokens = append(tokens, p.SAN(move))
+		numbered = true
+
+		if i < len(l.Annotations) {
+			annotation := &l.Annotations[i]
+			for _, nag := range annotation.NAGs {
+				tokens = append(tokens, "$"+strconv.Itoa(nag))
+			}
+			if annotation.Comment != "" {
+				tokens = appendComment(tokens, annotation.Comment)
+				numbered = false
+			}
+			for j := range annotation.Variations {
+				start := len(tokens)
+				tokens = annotation.Variations[j].tokens(p, side, moveNumber, tokens)
+				if len(tokens) > start {
+					tokens[start] = "(" + tokens[start]
+					tokens[len(tokens)-1] += ")"
+					numbered = false
+				}
+			}
+		}
+
+		undos = append(undos, p.makeMove(move))
+		if side == Black {
+			moveNumber++
+		}
+		side = side.OppSide()
+	}
+	for i := len(undos) - 1; i >= 0; i-- {
+		p.unmakeMove(undos[i])
+	}
+	return tokens
+}
+
+// appendComment appends a comment to tokens, a word at a time. Comments can't contain "}", so any are replaced.
+func appendComment(tokens []string, comment string) []string {
+	if comment == "" {
+		return tokens
+	}
+	words := strings.Fields(strings.ReplaceAll(comment, "}", ")"))
+	if len(words) == 0 {
+		return append(tokens, "{}")
+	}
+	words[0] = "{" + words[0]
+	words[len(words)-1] += "}"
+	return append(tokens, words...)
+}
+
+// PGNError is an error in a PGN file, located by the game, counting from 1, and the line and column.
+type PGNError struct {
+	Game, Line, Column int
+	Err                string
+}
+
+func (e *PGNError) Error() string {
+	return fmt.Sprintf("game %d, line %d, column %d: %s", e.Game, e.Line, e.Column, e.Err)
+}
+
+// ReadPGN reads all the games in a PGN file.
+func ReadPGN(r io.Reader) ([]*PGNGame, error) {
+	var games []*PGNGame
+	reader := NewPGNReader(r)
+	for {
+		game, err := reader.Read()
+		if err == io.EOF {
+			return games, nil
+		}
+		if err != nil {
+			return games, err
+		}
+		games = append(games, game)
+	}
+}
+
+// PGNReader reads games from a PGN file one at a time. Every move is checked to be legal.
+type PGNReader struct {
+	lex   pgnLexer
+	games int
+	// failed is set after an error, so that the next Read skips the rest of the game.
+	failed bool
+}
+
+// NewPGNReader returns a PGNReader reading from r.
+func NewPGNReader(r io.Reader) *PGNReader {
+	return &PGNReader{lex: pgnLexer{r: bufio.NewReader(r), line: 1}}
+}
+
+// Read returns the next game, or io.EOF when there are no more. A game which lacks a result token, at the end of the
+// file or before the next game's tags, is given the result "*". After an error, Read carries on with the next game.
+func (r *PGNReader) Read() (*PGNGame, error) {
+	if r.failed {
+		// Skip to the next game's tags, which start at the beginning of a line.
+		for {
+			tok, err := r.lex.next()
+			if err != nil {
+				continue
+			}
+			if tok.kind == pgnEOF {
+				return nil, io.EOF
+			}
+			if tok.kind == '[' && tok.column == 1 {
+				r.lex.unread(tok)
+				break
+			}
+		}
+		r.failed = false
+	}
+
+	tok, err := r.lex.next()
+	if err != nil {
+		r.games++
+		return nil, r.error(err)
+	}
+	if tok.kind == pgnEOF {
+		return nil, io.EOF
+	}
+	r.games++
+	game, err := r.readGame(tok)
+	if err != nil {
+		r.failed = true
+		return nil, r.error(err)
+	}
+	return game, nil
+}
+
+// error locates an error in the current game.
+func (r *PGNReader) error(err error) error {
+	if e, ok := err.(*pgnSyntaxError); ok {
+		return &PGNError{r.games, e.line, e.column, e.msg}
+	}
+	return err
+}
+
+// pgnSyntaxError is an error found at a line and column of a PGN file.
+type pgnSyntaxError struct {
+	line, column int
+	msg          string
+}
+
+func (e *pgnSyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.line, e.column, e.msg)
+}
+
+func errorAt(tok pgnToken, format string, args ...interface{}) error {
+	return &pgnSyntaxError{tok.line, tok.column, fmt.Sprintf(format, args...)}
+}
+
+// readGame reads a game starting at its first token.
+func (r *PGNReader) readGame(tok pgnToken) (*PGNGame, error) {
+	game := &PGNGame{}
+	var err error
+	for tok.kind == '[' {
+		name, err := r.expect(pgnSymbol, "tag name")
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.expect(pgnString, "tag value")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.expect(']', "]"); err != nil {
+			return nil, err
+		}
+		game.Tags = append(game.Tags, PGNTag{name.text, value.text})
+		if tok, err = r.lex.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	p, side, err := game.StartPosition()
+	if err != nil {
+		return nil, errorAt(tok, "FEN tag: %v", err)
+	}
+	if game.PGNLine, tok, err = r.readLine(p, side, tok, 0); err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case pgnEOF, '[':
+		r.lex.unread(tok)
+		game.Result = "*"
+	default:
+		game.Result = tok.text
+	}
+	return game, nil
+}
+
+// expect reads a token of the given kind.
+func (r *PGNReader) expect(kind rune, what string) (pgnToken, error) {
+	tok, err := r.lex.next()
+	if err != nil {
+		return tok, err
+	}
+	if tok.kind != kind {
+		return tok, errorAt(tok, "expected %s, found %s", what, tok)
+	}
+	return tok, nil
+}
+
+// isPGNResult reports whether a token is a game termination marker.
+func isPGNResult(tok pgnToken) bool {
+	return tok.kind == '*' || tok.kind == pgnSymbol && (tok.text == "1-0" || tok.text == "0-1" || tok.text == "1/2-1/2")
+}
+
+// readLine reads a line of moves played from the position, starting at tok, which may be a variation nested depth
+// deep. It returns the token which ended the line: a result or the start of the next game for the main line, and
+// the closing parenthesis for a variation. The position is left unchanged.
+func (r *PGNReader) readLine(p *Position, side Side, tok pgnToken, depth int) (PGNLine, pgnToken, error) {
+	var line PGNLine
+	var undos []moveUndo
+	defer func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			p.unmakeMove(undos[i])
+		}
+	}()
+
+	for {
+		switch {
+		case isPGNResult(tok) || tok.kind == pgnEOF || tok.kind == '[':
+			if depth > 0 {
+				return line, tok, errorAt(tok, "unterminated variation")
+			}
+			return line, tok, nil
+		case tok.kind == ')':
+			if depth == 0 {
+				return line, tok, errorAt(tok, "unexpected )")
+			}
+			return line, tok, nil
+		case tok.kind == '.':
+		case tok.kind == pgnSymbol && isNumber(tok.text):
+			// A move number.
+		case tok.kind == pgnSymbol:
+			move, err := p.ParseSAN(tok.text, side)
+			if err != nil {
+				return line, tok, errorAt(tok, "%v", err)
+			}
+			line.Moves = append(line.Moves, move)
+			undos = append(undos, p.makeMove(move))
+			side = side.OppSide()
+		case tok.kind == pgnComment:
+			if len(line.Moves) == 0 {
+				line.Comment = joinComments(line.Comment, tok.text)
+			} else {
+				annotation := line.annotation()
+				annotation.Comment = joinComments(annotation.Comment, tok.text)
+			}
+		case tok.kind == pgnNAG || tok.kind == pgnSuffix:
+			nag, ok := pgnSuffixes[tok.text]
+			if tok.kind == pgnNAG {
+				var err error
+				nag, err = strconv.Atoi(tok.text)
+				ok = err == nil && nag >= 0 && nag <= 255
+			}
+			if !ok {
+				return line, tok, errorAt(tok, "invalid annotation %s", tok)
+			}
+			if len(line.Moves) == 0 {
+				return line, tok, errorAt(tok, "annotation before any move")
+			}
+			annotation := line.annotation()
+			annotation.NAGs = append(annotation.NAGs, nag)
+		case tok.kind == '(':
+			if len(line.Moves) == 0 {
+				return line, tok, errorAt(tok, "variation before any move")
+			}
+			// A variation is an alternative to the last move, so it's played from the position before it.
+			last := len(undos) - 1
+			p.unmakeMove(undos[last])
+			start, err := r.lex.next()
+			if err != nil {
+				return line, start, err
+			}
+			variation, end, err := r.readLine(p, side.OppSide(), start, depth+1)
+			if err != nil {
+				undos = undos[:last]
+				return line, end, err
+			}
+			undos[last] = p.makeMove(line.Moves[len(line.Moves)-1])
+			annotation := line.annotation()
+			annotation.Variations = append(annotation.Variations, variation)
+		default:
+			return line, tok, errorAt(tok, "unexpected %s", tok)
+		}
+
+		var err error
+		if tok, err = r.lex.next(); err != nil {
+			return line, tok, err
+		}
+	}
+}
+
+// joinComments joins two comments on the same move. Whitespace within them isn't kept, since comments are rewrapped
+// when written.
+func joinComments(a, b string) string {
+	b = strings.Join(strings.Fields(b), " ")
+	if a == "" {
+		return b
+	}
+	return a + " " + b
+}
+
+// Token kinds. Punctuation tokens are their own kind.
+const (
+	pgnEOF     rune = 0
+	pgnSymbol  rune = 's'
+	pgnString  rune = '"'
+	pgnComment rune = '{'
+	pgnNAG     rune = '$'
+	pgnSuffix  rune = '!'
+)
+
+// pgnToken is a token of a PGN file.
+type pgnToken struct {
+	kind         rune
+	text         string
+	line, column int
+}
+
+func (t pgnToken) String() string {
+	switch t.kind {
+	case pgnEOF:
+		return "end of file"
+	case pgnSymbol, pgnSuffix:
+		return strconv.Quote(t.text)
+	case pgnString:
+		return "string " + strconv.Quote(t.text)
+	case pgnComment:
+		return "comment"
+	case pgnNAG:
+		return "$" + t.text
+	default:
+		return string(t.kind)
+	}
+}
+
+// pgnLexer splits a PGN file into tokens.
+type pgnLexer struct {
+	r *bufio.Reader
+	// line and column locate the last rune read, and prevLine and prevColumn the one before.
+	line, column         int
+	prevLine, prevColumn int
+	peeked               *pgnToken
+}
+
+func (l *pgnLexer) read() (rune, bool) {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0, false
+	}
+	l.prevLine, l.prevColumn = l.line, l.column
+	if c == '\n' {
+		l.line++
+		l.column = 0
+	} else {
+		l.column++
+	}
+	return c, true
+}
+
+func (l *pgnLexer) unreadRune() {
+	l.r.UnreadRune()
+	l.line, l.column = l.prevLine, l.prevColumn
+}
+
+// unread pushes a token back, to be returned by the next call to next.
+func (l *pgnLexer) unread(tok pgnToken) {
+	l.peeked = &tok
+}
+
+func isPGNSymbolRune(c rune) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_+#=:-/", c))
+}
+
+// next returns the next token.
+func (l *pgnLexer) next() (pgnToken, error) {
+	if l.peeked != nil {
+		tok := *l.peeked
+		l.peeked = nil
+		return tok, nil
+	}
+	return l.scan()
+}
+
+func (l *pgnLexer) scan() (pgnToken, error) {
+	for {
+		c, ok := l.read()
+		if !ok {
+			return pgnToken{kind: pgnEOF, line: l.line, column: l.column + 1}, nil
+		}
+		tok := pgnToken{kind: c, line: l.line, column: l.column}
+		switch {
+		case unicode.IsSpace(c):
+		case c == '%' && l.column == 1, c == ';':
+			// An escaped line, or a comment to the end of the line.
+			var text strings.Builder
+			for c, ok = l.read(); ok && c != '\n'; c, ok = l.read() {
+				text.WriteRune(c)
+			}
+			if tok.kind == ';' {
+				tok.kind, tok.text = pgnComment, text.String()
+				return tok, nil
+			}
+		case c == '{':
+			var text strings.Builder
+			for c, ok = l.read(); ok && c != '}'; c, ok = l.read() {
+				text.WriteRune(c)
+			}
+			if !ok {
+				return tok, errorAt(tok, "unterminated comment")
+			}
+			tok.text = text.String()
+			return tok, nil
+		case c == '"':
+			var text strings.Builder
+			for c, ok = l.read(); ok && c != '"'; c, ok = l.read() {
+				if c == '\\' {
+					if c, ok = l.read(); !ok {
+						break
+					}
+				}
+				text.WriteRune(c)
+			}
+			if !ok {
+				return tok, errorAt(tok, "unterminated string")
+			}
+			tok.text = text.String()
+			return tok, nil
+		case c == '$':
+			tok.text = l.scanWhile(unicode.IsDigit)
+			return tok, nil
+		case c == '!' || c == '?':
+			tok.kind = pgnSuffix
+			tok.text = string(c) + l.scanWhile(func(c rune) bool { return c == '!' || c == '?' })
+			return tok, nil
+		case strings.ContainsRune("[]().*", c):
+			tok.text = string(c)
+			return tok, nil
+		case isPGNSymbolRune(c):
+			tok.kind = pgnSymbol
+			tok.text = string(c) + l.scanWhile(isPGNSymbolRune)
+			return tok, nil
+		default:
+			return tok, errorAt(tok, "unexpected character %q", c)
+		}
+	}
+}
+
+// scanWhile reads runes while they satisfy f.
+func (l *pgnLexer) scanWhile(f func(rune) bool) string {
+	var text strings.Builder
+	for {
+		c, ok := l.read()
+		if !ok {
+			return text.String()
+		}
+		if !f(c) {
+			l.unreadRune()
+			return text.String()
+		}
+		text.WriteRune(c)
+	}
+}
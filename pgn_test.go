@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const annotatedPGN = `[Event "The \"Immortal\" \\ Game"]
+[Site "London"]
+[Date "1851.06.21"]
+[Round "?"]
+[White "Anderssen, Adolf"]
+[Black "Kieseritzky, Lionel"]
+[Result "1-0"]
+[ECO "C33"]
+
+{King's Gambit} 1. e4 e5 2. f4 exf4 3. Bc4 Qh4+ 4. Kf1 b5 $2 ; a wild line
+5. Bxb5 Nf6 6. Nf3 Qh6 (6... Qh5 7. d3 (7. Nc3 Bb7!?) 7... Bb7) 7. d3 Nh5 8. Nh4!
+Qg5 9. Nf5 c6 10. g4 Nf6 11. Rg1 cxb5 12. h4 Qg6 13. h5 Qg5 14. Qf3 Ng8 15. Bxf4
+Qf6 16. Nc3 Bc5 17. Nd5 Qxb2 18. Bd6 Bxg1 {It is doubtful whether Black should have
+taken the second rook.} 19. e5 Qxa1+ 20. Ke2 Na6 21. Nxg7+ Kd8 22. Qf6+ Nxf6 23. Be7# 1-0
+`
+
+// annotatedPGNWritten is annotatedPGN as WritePGN writes it, with the suffix annotations as NAGs and movetext wrapped
+// to 80 columns.
+const annotatedPGNWritten = `[Event "The \"Immortal\" \\ Game"]
+[Site "London"]
+[Date "1851.06.21"]
+[Round "?"]
+[White "Anderssen, Adolf"]
+[Black "Kieseritzky, Lionel"]
+[Result "1-0"]
+[ECO "C33"]
+
+{King's Gambit} 1. e4 e5 2. f4 exf4 3. Bc4 Qh4+ 4. Kf1 b5 $2 {a wild line} 5.
+Bxb5 Nf6 6. Nf3 Qh6 (6... Qh5 7. d3 (7. Nc3 Bb7 $5) 7... Bb7) 7. d3 Nh5 8. Nh4
+$1 Qg5 9. Nf5 c6 10. g4 Nf6 11. Rg1 cxb5 12. h4 Qg6 13. h5 Qg5 14. Qf3 Ng8 15.
+Bxf4 Qf6 16. Nc3 Bc5 17. Nd5 Qxb2 18. Bd6 Bxg1 {It is doubtful whether Black
+should have taken the second rook.} 19. e5 Qxa1+ 20. Ke2 Na6 21. Nxg7+ Kd8 22.
+Qf6+ Nxf6 23. Be7# 1-0
+
+`
+
+func readOnePGN(t *testing.T, text string) *PGNGame {
+	t.Helper()
+	games, err := ReadPGN(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 {
+		t.Fatalf("read %d games, want 1", len(games))
+	}
+	return games[0]
+}
+
+func TestPGNRead(t *testing.T) {
+	game := readOnePGN(t, annotatedPGN)
+	if event := game.Tag("Event"); event != `The "Immortal" \ Game` {
+		t.Errorf("Event tag %q", event)
+	}
+	if game.Result != "1-0" || len(game.Moves) != 45 || game.Comment != "King's Gambit" {
+		t.Errorf("result %s, %d moves, comment %q", game.Result, len(game.Moves), game.Comment)
+	}
+	// A comment's line breaks are dropped.
+	if comment := game.Annotations[35].Comment; comment != "It is doubtful whether Black should have taken the second rook." {
+		t.Errorf("18... Bxg1 comment %q", comment)
+	}
+
+	// 4... b5 $2 ; a wild line
+	if a := game.Annotations[7]; !reflect.DeepEqual(a.NAGs, []int{2}) || a.Comment != "a wild line" {
+		t.Errorf("4... b5 annotated %+v", a)
+	}
+	// 8. Nh4!
+	if a := game.Annotations[14]; !reflect.DeepEqual(a.NAGs, []int{1}) {
+		t.Errorf("8. Nh4 annotated %+v", a)
+	}
+	// (6... Qh5 7. d3 (7. Nc3 Bb7!?) 7... Bb7)
+	variations := game.Annotations[11].Variations
+	if len(variations) != 1 || len(variations[0].Moves) != 3 {
+		t.Fatalf("6... Qh6 has variations %+v", variations)
+	}
+	nested := variations[0].Annotations[1].Variations
+	if len(nested) != 1 || len(nested[0].Moves) != 2 || !reflect.DeepEqual(nested[0].Annotations[1].NAGs, []int{5}) {
+		t.Errorf("7. d3 has variations %+v", nested)
+	}
+}
+
+func TestPGNWrite(t *testing.T) {
+	game := readOnePGN(t, annotatedPGN)
+	var buf bytes.Buffer
+	if err := WritePGN(&buf, game); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != annotatedPGNWritten {
+		t.Errorf("wrote\n%s\nwant\n%s", buf.String(), annotatedPGNWritten)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > pgnLineLength {
+			t.Errorf("line of %d characters: %s", len(line), line)
+		}
+	}
+
+	// What's written reads back the same.
+	if again := readOnePGN(t, buf.String()); !reflect.DeepEqual(again, game) {
+		t.Errorf("read back %+v, want %+v", again, game)
+	}
+}
+
+// The seven tag roster is written even when a game lacks those tags, and the Result tag follows the result.
+func TestPGNWriteRoster(t *testing.T) {
+	game := &PGNGame{Tags: []PGNTag{{"Annotator", "RobChess"}, {"Result", "1-0"}, {"White", "A"}}}
+	p, side, _ := ParseFEN(StartFEN)
+	move, _ := p.ParseSAN("e4", side)
+	game.Moves = []Move{move}
+	var buf bytes.Buffer
+	if err := WritePGN(&buf, game); err != nil {
+		t.Fatal(err)
+	}
+	want := `[Event "?"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "A"]
+[Black "?"]
+[Result "*"]
+[Annotator "RobChess"]
+
+1. e4 *
+
+`
+	if buf.String() != want {
+		t.Errorf("wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestPGNErrors(t *testing.T) {
+	text := `[Event "First"]
+
+1. e4 e5 2. Nf3 *
+
+[Event "Second"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "?"]
+[Black "?"]
+[Result "*"]
+[Opening "Queen's Gambit Declined"]
+
+1. d4 d5 2. c4 e6 3. Nc3 Nf6
+4. cxd5 exd5 5. Kxe8 *
+
+[Event "Third"]
+
+1. c4 *
+`
+	r := NewPGNReader(strings.NewReader(text))
+	if game, err := r.Read(); err != nil || game.Tag("Event") != "First" {
+		t.Fatalf("first game %v, %v", game, err)
+	}
+	_, err := r.Read()
+	want := &PGNError{Game: 2, Line: 15, Column: 17}
+	if e, ok := err.(*PGNError); !ok || e.Game != want.Game || e.Line != want.Line || e.Column != want.Column {
+		t.Errorf("second game: error %v, want one at game 2, line 15, column 17", err)
+	}
+	// Reading carries on with the next game.
+	if game, err := r.Read(); err != nil || game.Tag("Event") != "Third" {
+		t.Errorf("third game %v, %v", game, err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("after the last game: %v, want EOF", err)
+	}
+
+	tests := []struct {
+		text         string
+		line, column int
+	}{
+		{"1. e4 (1. d4 d5 *", 1, 17},
+		{"1. e4 e5 )", 1, 10},
+		{"( 1. e4 ) *", 1, 1},
+		{"1. e4 {unterminated", 1, 7},
+		{"[Event \"unterminated]\n", 1, 8},
+		{"1. e4 $ *", 1, 7},
+		{"1. e4 e5 2. Nf3 &", 1, 17},
+		{"[FEN \"8/8/8/8/8/8/8/8 w - - 0 1\"]\n\n1. e4 *", 3, 1},
+	}
+	for _, test := range tests {
+		_, err := ReadPGN(strings.NewReader(test.text))
+		if e, ok := err.(*PGNError); !ok || e.Game != 1 || e.Line != test.line || e.Column != test.column {
+			t.Errorf("%q: error %v, want one at line %d, column %d", test.text, err, test.line, test.column)
+		}
+	}
+}
+
+// A game without a result token is unfinished.
+func TestPGNMissingResult(t *testing.T) {
+	games, err := ReadPGN(strings.NewReader("[Event \"A\"]\n\n1. e4\n\n[Event \"B\"]\n\n1. d4"))
+	if err != nil || len(games) != 2 {
+		t.Fatalf("read %d games, %v", len(games), err)
+	}
+	for _, game := range games {
+		if game.Result != "*" || len(game.Moves) != 1 {
+			t.Errorf("game %s: result %s, %d moves", game.Tag("Event"), game.Result, len(game.Moves))
+		}
+	}
+}
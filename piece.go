@@ -65,23 +65,3 @@ func (p GamePiece) String() string {
 	}
 	return color.RedString(pieceStr)
 }
-
-// Value returns the value of the piece. For now, the value is the classical chess piece value.
-func (p GamePiece) Value() float64 {
-	switch p.piece {
-	case Pawn:
-		return 1
-	case Rook:
-		return 5
-	case Knight:
-		return 3
-	case Bishop:
-		return 3
-	case Queen:
-		return 9
-	case King:
-		return 200
-	default:
-		return 1
-	}
-}
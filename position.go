@@ -11,6 +11,9 @@ type Square struct {
 	rank int
 }
 
+// noSquare is used in place of a square when there is none, e.g. when no en passant capture is possible.
+var noSquare = Square{-1, -1}
+
 // Position represents a chess position representation.
 type Position struct {
 	board               [][]GamePiece
@@ -18,6 +21,24 @@ type Position struct {
 	canCastleShortWhite bool
 	canCastleLongBlack  bool
 	canCastleShortBlack bool
+	enPassant           Square // The square a pawn may capture onto en passant, or noSquare.
+	halfMoveClock       int    // Plies since the last capture or pawn move.
+	fullMoveNumber      int    // Starts at 1 and is incremented after each of black's moves.
+
+	// nnue, when set, holds a network's accumulators, which makeMove and unmakeMove keep up to date with the board.
+	nnue *nnueState
+}
+
+// moveUndo records everything needed to take back a move made with makeMove.
+type moveUndo struct {
+	move           Move
+	piece          GamePiece
+	captured       GamePiece
+	captureSquare  Square
+	castling       [4]bool
+	enPassant      Square
+	halfMoveClock  int
+	fullMoveNumber int
 }
 
 // NewPosition creates and initializes a new Position with the starting arrangement of pieces.
@@ -28,7 +49,7 @@ func NewPosition() *Position {
 		board[i] = make([]GamePiece, 8, 8)
 	}
 
-	pos := Position{board, true, true, true, true}
+	pos := Position{board: board}
 
 	pos.Reset()
 	return &pos
@@ -36,16 +57,23 @@ func NewPosition() *Position {
 
 // Copy makes a copy of a position
 func (p Position) Copy() *Position {
-	newPos := Position{make([][]GamePiece, len(p.board)), true, true, true, true}
+	newPos := p
+	newPos.board = make([][]GamePiece, len(p.board))
 	for i := range newPos.board {
 		newPos.board[i] = make([]GamePiece, len(p.board[i]))
 		copy(newPos.board[i], p.board[i])
 	}
+	newPos.nnue = nil
 	return &newPos
 }
 
 // Reset resets the chess position to the starting chess arrangement.
 func (p *Position) Reset() {
+	p.canCastleLongWhite, p.canCastleShortWhite = true, true
+	p.canCastleLongBlack, p.canCastleShortBlack = true, true
+	p.enPassant = noSquare
+	p.halfMoveClock = 0
+	p.fullMoveNumber = 1
 	for r := 0; r < 8; r++ {
 		for f := 0; f < 8; f++ {
 			switch {
@@ -152,22 +180,21 @@ func (p *Position) GetMovesAt(f, r int, side Side) []Move {
 }
 
 func causesCheck(p *Position, move Move, side Side) bool {
-	oldPiece := p.board[move.oRank][move.oFile]
-	capturedPiece := p.board[move.nRank][move.nFile]
-	toReturn := false
-
-	p.MakeMove(move)
+	undo := p.makeMove(move)
 	kingSquare := getKingSquare(p, side)
-	if inCheck(*p, kingSquare.file, kingSquare.rank, side) {
-		toReturn = true
-	}
+	toReturn := inCheck(*p, kingSquare.file, kingSquare.rank, side)
 
 	// Roll back move
-	p.board[move.oRank][move.oFile] = oldPiece
-	p.board[move.nRank][move.nFile] = capturedPiece
+	p.unmakeMove(undo)
 	return toReturn
 }
 
+// InCheck reports whether side's king is attacked.
+func (p *Position) InCheck(side Side) bool {
+	kingSquare := getKingSquare(p, side)
+	return inCheck(*p, kingSquare.file, kingSquare.rank, side)
+}
+
 func getKingSquare(p *Position, side Side) Square {
 	for r := range p.board {
 		for f := range p.board[r] {
@@ -179,54 +206,56 @@ func getKingSquare(p *Position, side Side) Square {
 	panic(fmt.Sprintf("getKingSquare in %v returned no square for side %v.", p, side))
 }
 
-// TODO account for en passant. Investigate if the engine knows how to move black pawns.
 // Gets possible pawn moves starting at a specific square.
 func (p *Position) getPawnMoves(f, r int, side Side) []Move {
-	// A pawn can have a maximum of 4 moves (on promotion)
+	// A pawn can have a maximum of 12 moves (three destinations on promotion, each with four pieces)
 	moves := make([]Move, 0, 4)
 
-	// Define rank increment direction.
-	var rIncr int
-	if side == White {
-		rIncr = 1
-	} else {
-		rIncr = -1
+	// Define rank increment direction, and the ranks the pawn starts, captures en passant and promotes on.
+	rIncr, startRank, epRank, lastRank := 1, 1, 5, 7
+	if side == Black {
+		rIncr, startRank, epRank, lastRank = -1, 6, 2, 0
 	}
 
-	// TODO remove and teach about promotion
-	if r == 7 && side == White || r == 0 && side == Black {
+	if r == lastRank {
 		return moves
 	}
 
 	// Possible forward moves
-	if r == 1 && side == White || r == 6 && side == Black {
-		if p.board[r+rIncr][f].piece == None {
-			moves = append(moves, Move{f, r, f, r + rIncr, ""})
-			if p.board[r+rIncr*2][f].piece == None {
-				moves = append(moves, Move{f, r, f, r + rIncr*2, ""})
-			}
-		}
-	} else if r > 1 && side == White || r < 6 && side == Black {
-		if p.board[r+rIncr][f].piece == None {
-			moves = append(moves, Move{f, r, f, r + rIncr, ""})
+	if p.board[r+rIncr][f].piece == None {
+		moves = appendPawnMove(moves, Move{f, r, f, r + rIncr, ""}, lastRank)
+		if r == startRank && p.board[r+rIncr*2][f].piece == None {
+			moves = append(moves, Move{f, r, f, r + rIncr*2, ""})
 		}
 	}
 
-	// Possible captures
-	if f-1 >= 0 {
-		if p.board[r+rIncr][f-1].piece != None && p.board[r+rIncr][f-1].color != side {
-			moves = append(moves, Move{f, r, f - 1, r + rIncr, ""})
+	// Possible captures, including en passant
+	for _, nf := range [...]int{f - 1, f + 1} {
+		if nf < 0 || nf > 7 {
+			continue
 		}
-	}
-	if f+1 <= 7 {
-		if p.board[r+rIncr][f+1].piece != None && p.board[r+rIncr][f+1].color != side {
-			moves = append(moves, Move{f, r, f + 1, r + rIncr, ""})
+		target := p.board[r+rIncr][nf]
+		if target.piece != None && target.color != side ||
+			r+rIncr == epRank && p.enPassant == (Square{nf, r + rIncr}) {
+			moves = appendPawnMove(moves, Move{f, r, nf, r + rIncr, ""}, lastRank)
 		}
 	}
 
 	return moves
 }
 
+// appendPawnMove appends a pawn move, expanding it into each possible promotion when the pawn reaches the last rank.
+func appendPawnMove(moves []Move, move Move, lastRank int) []Move {
+	if move.nRank != lastRank {
+		return append(moves, move)
+	}
+	for _, promoPiece := range [...]string{"q", "r", "b", "n"} {
+		move.promoPiece = promoPiece
+		moves = append(moves, move)
+	}
+	return moves
+}
+
 // Get possible rook moves for a rook located at file r and rank f of color side.
 func (p *Position) getRookMoves(f, r int, side Side) []Move {
 	moves := make([]Move, 0, 20)
@@ -368,11 +397,42 @@ func (p *Position) getKingMoves(f, r int, side Side) []Move {
 		moves = append(moves, Move{f, r, f + 1, r + 1, ""})
 	}
 
-	// TODO Castle
+	// Castling. The king may not castle out of or through check. Castling into check is pruned along with other moves
+	// that lead to checks.
+	homeRank := 0
+	if side == Black {
+		homeRank = 7
+	}
+	if f == 4 && r == homeRank && !inCheck(*p, f, r, side) {
+		rook := GamePiece{Rook, side}
+		if p.canCastleShort(side) && p.board[r][7] == rook &&
+			p.board[r][5].piece == None && p.board[r][6].piece == None && !inCheck(*p, 5, r, side) {
+			moves = append(moves, Move{f, r, 6, r, ""})
+		}
+		if p.canCastleLong(side) && p.board[r][0] == rook &&
+			p.board[r][1].piece == None && p.board[r][2].piece == None && p.board[r][3].piece == None &&
+			!inCheck(*p, 3, r, side) {
+			moves = append(moves, Move{f, r, 2, r, ""})
+		}
+	}
 
 	return moves
 }
 
+func (p *Position) canCastleShort(side Side) bool {
+	if side == White {
+		return p.canCastleShortWhite
+	}
+	return p.canCastleShortBlack
+}
+
+func (p *Position) canCastleLong(side Side) bool {
+	if side == White {
+		return p.canCastleLongWhite
+	}
+	return p.canCastleLongBlack
+}
+
 /* lookUp and other look functions look in a direction on the board from a starting square. When another piece is encountered, the function returns
 with the squares traversed and the collision piece. */
 func (p *Position) lookUp(f, r int) ([]Square, *GamePiece) {
@@ -564,6 +624,28 @@ func inCheck(p Position, f, r int, side Side) bool {
 			return true
 		}
 	}
+
+	// Pawns attack diagonally forward, so an opposing pawn attacks from the rank ahead of us.
+	pawnRank := r + 1
+	if side == Black {
+		pawnRank = r - 1
+	}
+	if pawnRank >= 0 && pawnRank < 8 {
+		for _, pf := range [...]int{f - 1, f + 1} {
+			if pf >= 0 && pf < 8 && p.board[pawnRank][pf] == (GamePiece{Pawn, side.OppSide()}) {
+				return true
+			}
+		}
+	}
+
+	// Kings attack adjacent squares.
+	for kr := r - 1; kr <= r+1; kr++ {
+		for kf := f - 1; kf <= f+1; kf++ {
+			if kr >= 0 && kr < 8 && kf >= 0 && kf < 8 && p.board[kr][kf] == (GamePiece{King, side.OppSide()}) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
@@ -581,28 +663,49 @@ func (p *Position) GetPieces(side Side) []GamePiece {
 	return pieces
 }
 
-// SumMaterial performs a rudimentary sum of the material using the classic chess piece values.
-func (p *Position) SumMaterial(pieces []GamePiece) float64 {
-	var sum float64
-	for _, piece := range pieces {
-		if piece.piece != None {
-			sum += piece.Value()
+// InsufficientMaterial reports whether neither side has the material to checkmate: a lone king against a king with at
+// most one minor piece, or kings with bishops all on squares of one color.
+func (p *Position) InsufficientMaterial() bool {
+	knights, bishops := 0, 0
+	bishopColors := [2]bool{}
+	for r := range p.board {
+		for f := range p.board[r] {
+			switch p.board[r][f].piece {
+			case Pawn, Rook, Queen:
+				return false
+			case Knight:
+				knights++
+			case Bishop:
+				bishops++
+				bishopColors[(r+f)%2] = true
+			}
 		}
 	}
-	return sum
+	if knights+bishops <= 1 {
+		return true
+	}
+	return knights == 0 && !(bishopColors[0] && bishopColors[1])
 }
 
-// CentralControl returns a simple count of the number of squares in the center occupied by the specified side's pieces.
-func (p *Position) CentralControl(side Side) float64 {
-	count := 0.0
-	for r := 2; r < 5; r++ {
-		for f := 2; f < 5; f++ {
-			if p.board[r][f].color == side {
-				count++
+// hasMatingMaterial reports whether side has more than a lone king or a king and one knight or bishop. Without it a
+// side can't mate, so its opponent's flag falling draws rather than losing.
+func (p *Position) hasMatingMaterial(side Side) bool {
+	minors := 0
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			if piece.color != side {
+				continue
+			}
+			switch piece.piece {
+			case Pawn, Rook, Queen:
+				return true
+			case Knight, Bishop:
+				minors++
 			}
 		}
 	}
-	return count
+	return minors > 1
 }
 
 // MakeMove modifies the given position to represent the position after the move is made.
@@ -614,11 +717,122 @@ func (p *Position) MakeMove(move Move) bool {
 		return false
 	}
 
-	// TODO handle promotion
+	p.makeMove(move)
+	return true
+}
 
-	// Make normal move
+// makeMove makes a move without validation and returns what is needed to take it back with unmakeMove.
+func (p *Position) makeMove(move Move) moveUndo {
+	of, or := move.oFile, move.oRank
+	nf, nr := move.nFile, move.nRank
 	piece := p.board[or][of]
+	undo := moveUndo{
+		move:          move,
+		piece:         piece,
+		captured:      p.board[nr][nf],
+		captureSquare: Square{nf, nr},
+		castling: [4]bool{p.canCastleLongWhite, p.canCastleShortWhite,
+			p.canCastleLongBlack, p.canCastleShortBlack},
+		enPassant:      p.enPassant,
+		halfMoveClock:  p.halfMoveClock,
+		fullMoveNumber: p.fullMoveNumber,
+	}
+
+	switch {
+	case piece.piece == Pawn && nf != of && p.board[nr][nf].piece == None:
+		// En passant removes the pawn which passed us rather than a piece on the destination square.
+		undo.captureSquare = Square{nf, or}
+		undo.captured = p.board[or][nf]
+		p.board[or][nf] = GamePiece{None, White}
+	case piece.piece == King && nf-of == 2:
+		p.board[or][5] = p.board[or][7]
+		p.board[or][7] = GamePiece{None, White}
+	case piece.piece == King && of-nf == 2:
+		p.board[or][3] = p.board[or][0]
+		p.board[or][0] = GamePiece{None, White}
+	}
+
+	// Make normal move
 	p.board[or][of] = GamePiece{None, White}
+	if piece.piece == Pawn && (nr == 7 || nr == 0) {
+		piece.piece = promotionPiece(move.promoPiece)
+	}
 	p.board[nr][nf] = piece
-	return true
+
+	// Moving the king or a rook, or capturing a rook, gives up castling on that side.
+	if piece.piece == King {
+		if piece.color == White {
+			p.canCastleLongWhite, p.canCastleShortWhite = false, false
+		} else {
+			p.canCastleLongBlack, p.canCastleShortBlack = false, false
+		}
+	}
+	for _, s := range [...]Square{{of, or}, {nf, nr}} {
+		switch s {
+		case Square{0, 0}:
+			p.canCastleLongWhite = false
+		case Square{7, 0}:
+			p.canCastleShortWhite = false
+		case Square{0, 7}:
+			p.canCastleLongBlack = false
+		case Square{7, 7}:
+			p.canCastleShortBlack = false
+		}
+	}
+
+	p.enPassant = noSquare
+	if piece.piece == Pawn && (nr-or == 2 || or-nr == 2) {
+		p.enPassant = Square{of, (or + nr) / 2}
+	}
+	if undo.piece.piece == Pawn || undo.captured.piece != None {
+		p.halfMoveClock = 0
+	} else {
+		p.halfMoveClock++
+	}
+	if piece.color == Black {
+		p.fullMoveNumber++
+	}
+	if p.nnue != nil {
+		p.nnue.push(p, undo)
+	}
+	return undo
+}
+
+// unmakeMove takes back a move made with makeMove.
+func (p *Position) unmakeMove(undo moveUndo) {
+	move := undo.move
+	p.board[move.nRank][move.nFile] = GamePiece{None, White}
+	p.board[undo.captureSquare.rank][undo.captureSquare.file] = undo.captured
+	p.board[move.oRank][move.oFile] = undo.piece
+
+	if undo.piece.piece == King && move.nFile-move.oFile == 2 {
+		p.board[move.oRank][7] = p.board[move.oRank][5]
+		p.board[move.oRank][5] = GamePiece{None, White}
+	} else if undo.piece.piece == King && move.oFile-move.nFile == 2 {
+		p.board[move.oRank][0] = p.board[move.oRank][3]
+		p.board[move.oRank][3] = GamePiece{None, White}
+	}
+
+	p.canCastleLongWhite, p.canCastleShortWhite = undo.castling[0], undo.castling[1]
+	p.canCastleLongBlack, p.canCastleShortBlack = undo.castling[2], undo.castling[3]
+	p.enPassant = undo.enPassant
+	p.halfMoveClock = undo.halfMoveClock
+	p.fullMoveNumber = undo.fullMoveNumber
+	if p.nnue != nil {
+		p.nnue.pop()
+	}
+}
+
+// promotionPiece returns the piece named by a move's promotion component. Pawns promote to a queen when none is given.
+func promotionPiece(promoPiece string) Piece {
+	switch promoPiece {
+	case "r":
+		return Rook
+	case "b":
+		return Bishop
+	case "n":
+		return Knight
+	default:
+		return Queen
+	}
 }
@@ -0,0 +1,934 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Generated tables are stored one per file, named by their material, e.g. KRvKP.dtm. A file starts with the magic
+// "RCTB", a version byte and the material as a string prefixed by its length in a byte. The table's values follow,
+// one byte for each position index, compressed with DEFLATE.
+const (
+	retroMagic   = "RCTB"
+	retroVersion = 1
+	retroExt     = ".dtm"
+)
+
+// The values of positions in a generated table. Any other value is one more than the number of plies to mate with best
+// play, which is odd when the side to move mates and even when it's mated.
+const (
+	retroDraw    = 0
+	retroIllegal = 255
+)
+
+// maxRetroPieces is the most pieces, kings included, of the tables which may be generated.
+const maxRetroPieces = 4
+
+// DefaultRetroTables are the tables tbgen generates when none are named.
+var DefaultRetroTables = []string{"KQvK", "KRvK", "KPvK", "KBNvK", "KRvKP"}
+
+// retroSymmetries maps each square to its image under the symmetries of the board: the identity, the reflections and
+// the rotations. The first two, the identity and the reflection between the a and h files, are the only ones which
+// respect pawns.
+var retroSymmetries [8][64]int
+
+func init() {
+	for sq := 0; sq < 64; sq++ {
+		f, r := sq%8, sq/8
+		for i, image := range [8][2]int{{f, r}, {7 - f, r}, {f, 7 - r}, {7 - f, 7 - r}, {r, f}, {7 - r, f},
+			{r, 7 - f}, {7 - r, 7 - f}} {
+			retroSymmetries[i][sq] = image[1]*8 + image[0]
+		}
+	}
+}
+
+// retroTable is the table of one material, holding a value for each position with the stronger side as White.
+// Positions are indexed by the side to move and the squares of the pieces, the white king first. Symmetry brings the
+// white king to the a1-d1-d4 triangle, or to the a-d files when there are pawns, and of the indices of a position and
+// its reflections only the smallest holds its value.
+type retroTable struct {
+	Material string
+	// pieces lists the pieces in the order of the index: the kings, then White's pieces and Black's, each from the
+	// most valuable.
+	pieces      []GamePiece
+	kingSquares []int
+	kingIndex   [64]int
+	symmetries  int
+	values      []uint8
+}
+
+// parseRetroMaterial names the material of a table the way Syzygy does, with the stronger side first, e.g. KRvKP.
+func parseRetroMaterial(name string) (string, error) {
+	if _, ok := syzygyPieceCount(name); !ok {
+		return "", fmt.Errorf("%q is not a material such as KRvKP", name)
+	}
+	sides := strings.Split(name, "v")
+	for i, side := range sides {
+		letters := []byte(side)
+		sort.Slice(letters, func(i, j int) bool {
+			return strings.IndexByte(syzygyPieces, letters[i]) < strings.IndexByte(syzygyPieces, letters[j])
+		})
+		sides[i] = string(letters)
+	}
+	if syzygyStronger(sides[1], sides[0]) {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+	return sides[0] + "v" + sides[1], nil
+}
+
+// newRetroTable makes an empty table for the material, which is named with the stronger side first.
+func newRetroTable(material string) (*retroTable, error) {
+	sides := strings.Split(material, "v")
+	if len(sides[0])+len(sides[1]) > maxRetroPieces {
+		return nil, fmt.Errorf("%s: tables of more than %d pieces can't be generated", material, maxRetroPieces)
+	}
+	if strings.Contains(sides[0], "P") && strings.Contains(sides[1], "P") {
+		return nil, fmt.Errorf("%s: tables with pawns on both sides, where en passant matters, can't be generated",
+			material)
+	}
+
+	t := &retroTable{Material: material, pieces: []GamePiece{{King, White}, {King, Black}}}
+	for i, side := range sides {
+		for _, letter := range side[1:] {
+			piece := Pawn
+			if letter != 'P' {
+				piece = pieceFromSAN(byte(letter))
+			}
+			t.pieces = append(t.pieces, GamePiece{piece, Side(i)})
+		}
+	}
+	for i := range t.kingIndex {
+		t.kingIndex[i] = -1
+	}
+	t.symmetries = 8
+	for sq := 0; sq < 64; sq++ {
+		f, r := sq%8, sq/8
+		if strings.Contains(material, "P") {
+			if f > 3 {
+				continue
+			}
+		} else if f > 3 || r > f {
+			continue
+		}
+		t.kingIndex[sq] = len(t.kingSquares)
+		t.kingSquares = append(t.kingSquares, sq)
+	}
+	if strings.Contains(material, "P") {
+		t.symmetries = 2
+	}
+
+	size := 2 * len(t.kingSquares)
+	for range t.pieces[1:] {
+		size *= 64
+	}
+	t.values = make([]uint8, size)
+	return t, nil
+}
+
+// index returns the index of a position whose white king is on one of the table's king squares.
+func (t *retroTable) index(squares []int, side Side) int {
+	idx := int(side)*len(t.kingSquares) + t.kingIndex[squares[0]]
+	for _, sq := range squares[1:] {
+		idx = idx*64 + sq
+	}
+	return idx
+}
+
+// decode returns the squares of the pieces and the side to move of the position with the index.
+func (t *retroTable) decode(idx int, squares []int) Side {
+	for i := len(t.pieces) - 1; i > 0; i-- {
+		squares[i] = idx % 64
+		idx /= 64
+	}
+	squares[0] = t.kingSquares[idx%len(t.kingSquares)]
+	return Side(idx / len(t.kingSquares))
+}
+
+// canonical returns the index holding the value of a position: the smallest index of the position and its
+// reflections.
+func (t *retroTable) canonical(squares []int, side Side) int {
+	var image [maxRetroPieces]int
+	best := -1
+	for _, symmetry := range retroSymmetries[:t.symmetries] {
+		if t.kingIndex[symmetry[squares[0]]] < 0 {
+			continue
+		}
+		for i, sq := range squares {
+			image[i] = symmetry[sq]
+		}
+		// Identical pieces are ordered by square, so that swapping them doesn't give another index.
+		for i := 2; i < len(squares); i++ {
+			for j := i + 1; j < len(squares); j++ {
+				if t.pieces[i] == t.pieces[j] && image[j] < image[i] {
+					image[i], image[j] = image[j], image[i]
+				}
+			}
+		}
+		if idx := t.index(image[:len(squares)], side); best < 0 || idx < best {
+			best = idx
+		}
+	}
+	return best
+}
+
+// squares finds the squares of the table's pieces in a position of its material. mirror reverses the colors of the
+// position, for when Black is the stronger side.
+func (t *retroTable) squares(p *Position, mirror bool, squares []int) bool {
+	var found [maxRetroPieces]bool
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			if piece.piece == None {
+				continue
+			}
+			sq := r*8 + f
+			if mirror {
+				piece.color = piece.color.OppSide()
+				sq = (7-r)*8 + f
+			}
+			i := 0
+			for i < len(t.pieces) && (found[i] || t.pieces[i] != piece) {
+				i++
+			}
+			if i == len(t.pieces) {
+				return false
+			}
+			found[i], squares[i] = true, sq
+		}
+	}
+	for i := range t.pieces {
+		if !found[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// place puts the table's pieces on the squares of an empty board, reporting false, with the board left empty, when
+// two pieces share a square or a pawn stands on the first or last rank.
+func (t *retroTable) place(p *Position, squares []int) bool {
+	for i, sq := range squares {
+		r, f := sq/8, sq%8
+		if p.board[r][f].piece != None || t.pieces[i].piece == Pawn && (r == 0 || r == 7) {
+			t.clear(p, squares[:i])
+			return false
+		}
+		p.board[r][f] = t.pieces[i]
+	}
+	return true
+}
+
+// clear empties the squares.
+func (t *retroTable) clear(p *Position, squares []int) {
+	for _, sq := range squares {
+		p.board[sq/8][sq%8] = GamePiece{None, White}
+	}
+}
+
+// emptyPosition returns a position with no pieces, castling rights or en passant square.
+func emptyPosition() *Position {
+	p := NewPosition()
+	for r := range p.board {
+		for f := range p.board[r] {
+			p.board[r][f] = GamePiece{None, White}
+		}
+	}
+	p.canCastleLongWhite, p.canCastleShortWhite = false, false
+	p.canCastleLongBlack, p.canCastleShortBlack = false, false
+	return p
+}
+
+// RetrogradeTablebase probes the endgame tables RobChess generates itself by retrograde analysis, which hold the
+// distance to mate of every position of up to four pieces of the materials generated.
+type RetrogradeTablebase struct {
+	tables map[string]*retroTable
+}
+
+// NewRetrogradeTablebase returns a tablebase without tables, to generate them into.
+func NewRetrogradeTablebase() *RetrogradeTablebase {
+	return &RetrogradeTablebase{tables: make(map[string]*retroTable)}
+}
+
+// OpenRetrogradeTables loads the generated tables in dir.
+func OpenRetrogradeTables(dir string) (*RetrogradeTablebase, error) {
+	tb, err := loadRetroTables(dir)
+	if err == nil && len(tb.tables) == 0 {
+		err = fmt.Errorf("%s: no generated tables", dir)
+	}
+	return tb, err
+}
+
+// loadRetroTables loads the generated tables in dir, if any.
+func loadRetroTables(dir string) (*RetrogradeTablebase, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+retroExt))
+	if err != nil {
+		return nil, err
+	}
+	tb := NewRetrogradeTablebase()
+	for _, path := range paths {
+		t, err := loadRetroTable(path)
+		if err != nil {
+			return nil, err
+		}
+		tb.tables[t.Material] = t
+	}
+	return tb, nil
+}
+
+// Materials returns the materials of the tablebase's tables, in order.
+func (tb *RetrogradeTablebase) Materials() []string {
+	var materials []string
+	for material := range tb.tables {
+		materials = append(materials, material)
+	}
+	sort.Strings(materials)
+	return materials
+}
+
+// MaxPieces returns the most pieces of the tablebase's tables.
+func (tb *RetrogradeTablebase) MaxPieces() int {
+	max := 0
+	for _, t := range tb.tables {
+		if len(t.pieces) > max {
+			max = len(t.pieces)
+		}
+	}
+	return max
+}
+
+// probe returns the table value of the position with side to move. Positions without the material to mate are drawn
+// without needing a table.
+func (tb *RetrogradeTablebase) probe(p *Position, side Side) (uint8, bool) {
+	material, mirror := syzygyMaterial(p)
+	switch material {
+	case "KvK", "KBvK", "KNvK":
+		return retroDraw, true
+	}
+	t := tb.tables[material]
+	if t == nil || p.hasCastlingRights() {
+		return 0, false
+	}
+	var squares [maxRetroPieces]int
+	if !t.squares(p, mirror, squares[:len(t.pieces)]) {
+		return 0, false
+	}
+	if mirror {
+		side = side.OppSide()
+	}
+	value := t.values[t.canonical(squares[:len(t.pieces)], side)]
+	return value, value != retroIllegal
+}
+
+// retroDTM converts a table value to the number of plies to mate, positive when the side to move mates and negative
+// when it's mated. Draws are 0.
+func retroDTM(value uint8) int {
+	if value == retroDraw {
+		return 0
+	}
+	plies := int(value) - 1
+	if plies%2 == 0 {
+		return -plies
+	}
+	return plies
+}
+
+// ProbeWDL returns the outcome of the position with side to move.
+func (tb *RetrogradeTablebase) ProbeWDL(p *Position, side Side) (WDL, bool) {
+	value, ok := tb.probe(p, side)
+	if !ok {
+		return Draw, false
+	}
+	switch dtm := retroDTM(value); {
+	case value == retroDraw:
+		return Draw, true
+	case dtm > 0:
+		return Win, true
+	default:
+		return Loss, true
+	}
+}
+
+// ProbeDTM returns the number of plies to mate with best play, positive when the side to move mates and negative when
+// it's mated.
+func (tb *RetrogradeTablebase) ProbeDTM(p *Position, side Side) (int, bool) {
+	value, ok := tb.probe(p, side)
+	return retroDTM(value), ok
+}
+
+// ProbeDTZ always reports the distance to zeroing as unknown: the tables hold the distance to mate instead, which
+// ProbeDTM returns.
+func (tb *RetrogradeTablebase) ProbeDTZ(p *Position, side Side) (int, bool) {
+	return 0, false
+}
+
+// retroConversions returns the materials a table's positions may turn into by a capture, a promotion or both, named
+// with the stronger side first and leaving out those without the material to mate.
+func retroConversions(material string) []string {
+	var conversions []string
+	add := func(sides [2]string) {
+		if converted, _ := parseRetroMaterial(sides[0] + "v" + sides[1]); !containsString(conversions, converted) {
+			switch converted {
+			case "KvK", "KBvK", "KNvK":
+			default:
+				conversions = append(conversions, converted)
+			}
+		}
+	}
+	var sides [2]string
+	copy(sides[:], strings.Split(material, "v"))
+	for i := range sides {
+		opp := 1 - i
+		// Captures of the opponent's pieces.
+		for j := 1; j < len(sides[opp]); j++ {
+			captured := sides
+			captured[opp] = sides[opp][:j] + sides[opp][j+1:]
+			add(captured)
+		}
+		// Promotions, perhaps with a capture.
+		j := strings.IndexByte(sides[i], 'P')
+		if j < 0 {
+			continue
+		}
+		for _, promoted := range "QRBN" {
+			converted := sides
+			converted[i] = sides[i][:j] + string(promoted) + sides[i][j+1:]
+			add(converted)
+			for k := 1; k < len(sides[opp]); k++ {
+				captured := converted
+				captured[opp] = sides[opp][:k] + sides[opp][k+1:]
+				add(captured)
+			}
+		}
+	}
+	return conversions
+}
+
+// RetroStats summarizes a generated table.
+type RetroStats struct {
+	Material     string
+	Positions    int
+	Wins, Losses int
+	Draws        int
+	// LongestMate is the most plies to mate of the table's positions.
+	LongestMate int
+	Time        time.Duration
+
+	longestIndex int
+}
+
+// Generate generates the table of the material, after those of the materials it converts into, saving each table to
+// dir when it's not empty. Tables already in the tablebase aren't generated again. done, if not nil, is called as
+// each table is finished.
+func (tb *RetrogradeTablebase) Generate(material, dir string, done func(RetroStats)) error {
+	material, err := parseRetroMaterial(material)
+	if err != nil {
+		return err
+	}
+	if tb.tables[material] != nil {
+		return nil
+	}
+	for _, conversion := range retroConversions(material) {
+		if err := tb.Generate(conversion, dir, done); err != nil {
+			return err
+		}
+	}
+
+	t, err := newRetroTable(material)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := tb.generate(t); err != nil {
+		return err
+	}
+	tb.tables[material] = t
+	if dir != "" {
+		if err := t.save(filepath.Join(dir, material+retroExt)); err != nil {
+			return err
+		}
+	}
+	if done != nil {
+		stats := t.stats()
+		stats.Time = time.Since(start)
+		done(stats)
+	}
+	return nil
+}
+
+// generate fills in a table by retrograde analysis. Every legal position is first given a count of its moves, and the
+// positions which are mated or convert into another table are resolved. Then, a ply at a time, positions from which
+// the side to move can reach a position lost to the opponent are won, and positions all of whose moves reach positions
+// won by the opponent are lost. The positions left are draws.
+func (tb *RetrogradeTablebase) generate(t *retroTable) error {
+	// counts holds, for each position, the number of its moves not yet known to lose. Moves within the table are
+	// counted once for each position they reach, whatever its reflection.
+	counts := make([]uint8, len(t.values))
+	// wins holds the positions which win by a conversion, by the ply of the mate, and refutations the positions with a
+	// conversion which loses, by the ply at which the opponent mates.
+	wins, refutations := make(map[int][]int32), make(map[int][]int32)
+	var resolved []int32
+	lastPly := 0
+
+	p := emptyPosition()
+	squares := make([]int, len(t.pieces))
+	child := make([]int, len(t.pieces))
+	var children []int
+	for idx := range t.values {
+		side := t.decode(idx, squares)
+		if t.canonical(squares, side) != idx || !t.place(p, squares) {
+			t.values[idx] = retroIllegal
+			continue
+		}
+		if p.InCheck(side.OppSide()) {
+			t.values[idx] = retroIllegal
+			t.clear(p, squares)
+			continue
+		}
+
+		moves := p.GetMoves(side)
+		if len(moves) == 0 && p.InCheck(side) {
+			t.values[idx] = 1
+			resolved = append(resolved, int32(idx))
+		}
+		children = children[:0]
+		conversions := 0
+		for _, move := range moves {
+			undo := p.makeMove(move)
+			if undo.captured.piece == None && move.promoPiece == "" {
+				copy(child, squares)
+				for i, sq := range child {
+					if sq == move.oRank*8+move.oFile {
+						child[i] = move.nRank*8 + move.nFile
+						break
+					}
+				}
+				childIdx := t.canonical(child, side.OppSide())
+				if !containsInt(children, childIdx) {
+					children = append(children, childIdx)
+				}
+			} else {
+				conversions++
+				value, ok := tb.probe(p, side.OppSide())
+				if !ok {
+					p.unmakeMove(undo)
+					return fmt.Errorf("%s: no table for %s", t.Material, p.FEN(side.OppSide()))
+				}
+				switch dtm := retroDTM(value); {
+				case value == retroDraw:
+				case dtm <= 0:
+					wins[1-dtm] = append(wins[1-dtm], int32(idx))
+					lastPly = maxInt(lastPly, 1-dtm)
+				default:
+					refutations[dtm] = append(refutations[dtm], int32(idx))
+					lastPly = maxInt(lastPly, dtm)
+				}
+			}
+			p.unmakeMove(undo)
+		}
+		counts[idx] = uint8(len(children) + conversions)
+		t.clear(p, squares)
+	}
+
+	for ply := 0; len(resolved) > 0 || ply <= lastPly; ply++ {
+		if ply+2 >= retroIllegal {
+			return fmt.Errorf("%s: mates are too long to store", t.Material)
+		}
+		var next []int32
+		resolve := func(idx int32) {
+			if t.values[idx] == retroDraw {
+				t.values[idx] = uint8(ply + 2)
+				next = append(next, idx)
+			}
+		}
+		refute := func(idx int32) {
+			if t.values[idx] == retroDraw {
+				counts[idx]--
+				if counts[idx] == 0 {
+					resolve(idx)
+				}
+			}
+		}
+
+		if ply%2 == 0 {
+			// The positions resolved are lost, so the positions leading to them are won.
+			for _, idx := range resolved {
+				for _, pred := range t.predecessors(p, int(idx)) {
+					resolve(int32(pred))
+				}
+			}
+			for _, idx := range wins[ply+1] {
+				resolve(idx)
+			}
+		} else {
+			// The positions resolved are won, refuting the moves leading to them.
+			for _, idx := range resolved {
+				for _, pred := range t.predecessors(p, int(idx)) {
+					refute(int32(pred))
+				}
+			}
+			for _, idx := range refutations[ply] {
+				refute(idx)
+			}
+		}
+		resolved = next
+	}
+	return nil
+}
+
+// predecessors returns the indices of the positions from which a move within the table leads to the position with
+// the index.
+func (t *retroTable) predecessors(p *Position, idx int) []int {
+	squares := make([]int, len(t.pieces))
+	side := t.decode(idx, squares)
+	mover := side.OppSide()
+	t.place(p, squares)
+
+	var preds []int
+	pred := make([]int, len(t.pieces))
+	unmove := func(i, from int) {
+		sq := squares[i]
+		p.board[from/8][from%8], p.board[sq/8][sq%8] = t.pieces[i], GamePiece{None, White}
+		if !p.InCheck(side) {
+			copy(pred, squares)
+			pred[i] = from
+			if predIdx := t.canonical(pred, mover); !containsInt(preds, predIdx) {
+				preds = append(preds, predIdx)
+			}
+		}
+		p.board[from/8][from%8], p.board[sq/8][sq%8] = GamePiece{None, White}, t.pieces[i]
+	}
+
+	for i, sq := range squares {
+		piece := t.pieces[i]
+		if piece.color != mover {
+			continue
+		}
+		f, r := sq%8, sq/8
+		if piece.piece != Pawn {
+			// Pieces move back the way they move forward.
+			for _, move := range p.GetMovesAt(f, r, mover) {
+				if p.board[move.nRank][move.nFile].piece == None {
+					unmove(i, move.nRank*8+move.nFile)
+				}
+			}
+			continue
+		}
+		// Pawns only move forward, so they come from the square behind, or two behind from where they start.
+		back, startRank := -1, 1
+		if mover == Black {
+			back, startRank = 1, 6
+		}
+		from := r + back
+		if from == 0 || from == 7 || p.board[from][f].piece != None {
+			continue
+		}
+		unmove(i, from*8+f)
+		if from+back == startRank && p.board[from+back][f].piece == None {
+			unmove(i, (from+back)*8+f)
+		}
+	}
+	t.clear(p, squares)
+	return preds
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// stats summarizes the table.
+func (t *retroTable) stats() RetroStats {
+	stats := RetroStats{Material: t.Material}
+	for idx, value := range t.values {
+		switch dtm := retroDTM(value); {
+		case value == retroIllegal:
+			continue
+		case value == retroDraw:
+			stats.Draws++
+		case dtm > 0:
+			stats.Wins++
+			if dtm > stats.LongestMate {
+				stats.LongestMate, stats.longestIndex = dtm, idx
+			}
+		default:
+			stats.Losses++
+		}
+		stats.Positions++
+	}
+	return stats
+}
+
+// position sets up the position with the index, returning it with the side to move.
+func (t *retroTable) position(idx int) (*Position, Side) {
+	p := emptyPosition()
+	squares := make([]int, len(t.pieces))
+	side := t.decode(idx, squares)
+	t.place(p, squares)
+	return p, side
+}
+
+// save writes the table to a file.
+func (t *retroTable) save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(retroMagic)
+	w.WriteByte(retroVersion)
+	w.WriteByte(byte(len(t.Material)))
+	w.WriteString(t.Material)
+	zw, _ := flate.NewWriter(w, flate.BestCompression)
+	zw.Write(t.values)
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadRetroTable reads a table written by save.
+func loadRetroTable(path string) (*retroTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, len(retroMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(retroMagic)]) != retroMagic {
+		return nil, fmt.Errorf("%s: not a generated table", path)
+	}
+	if header[len(retroMagic)] != retroVersion {
+		return nil, fmt.Errorf("%s: unknown version %d", path, header[len(retroMagic)])
+	}
+	material := make([]byte, header[len(retroMagic)+1])
+	if _, err := io.ReadFull(r, material); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	t, err := newRetroTable(string(material))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := io.ReadFull(flate.NewReader(r), t.values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// TBGenCommand runs the tbgen command: it generates endgame tables by retrograde analysis.
+func TBGenCommand(args []string) error {
+	fs := flag.NewFlagSet("tbgen", flag.ExitOnError)
+	dir := fs.String("dir", "tables", "`dir` to write the tables to")
+	fs.Parse(args)
+	materials := fs.Args()
+	if len(materials) == 0 {
+		materials = DefaultRetroTables
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	// Tables generated before are loaded rather than generated again.
+	tb, err := loadRetroTables(*dir)
+	if err != nil {
+		return err
+	}
+	for _, material := range materials {
+		err := tb.Generate(material, *dir, func(stats RetroStats) {
+			t := tb.tables[stats.Material]
+			longest := ""
+			if stats.LongestMate > 0 {
+				p, side := t.position(stats.longestIndex)
+				longest = fmt.Sprintf(", longest mate %d plies in %s", stats.LongestMate, p.FEN(side))
+			}
+			fmt.Printf("%s: %d positions, %d won, %d lost, %d drawn%s (%s)\n", stats.Material, stats.Positions,
+				stats.Wins, stats.Losses, stats.Draws, longest, stats.Time.Round(time.Millisecond))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retroValue works out the value of a position from the values of the positions its moves lead to.
+func (tb *RetrogradeTablebase) retroValue(p *Position, side Side) (uint8, error) {
+	moves := p.GetMoves(side)
+	if len(moves) == 0 {
+		if p.InCheck(side) {
+			return 1, nil
+		}
+		return retroDraw, nil
+	}
+	win, loss, drawn := 0, 0, false
+	for _, move := range moves {
+		undo := p.makeMove(move)
+		value, ok := tb.probe(p, side.OppSide())
+		p.unmakeMove(undo)
+		if !ok {
+			return 0, fmt.Errorf("no table after %s", move)
+		}
+		switch dtm := retroDTM(value); {
+		case value == retroDraw:
+			drawn = true
+		case dtm <= 0:
+			if win == 0 || 1-dtm < win {
+				win = 1 - dtm
+			}
+		default:
+			loss = maxInt(loss, dtm+1)
+		}
+	}
+	switch {
+	case win > 0:
+		return uint8(win + 1), nil
+	case drawn:
+		return retroDraw, nil
+	default:
+		return uint8(loss + 1), nil
+	}
+}
+
+// mateWithin reports whether side to move can mate within plies.
+func mateWithin(p *Position, side Side, plies int) bool {
+	if plies <= 0 {
+		return false
+	}
+	for _, move := range p.GetMoves(side) {
+		undo := p.makeMove(move)
+		mated := matedWithin(p, side.OppSide(), plies-1)
+		p.unmakeMove(undo)
+		if mated {
+			return true
+		}
+	}
+	return false
+}
+
+// matedWithin reports whether side to move is mated within plies, whatever it plays.
+func matedWithin(p *Position, side Side, plies int) bool {
+	moves := p.GetMoves(side)
+	if len(moves) == 0 {
+		return p.InCheck(side)
+	}
+	if plies < 2 {
+		return false
+	}
+	for _, move := range moves {
+		undo := p.makeMove(move)
+		mate := mateWithin(p, side.OppSide(), plies-1)
+		p.unmakeMove(undo)
+		if !mate {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyBySearch checks a position's table value with a search of up to plies plies which doesn't use the tables.
+// Mates further away are only checked not to be found sooner.
+func verifyBySearch(p *Position, side Side, value uint8, plies int) bool {
+	switch dtm := retroDTM(value); {
+	case value == retroDraw:
+		return !mateWithin(p, side, plies) && !matedWithin(p, side, plies)
+	case dtm > 0 && dtm <= plies:
+		return mateWithin(p, side, dtm) && !mateWithin(p, side, dtm-2)
+	case dtm > 0:
+		return !mateWithin(p, side, plies)
+	case -dtm <= plies:
+		return matedWithin(p, side, -dtm) && (dtm == 0 || !matedWithin(p, side, -dtm-2))
+	default:
+		return !matedWithin(p, side, plies)
+	}
+}
+
+// errRetroMismatch is returned by tbverify when a table disagrees with the checks.
+var errRetroMismatch = errors.New("tbverify: tables failed verification")
+
+// TBVerifyCommand runs the tbverify command: it checks generated tables against their positions' moves and against a
+// forward search which doesn't use them.
+func TBVerifyCommand(args []string) error {
+	fs := flag.NewFlagSet("tbverify", flag.ExitOnError)
+	dir := fs.String("dir", "tables", "`dir` holding the tables")
+	samples := fs.Int("samples", 100, "number of random positions to check in each table")
+	plies := fs.Int("plies", 3, "plies to search forward from each position")
+	seed := fs.Int64("seed", 1, "seed choosing the positions")
+	fs.Parse(args)
+
+	tb, err := OpenRetrogradeTables(*dir)
+	if err != nil {
+		return err
+	}
+	materials := fs.Args()
+	if len(materials) == 0 {
+		materials = tb.Materials()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	failed := false
+	for _, material := range materials {
+		material, err := parseRetroMaterial(material)
+		if err != nil {
+			return err
+		}
+		t := tb.tables[material]
+		if t == nil {
+			return fmt.Errorf("tbverify: no table for %s in %s", material, *dir)
+		}
+		mismatches := 0
+		for checked := 0; checked < *samples; {
+			idx := rng.Intn(len(t.values))
+			if t.values[idx] == retroIllegal {
+				continue
+			}
+			checked++
+			p, side := t.position(idx)
+			value, err := tb.retroValue(p, side)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", material, p.FEN(side), err)
+			}
+			dtm := retroDTM(t.values[idx])
+			switch {
+			case value != t.values[idx]:
+				fmt.Printf("%s: %s has distance to mate %d, but its moves give %d\n", material, p.FEN(side), dtm,
+					retroDTM(value))
+				mismatches++
+			case !verifyBySearch(p, side, t.values[idx], *plies):
+				fmt.Printf("%s: %s has distance to mate %d, which a %d-ply search disagrees with\n", material,
+					p.FEN(side), dtm, *plies)
+				mismatches++
+			}
+		}
+		fmt.Printf("%s: checked %d positions, %d mismatches\n", material, *samples, mismatches)
+		failed = failed || mismatches > 0
+	}
+	if failed {
+		return errRetroMismatch
+	}
+	return nil
+}
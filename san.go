@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sanPieces holds the SAN letter for each Piece, indexed by the Piece's value. Pawns have no letter.
+var sanPieces = [...]string{"", "R", "N", "B", "Q", "K"}
+
+// SAN returns the move in Standard Algebraic Notation, e.g. "Nbd7", "exd5", "O-O" or "e8=Q+". The move must be legal
+// in the position.
+func (p *Position) SAN(move Move) string {
+	side := p.board[move.oRank][move.oFile].color
+	san := p.sanWithoutCheck(move)
+	undo := p.makeMove(move)
+	if p.InCheck(side.OppSide()) {
+		if len(p.GetMoves(side.OppSide())) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	p.unmakeMove(undo)
+	return san
+}
+
+// sanWithoutCheck returns the move's SAN without the check or mate suffix.
+func (p *Position) sanWithoutCheck(move Move) string {
+	piece := p.board[move.oRank][move.oFile]
+	to := Square{move.nFile, move.nRank}
+
+	var san string
+	switch {
+	case piece.piece == King && move.nFile-move.oFile == 2:
+		san = "O-O"
+	case piece.piece == King && move.oFile-move.nFile == 2:
+		san = "O-O-O"
+	case piece.piece == Pawn:
+		if move.oFile != move.nFile {
+			san = Square{move.oFile, move.oRank}.String()[:1] + "x"
+		}
+		san += to.String()
+		if move.nRank == 7 || move.nRank == 0 {
+			san += "=" + sanPieces[promotionPiece(move.promoPiece)]
+		}
+	default:
+		san = sanPieces[piece.piece] + p.disambiguation(move, piece.color)
+		if p.board[move.nRank][move.nFile].piece != None {
+			san += "x"
+		}
+		san += to.String()
+	}
+	return san
+}
+
+// disambiguation returns what must be added to a piece move's SAN to tell it apart from moves of other pieces of the
+// same type to the same square: the origin file if that's enough, otherwise the origin rank, otherwise both.
+func (p *Position) disambiguation(move Move, side Side) string {
+	piece := p.board[move.oRank][move.oFile].piece
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range p.GetMoves(side) {
+		if other.nFile != move.nFile || other.nRank != move.nRank ||
+			other.oFile == move.oFile && other.oRank == move.oRank ||
+			p.board[other.oRank][other.oFile].piece != piece {
+			continue
+		}
+		ambiguous = true
+		sameFile = sameFile || other.oFile == move.oFile
+		sameRank = sameRank || other.oRank == move.oRank
+	}
+
+	from := Square{move.oFile, move.oRank}.String()
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return from[:1]
+	case !sameRank:
+		return from[1:]
+	default:
+		return from
+	}
+}
+
+// SANMoves returns a sequence of moves played from the position in SAN. The position is left unchanged.
+func (p *Position) SANMoves(moves []Move) []string {
+	sans := make([]string, 0, len(moves))
+	undos := make([]moveUndo, 0, len(moves))
+	for _, move := range moves {
+		sans = append(sans, p.SAN(move))
+		undos = append(undos, p.makeMove(move))
+	}
+	for i := len(undos) - 1; i >= 0; i-- {
+		p.unmakeMove(undos[i])
+	}
+	return sans
+}
+
+// ParseSAN returns side's legal move written in SAN, e.g. "Nbxd7+". Input is read tolerantly: check and mate
+// suffixes and annotations such as "!?" may be missing or wrong, castling may be written with zeros, piece letters and
+// promotions may be lowercase where that isn't ambiguous, the "=" of a promotion may be left out, and a promotion to a
+// queen may be left out altogether. Moves in long algebraic notation, e.g. "e2e4" or "Ng1-f3", are accepted too.
+func (p *Position) ParseSAN(san string, side Side) (Move, error) {
+	text := strings.TrimSuffix(strings.TrimSpace(san), "e.p.")
+	text = strings.TrimRight(text, "+#!? ")
+
+	var matches []Move
+	switch strings.ToUpper(strings.ReplaceAll(text, "0", "O")) {
+	case "O-O", "O-O-O":
+		for _, move := range p.GetMoves(side) {
+			distance := move.nFile - move.oFile
+			if p.board[move.oRank][move.oFile].piece == King && (distance == 2 && len(text) == 3 ||
+				distance == -2 && len(text) == 5) {
+				matches = append(matches, move)
+			}
+		}
+	default:
+		// A lowercase b may be the b-file or a bishop. When both readings give a legal move, the move is ambiguous.
+		if text != "" && strings.ContainsRune("KQRBN", rune(text[0])) {
+			matches = p.matchSAN(side, pieceFromSAN(text[0]), text[1:], matches)
+		} else {
+			matches = p.matchSAN(side, Pawn, text, matches)
+			if text != "" && strings.ContainsRune("kqrbn", rune(text[0])) {
+				matches = p.matchSAN(side, pieceFromSAN(text[0]), text[1:], matches)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Move{}, fmt.Errorf("%q is not a legal move", san)
+	case 1:
+		return matches[0], nil
+	default:
+		return Move{}, fmt.Errorf("%q is ambiguous", san)
+	}
+}
+
+// pieceFromSAN returns the piece for a SAN piece letter, in either case.
+func pieceFromSAN(letter byte) Piece {
+	for piece, s := range sanPieces {
+		if s != "" && strings.EqualFold(s, string(letter)) {
+			return Piece(piece)
+		}
+	}
+	return None
+}
+
+// matchSAN appends to matches those of side's legal moves of the piece which agree with the rest of a SAN move: an
+// optional origin file and rank, the destination square and an optional promotion. A pawn move giving the whole origin
+// square may be long algebraic notation, so it matches a move of any piece.
+func (p *Position) matchSAN(side Side, piece Piece, rest string, matches []Move) []Move {
+	rest = strings.NewReplacer("x", "", "X", "", ":", "", "-", "", "=", "", "(", "", ")", "").Replace(rest)
+	promo := ""
+	if n := len(rest); n >= 3 && strings.ContainsRune("QRBNqrbn", rune(rest[n-1])) && rest[n-2] >= '1' &&
+		rest[n-2] <= '8' {
+		promo = strings.ToLower(rest[n-1:])
+		rest = rest[:n-1]
+	}
+	if len(rest) < 2 || len(rest) > 4 {
+		return matches
+	}
+	to, ok := parseSquare(rest[len(rest)-2:])
+	if !ok {
+		return matches
+	}
+	fromFile, fromRank := -1, -1
+	for _, c := range rest[:len(rest)-2] {
+		switch {
+		case c >= 'a' && c <= 'h' && fromFile < 0 && fromRank < 0:
+			fromFile = int(c - 'a')
+		case c >= '1' && c <= '8' && fromRank < 0:
+			fromRank = int(c - '1')
+		default:
+			return matches
+		}
+	}
+
+	anyPiece := piece == Pawn && fromFile >= 0 && fromRank >= 0
+	for _, move := range p.GetMoves(side) {
+		if p.board[move.oRank][move.oFile].piece != piece && !anyPiece || move.nFile != to.file || move.nRank != to.rank ||
+			fromFile >= 0 && move.oFile != fromFile || fromRank >= 0 && move.oRank != fromRank {
+			continue
+		}
+		if move.promoPiece != promo && (promo != "" || move.promoPiece != "q") {
+			continue
+		}
+		if !containsMove(matches, move) {
+			matches = append(matches, move)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseSANLowercaseBishop(t *testing.T) {
+	tests := []struct {
+		fen, san, want string // want is "" when the move should be rejected as ambiguous
+	}{
+		// Only the bishop can reach c4.
+		{"4k3/8/8/8/8/8/8/4KB2 w - - 0 1", "bc4", "f1c4"},
+		// Only the b-pawn can capture on c4.
+		{"4k3/8/8/8/2p5/1P6/8/4K3 w - - 0 1", "bc4", "b3c4"},
+		// Both the bishop and the b-pawn can take on c4.
+		{"4k3/8/8/8/2p5/1P6/8/4KB2 w - - 0 1", "bc4", ""},
+		{"4k3/8/8/8/2p5/1P6/8/4KB2 w - - 0 1", "bxc4", ""},
+		{"4k3/8/8/8/2p5/1P6/8/4KB2 w - - 0 1", "Bxc4", "f1c4"},
+	}
+	for _, test := range tests {
+		p, side, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		move, err := p.ParseSAN(test.san, side)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("%s in %s: got %v, want an ambiguity error", test.san, test.fen, move)
+		case test.want != "" && err != nil:
+			t.Errorf("%s in %s: %v", test.san, test.fen, err)
+		case test.want != "" && move.String() != test.want:
+			t.Errorf("%s in %s: got %v, want %s", test.san, test.fen, move, test.want)
+		}
+	}
+}
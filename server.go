@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	httppprof "net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// maxRequestBytes bounds the size of a request body accepted by the server.
+const maxRequestBytes = 1 << 16
+
+// Server serves the engine over HTTP. Requests are JSON objects and responses are JSON objects. Errors are reported
+// with a non-2xx status and a body of the form {"error": "..."}.
+type Server struct {
+	// Timeout bounds the time spent on any one request. Searches which run out of time report their best result so far.
+	Timeout time.Duration
+	// Book, if not nil, answers analyses of the positions in it without searching, unless a request bypasses it.
+	Book *OpeningBook
+
+	// searches limits the number of searches running at once.
+	searches chan struct{}
+	streams  streams
+}
+
+// NewServer creates a Server which runs up to one search per CPU at a time.
+func NewServer(timeout time.Duration) *Server {
+	return &Server{Timeout: timeout, searches: make(chan struct{}, runtime.GOMAXPROCS(0))}
+}
+
+// Handler returns the server's routes, along with the pprof handlers under /debug/pprof/.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/analyze", s.handleAnalyze)
+	mux.HandleFunc("/analyze/stream", s.handleAnalyzeStream)
+	mux.HandleFunc("/analyze/stop", s.handleAnalyzeStop)
+	mux.HandleFunc("/legal-moves", s.handleLegalMoves)
+	mux.HandleFunc("/move", s.handleMove)
+	mux.HandleFunc("/eval", s.handleEval)
+
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	return mux
+}
+
+// Serve listens on addr and serves the engine, playing from book when it isn't nil, until the server fails.
+func Serve(addr string, timeout time.Duration, book *OpeningBook) error {
+	s := NewServer(timeout)
+	s.Book = book
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("RobChess serving on %s", addr)
+	return server.ListenAndServe()
+}
+
+type analyzeRequest struct {
+	FEN      string `json:"fen"`
+	Depth    int    `json:"depth"`
+	Nodes    int    `json:"nodes"`
+	MoveTime int    `json:"movetime"` // Milliseconds
+	// Book, when false, searches positions which are in the server's book. It defaults to true.
+	Book *bool `json:"book"`
+}
+
+type analyzeResponse struct {
+	BestMove string   `json:"bestmove"`       // Empty when the side to move has no moves.
+	Score    int      `json:"score"`          // Centipawns, from the perspective of the side to move.
+	Mate     int      `json:"mate,omitempty"` // Moves until mate, negative when the side to move is being mated.
+	Depth    int      `json:"depth"`
+	Nodes    int      `json:"nodes"`
+	Time     int64    `json:"time"` // Milliseconds
+	PV       []string `json:"pv"`
+	Book     bool     `json:"book,omitempty"` // Set when the move comes from the book without searching.
+}
+
+type legalMovesRequest struct {
+	FEN string `json:"fen"`
+}
+
+type legalMovesResponse struct {
+	Moves []string `json:"moves"`
+}
+
+type moveRequest struct {
+	FEN  string `json:"fen"`
+	Move string `json:"move"`
+}
+
+type moveResponse struct {
+	FEN string `json:"fen"`
+}
+
+type evalRequest struct {
+	FEN string `json:"fen"`
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	var req analyzeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	p, side, err := ParseFEN(req.FEN)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Depth < 0 || req.Nodes < 0 || req.MoveTime < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limits must not be negative"))
+		return
+	}
+
+	if req.Book == nil || *req.Book {
+		if move, ok := s.Book.probe(p, side); ok {
+			resp := newAnalyzeResponse(SearchResult{BestMove: move, PV: []Move{move}})
+			resp.Book = true
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if !s.acquire(ctx) {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("timed out waiting for a free search"))
+		return
+	}
+	defer s.release()
+
+	limits := SearchLimits{req.Depth, req.Nodes, time.Duration(req.MoveTime) * time.Millisecond}
+	result := Search(ctx, *NewGameFromPosition(p), side, limits, nil)
+	writeJSON(w, http.StatusOK, newAnalyzeResponse(result))
+}
+
+func newAnalyzeResponse(result SearchResult) analyzeResponse {
+	resp := analyzeResponse{
+		Score: int(math.Round(result.Score * 100)),
+		Depth: result.Depth,
+		Nodes: result.Nodes,
+		Time:  result.Time.Milliseconds(),
+		PV:    make([]string, 0, len(result.PV)),
+	}
+	if len(result.PV) > 0 {
+		resp.BestMove = result.BestMove.String()
+	}
+	if mate, ok := result.MateIn(); ok {
+		resp.Mate = mate
+	}
+	for _, move := range result.PV {
+		resp.PV = append(resp.PV, move.String())
+	}
+	return resp
+}
+
+func (s *Server) handleLegalMoves(w http.ResponseWriter, r *http.Request) {
+	var req legalMovesRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	p, side, err := ParseFEN(req.FEN)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := legalMovesResponse{make([]string, 0)}
+	for _, move := range p.GetMoves(side) {
+		resp.Moves = append(resp.Moves, move.String())
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	var req moveRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	p, side, err := ParseFEN(req.FEN)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	move, ok := findLegalMove(p, side, req.Move)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("illegal move %q", req.Move))
+		return
+	}
+	p.MakeMove(move)
+	writeJSON(w, http.StatusOK, moveResponse{p.FEN(side.OppSide())})
+}
+
+func (s *Server) handleEval(w http.ResponseWriter, r *http.Request) {
+	var req evalRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	p, side, err := ParseFEN(req.FEN)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, EvaluateTrace(p, side))
+}
+
+// findLegalMove parses a move in long algebraic notation and checks that side may play it.
+func findLegalMove(p *Position, side Side, moveStr string) (Move, bool) {
+	move, ok := algebraicToMove(moveStr)
+	if !ok {
+		return Move{}, false
+	}
+	for _, legal := range p.GetMoves(side) {
+		if legal == move {
+			return move, true
+		}
+	}
+	return Move{}, false
+}
+
+func (s *Server) acquire(ctx context.Context) bool {
+	select {
+	case s.searches <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) release() {
+	<-s.searches
+}
+
+// readJSON decodes a POSTed request body into v. On failure it writes an error response and returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postJSON posts body to path on a test server and decodes the JSON response into resp, returning the status.
+func postJSON(t *testing.T, s *Server, path, body string, resp interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatalf("%s %s: decoding the response: %v", path, body, err)
+	}
+	return rec.Code
+}
+
+func TestServerBadFEN(t *testing.T) {
+	s := NewServer(time.Second)
+	fens := []string{
+		"not a fen",
+		"P3k3/8/8/8/8/8/8/4K3 w - - 0 1",
+		"4k3/8/8/8/8/8/8/4K2p b - - 0 1",
+		"4k3/8/8/8/8/8/8/r3K3 b - - 0 1",
+	}
+	for _, fen := range fens {
+		for _, path := range []string{"/analyze", "/legal-moves", "/move", "/eval"} {
+			body, _ := json.Marshal(map[string]string{"fen": fen})
+			var resp map[string]string
+			if code := postJSON(t, s, path, string(body), &resp); code != http.StatusBadRequest || resp["error"] == "" {
+				t.Errorf("%s %s: status %d, %v, want %d and an error", path, fen, code, resp, http.StatusBadRequest)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze/stream?fen="+url.QueryEscape(fen), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("/analyze/stream %s: status %d, want %d", fen, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestServerAnalyze(t *testing.T) {
+	s := NewServer(time.Minute)
+	var resp analyzeResponse
+	body := `{"fen": "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "depth": 3}`
+	if code := postJSON(t, s, "/analyze", body, &resp); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if resp.BestMove != "a1a8" || resp.Mate != 1 {
+		t.Errorf("got %+v, want mate in 1 by a1a8", resp)
+	}
+
+	if code := postJSON(t, s, "/analyze", `{"fen": "`+StartFEN+`", "depth": -1}`, &map[string]string{}); code != http.StatusBadRequest {
+		t.Errorf("negative depth: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// A search which runs out of the server's time reports its best result so far.
+func TestServerAnalyzeTimeout(t *testing.T) {
+	s := NewServer(200 * time.Millisecond)
+	start := time.Now()
+	var resp analyzeResponse
+	if code := postJSON(t, s, "/analyze", `{"fen": "`+StartFEN+`", "depth": 60}`, &resp); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v with a timeout of %v", elapsed, s.Timeout)
+	}
+	if resp.BestMove == "" || resp.Depth >= 60 {
+		t.Errorf("got %+v, want a move from a shallower search", resp)
+	}
+}
+
+// A request which can't get a search before the timeout is refused.
+func TestServerBusy(t *testing.T) {
+	s := NewServer(50 * time.Millisecond)
+	for i := 0; i < cap(s.searches); i++ {
+		s.searches <- struct{}{}
+	}
+	var resp map[string]string
+	if code := postJSON(t, s, "/analyze", `{"fen": "`+StartFEN+`", "depth": 1}`, &resp); code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, %v, want %d", code, resp, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerMove(t *testing.T) {
+	s := NewServer(time.Second)
+	tests := []struct {
+		fen, move, want string
+	}{
+		{StartFEN, "e2e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 3 10", "e8c8", "2kr3r/8/8/8/8/8/8/R3K2R w KQ - 4 11"},
+		{"4k3/P7/8/8/8/8/8/4K3 w - - 5 1", "a7a8q", "Q3k3/8/8/8/8/8/8/4K3 b - - 0 1"},
+	}
+	for _, test := range tests {
+		var resp moveResponse
+		body, _ := json.Marshal(moveRequest{test.fen, test.move})
+		if code := postJSON(t, s, "/move", string(body), &resp); code != http.StatusOK || resp.FEN != test.want {
+			t.Errorf("%s in %s: status %d, %s, want %s", test.move, test.fen, code, resp.FEN, test.want)
+		}
+	}
+
+	for _, move := range []string{"e2e5", "e7e5", "nonsense"} {
+		var resp map[string]string
+		body, _ := json.Marshal(moveRequest{StartFEN, move})
+		if code := postJSON(t, s, "/move", string(body), &resp); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", move, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestServerLegalMoves(t *testing.T) {
+	s := NewServer(time.Second)
+	var resp legalMovesResponse
+	if code := postJSON(t, s, "/legal-moves", `{"fen": "`+StartFEN+`"}`, &resp); code != http.StatusOK || len(resp.Moves) != 20 {
+		t.Errorf("status %d, %d moves, want 20", code, len(resp.Moves))
+	}
+	// Mated, so no moves, but still a list.
+	if code := postJSON(t, s, "/legal-moves", `{"fen": "R5k1/5ppp/8/8/8/8/8/6K1 b - - 0 1"}`, &resp); code != http.StatusOK || resp.Moves == nil || len(resp.Moves) != 0 {
+		t.Errorf("mated: status %d, moves %v, want none", code, resp.Moves)
+	}
+}
+
+func TestServerRequests(t *testing.T) {
+	s := NewServer(time.Second)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /analyze: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	var resp map[string]string
+	if code := postJSON(t, s, "/analyze", `{"fen": "`+StartFEN+`", "ply": 3}`, &resp); code != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	server := httptest.NewServer(NewServer(time.Minute).Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/analyze/stream?depth=3&fen=" + url.QueryEscape("6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	var events []string
+	var last analyzeResponse
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if event := strings.TrimPrefix(line, "event: "); event != line {
+			events = append(events, event)
+		}
+		if data := strings.TrimPrefix(line, "data: "); data != line && events[len(events)-1] == "bestmove" {
+			if err := json.Unmarshal([]byte(data), &last); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(events) < 3 || events[0] != "started" || events[1] != "info" || events[len(events)-1] != "bestmove" {
+		t.Errorf("events %v, want started, info... and bestmove", events)
+	}
+	if last.BestMove != "a1a8" {
+		t.Errorf("best move %s, want a1a8", last.BestMove)
+	}
+}
+
+func TestServerStopUnknown(t *testing.T) {
+	var resp map[string]string
+	if code := postJSON(t, NewServer(time.Second), "/analyze/stop", `{"id": "nope"}`, &resp); code != http.StatusNotFound {
+		t.Errorf("status %d, want %d", code, http.StatusNotFound)
+	}
+}
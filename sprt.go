@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SPRT is a sequential probability ratio test of whether a player is Elo0 (the null hypothesis) or Elo1 (the
+// alternative) Elo stronger than its opponent, with false positive rate Alpha and false negative rate Beta.
+type SPRT struct {
+	Elo0, Elo1  float64
+	Alpha, Beta float64
+}
+
+// Bounds returns the log-likelihood ratios at which the test accepts the null hypothesis and the alternative.
+func (t SPRT) Bounds() (lower, upper float64) {
+	return math.Log(t.Beta / (1 - t.Alpha)), math.Log((1 - t.Beta) / t.Alpha)
+}
+
+// LLR returns the log-likelihood ratio of the alternative hypothesis over the null for the results so far. It uses the
+// normal approximation to the generalized SPRT, over game pairs when there are any and single games otherwise. Until
+// the results vary, they say nothing about the spread of outcomes, and the ratio is zero.
+func (t SPRT) LLR(s *MatchStats) float64 {
+	n, mean, variance := s.scoreDistribution()
+	if n == 0 || variance == 0 {
+		return 0
+	}
+	s0, s1 := expectedScore(t.Elo0), expectedScore(t.Elo1)
+	return n * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+}
+
+// Decision returns "H1" once the alternative is accepted, "H0" once the null is accepted and "" while the test
+// continues.
+func (t SPRT) Decision(s *MatchStats) string {
+	llr := t.LLR(s)
+	lower, upper := t.Bounds()
+	switch {
+	case llr >= upper:
+		return "H1"
+	case llr <= lower:
+		return "H0"
+	default:
+		return ""
+	}
+}
+
+// MatchStats accumulates the results of a match from the first player's perspective. Games are paired by round, the
+// first and second, third and fourth and so on, since those play the same opening with colors reversed.
+type MatchStats struct {
+	// Trinomial counts losses, draws and wins.
+	Trinomial [3]int
+	// Pentanomial counts game pairs by the points scored over the pair: 0, 1/2, 1, 3/2 and 2.
+	Pentanomial [5]int
+	// unpaired holds the points scored in rounds whose partner hasn't finished.
+	unpaired map[int]float64
+}
+
+// Add records the points scored in a round, numbered from 1.
+func (s *MatchStats) Add(round int, score float64) {
+	s.Trinomial[int(score*2)]++
+	if s.unpaired == nil {
+		s.unpaired = make(map[int]float64)
+	}
+	partner := round + 1
+	if round%2 == 0 {
+		partner = round - 1
+	}
+	if other, ok := s.unpaired[partner]; ok {
+		delete(s.unpaired, partner)
+		s.Pentanomial[int((score+other)*2)]++
+	} else {
+		s.unpaired[round] = score
+	}
+}
+
+// Games returns the number of games recorded.
+func (s *MatchStats) Games() int {
+	return s.Trinomial[0] + s.Trinomial[1] + s.Trinomial[2]
+}
+
+// Score returns the mean points per game.
+func (s *MatchStats) Score() float64 {
+	if s.Games() == 0 {
+		return .5
+	}
+	return (float64(s.Trinomial[1])/2 + float64(s.Trinomial[2])) / float64(s.Games())
+}
+
+// scoreDistribution returns the number of observations and the mean and variance of the score per game in each. The
+// observations are game pairs when there are any, since pairing cancels out the bias of the openings.
+func (s *MatchStats) scoreDistribution() (n, mean, variance float64) {
+	counts := s.Trinomial[:]
+	if pairs := s.Pentanomial[0] + s.Pentanomial[1] + s.Pentanomial[2] + s.Pentanomial[3] + s.Pentanomial[4]; pairs > 0 {
+		counts = s.Pentanomial[:]
+	}
+	step := 1 / float64(len(counts)-1)
+	for i, count := range counts {
+		n += float64(count)
+		mean += float64(count) * float64(i) * step
+	}
+	if n == 0 {
+		return 0, .5, 0
+	}
+	mean /= n
+	for i, count := range counts {
+		d := float64(i)*step - mean
+		variance += float64(count) * d * d
+	}
+	return n, mean, variance / n
+}
+
+// Elo returns the estimated Elo difference along with the margin of its 95% confidence interval.
+func (s *MatchStats) Elo() (elo, margin float64) {
+	n, mean, variance := s.scoreDistribution()
+	elo = scoreElo(mean)
+	if n == 0 {
+		return elo, math.Inf(1)
+	}
+	stderr := math.Sqrt(variance / n)
+	return elo, (scoreElo(mean+1.96*stderr) - scoreElo(mean-1.96*stderr)) / 2
+}
+
+// LOS returns the likelihood of superiority: the probability that the first player is the stronger.
+func (s *MatchStats) LOS() float64 {
+	wins, losses := float64(s.Trinomial[2]), float64(s.Trinomial[0])
+	if wins+losses == 0 {
+		return .5
+	}
+	return .5 * (1 + math.Erf((wins-losses)/math.Sqrt(2*(wins+losses))))
+}
+
+// Summary describes the results, along with the state of the test when t is not nil.
+func (s *MatchStats) Summary(t *SPRT) string {
+	elo, margin := s.Elo()
+	summary := fmt.Sprintf("Games %d: +%d =%d -%d, score %.1f%%, Elo %.1f +/- %.1f, LOS %.1f%%, pairs %v",
+		s.Games(), s.Trinomial[2], s.Trinomial[1], s.Trinomial[0], s.Score()*100, elo, margin, s.LOS()*100,
+		s.Pentanomial)
+	if t != nil {
+		lower, upper := t.Bounds()
+		summary += fmt.Sprintf("\nSPRT elo0=%g elo1=%g alpha=%g beta=%g: LLR %.2f (%.2f, %.2f)",
+			t.Elo0, t.Elo1, t.Alpha, t.Beta, t.LLR(s), lower, upper)
+		switch t.Decision(s) {
+		case "H1":
+			summary += " H1 accepted"
+		case "H0":
+			summary += " H0 accepted"
+		}
+	}
+	return summary
+}
+
+// expectedScore returns the mean points per game expected of a player elo stronger than its opponent.
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// scoreElo returns the Elo difference expected to produce a mean score.
+func scoreElo(score float64) float64 {
+	if score <= 0 {
+		return math.Inf(-1)
+	}
+	if score >= 1 {
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1/score-1)
+}
+
+// sprtFlags registers the flags configuring an SPRT, and returns a function giving the test, or nil when none was
+// asked for, once the flags are parsed.
+func sprtFlags(fs *flag.FlagSet) func() *SPRT {
+	enabled := fs.Bool("sprt", false, "run a sequential probability ratio test, stopping once it is decided")
+	elo0 := fs.Float64("elo0", 0, "SPRT null hypothesis, in Elo")
+	elo1 := fs.Float64("elo1", 5, "SPRT alternative hypothesis, in Elo")
+	alpha := fs.Float64("alpha", .05, "SPRT false positive rate")
+	beta := fs.Float64("beta", .05, "SPRT false negative rate")
+	return func() *SPRT {
+		if !*enabled {
+			return nil
+		}
+		return &SPRT{*elo0, *elo1, *alpha, *beta}
+	}
+}
+
+// SPRTCommand runs the sprt command: it reads the results of games from PGN files, or from standard input as they
+// arrive, and prints the statistics for a player after each game.
+func SPRTCommand(args []string) error {
+	fs := flag.NewFlagSet("sprt", flag.ExitOnError)
+	player := fs.String("player", "", "name of the player to compute statistics for (default the first game's White)")
+	test := sprtFlags(fs)
+	fs.Parse(args)
+	sprt := test()
+
+	var stats MatchStats
+	handle := func(tags map[string]string) error {
+		if *player == "" {
+			*player = tags["White"]
+		}
+		var score float64
+		switch tags["Result"] {
+		case "1-0":
+			score = 1
+		case "1/2-1/2":
+			score = .5
+		case "0-1":
+		default:
+			return nil
+		}
+		switch *player {
+		case tags["White"]:
+		case tags["Black"]:
+			score = 1 - score
+		default:
+			return nil
+		}
+		round, err := strconv.Atoi(tags["Round"])
+		if err != nil {
+			round = stats.Games() + 1
+		}
+		stats.Add(round, score)
+		fmt.Println(stats.Summary(sprt))
+		return nil
+	}
+
+	if fs.NArg() == 0 {
+		return readPGNTags(os.Stdin, handle)
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = readPGNTags(f, handle)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPGNTags calls handle with the tag pairs of each game in a PGN stream as soon as they have been read. Movetext
+// is skipped.
+func readPGNTags(r io.Reader, handle func(tags map[string]string) error) error {
+	scanner := bufio.NewScanner(r)
+	tags := make(map[string]string)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			fields := strings.SplitN(strings.Trim(line, "[]"), " ", 2)
+			if len(fields) == 2 {
+				value, err := strconv.Unquote(strings.TrimSpace(fields[1]))
+				if err != nil {
+					value = strings.Trim(fields[1], `"`)
+				}
+				tags[fields[0]] = value
+			}
+			continue
+		}
+		if line == "" && len(tags) > 0 {
+			if err := handle(tags); err != nil {
+				return err
+			}
+			tags = make(map[string]string)
+		}
+	}
+	if len(tags) > 0 {
+		if err := handle(tags); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streams tracks the streaming analyses in progress so that they can be stopped by id. Ids are random, so that only
+// the client which started an analysis can stop it.
+type streams struct {
+	mu     sync.Mutex
+	cancel map[string]context.CancelFunc
+}
+
+func (st *streams) add(cancel context.CancelFunc) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.cancel == nil {
+		st.cancel = make(map[string]context.CancelFunc)
+	}
+	st.cancel[id] = cancel
+	return id, nil
+}
+
+func (st *streams) remove(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.cancel, id)
+}
+
+// stop cancels the analysis with the given id. It reports whether such an analysis was running.
+func (st *streams) stop(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	cancel, ok := st.cancel[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+type stopRequest struct {
+	ID string `json:"id"`
+}
+
+// handleAnalyzeStream streams a search as Server-Sent Events. The position and limits are given as the query
+// parameters fen, depth, nodes and movetime, so that a browser's EventSource can connect directly. The stream opens
+// with a "started" event carrying the id to pass to /analyze/stop, sends an "info" event as each depth completes and
+// ends with a "bestmove" event. Without limits the search goes on until it's stopped or the server's timeout, as an
+// analysis board wants. Closing the connection also stops the search.
+func (s *Server) handleAnalyzeStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	query := r.URL.Query()
+	p, side, err := ParseFEN(query.Get("fen"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var limits SearchLimits
+	var moveTime int
+	for name, limit := range map[string]*int{"depth": &limits.Depth, "nodes": &limits.Nodes, "movetime": &moveTime} {
+		if value := query.Get(name); value != "" {
+			if *limit, err = strconv.Atoi(value); err != nil || *limit < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, value))
+				return
+			}
+		}
+	}
+	limits.MoveTime = time.Duration(moveTime) * time.Millisecond
+	if limits == (SearchLimits{}) {
+		limits.Depth = maxSearchDepth
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	id, err := s.streams.add(cancel)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer s.streams.remove(id)
+	if !s.acquire(ctx) {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("timed out waiting for a free search"))
+		return
+	}
+	defer s.release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, "started", stopRequest{id})
+	flusher.Flush()
+
+	result := Search(ctx, *NewGameFromPosition(p), side, limits, func(r SearchResult) {
+		writeEvent(w, "info", newAnalyzeResponse(r))
+		flusher.Flush()
+	})
+	writeEvent(w, "bestmove", newAnalyzeResponse(result))
+	flusher.Flush()
+}
+
+// handleAnalyzeStop stops a streaming analysis. The stream still ends with its "bestmove" event.
+func (s *Server) handleAnalyzeStop(w http.ResponseWriter, r *http.Request) {
+	var req stopRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !s.streams.stop(req.ID) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no analysis with id %q", req.ID))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+}
+
+// writeEvent writes a Server-Sent Event whose data is v encoded as JSON.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
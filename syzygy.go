@@ -0,0 +1,1158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The magic numbers which start Syzygy WDL and DTZ files.
+var (
+	syzygyWDLMagic = []byte{0x71, 0xe8, 0x23, 0x5d}
+	syzygyDTZMagic = []byte{0xd7, 0x66, 0x0c, 0xa5}
+)
+
+// syzygyPieces lists the piece letters in the order Syzygy file names use.
+const syzygyPieces = "KQRBNP"
+
+// syzygyMaxPieces is the most pieces, kings included, of any Syzygy table.
+const syzygyMaxPieces = 7
+
+// SyzygyTablebase probes the Syzygy tables in a directory. Tables are named by their material, the stronger side
+// first, e.g. KRvK.rtbw for the WDL table and KRvK.rtbz for the DTZ table of king and rook against king. A table is
+// read into memory when a position of its material is first probed.
+//
+// The decoding follows the probing code Syzygy's author wrote for Stockfish: positions are indexed by the squares of
+// their pieces, reduced by the board's symmetries, and each table is a sequence of values compressed by recursive
+// pairing and canonical Huffman codes in blocks, which an index into the table locates.
+type SyzygyTablebase struct {
+	// WDL and DTZ map the material of each table, e.g. "KRvK", to its file.
+	WDL, DTZ  map[string]string
+	maxPieces int
+
+	mu sync.Mutex
+	// tables holds the tables read so far by path, or nil for those which couldn't be read.
+	tables map[string]*syzygyTable
+}
+
+// OpenSyzygy finds the Syzygy tables in dir, checking that each is a Syzygy file.
+func OpenSyzygy(dir string) (*SyzygyTablebase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	tb := &SyzygyTablebase{
+		WDL:    make(map[string]string),
+		DTZ:    make(map[string]string),
+		tables: make(map[string]*syzygyTable),
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		material := strings.TrimSuffix(entry.Name(), ext)
+		var tables map[string]string
+		var magic []byte
+		switch ext {
+		case ".rtbw":
+			tables, magic = tb.WDL, syzygyWDLMagic
+		case ".rtbz":
+			tables, magic = tb.DTZ, syzygyDTZMagic
+		default:
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		pieces, ok := syzygyPieceCount(material)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a Syzygy table name", path)
+		}
+		if err := checkMagic(path, magic); err != nil {
+			return nil, err
+		}
+		tables[material] = path
+		if pieces > tb.maxPieces {
+			tb.maxPieces = pieces
+		}
+	}
+	if len(tb.WDL) == 0 {
+		return nil, fmt.Errorf("%s: no Syzygy WDL tables", dir)
+	}
+	return tb, nil
+}
+
+// syzygyPieceCount returns the number of pieces in a table's material, e.g. 3 for KRvK.
+func syzygyPieceCount(material string) (int, bool) {
+	sides := strings.Split(material, "v")
+	if len(sides) != 2 {
+		return 0, false
+	}
+	for _, side := range sides {
+		if !strings.HasPrefix(side, "K") || strings.Trim(side, syzygyPieces) != "" {
+			return 0, false
+		}
+	}
+	return len(sides[0]) + len(sides[1]), true
+}
+
+func checkMagic(path string, magic []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, magic) {
+		return fmt.Errorf("%s: not a Syzygy table", path)
+	}
+	return nil
+}
+
+// syzygyMaterial returns the name of the table holding the position, e.g. "KRvK", along with whether the table has
+// the position's colors reversed.
+func syzygyMaterial(p *Position) (string, bool) {
+	var letters [2][]byte
+	for r := range p.board {
+		for f := range p.board[r] {
+			piece := p.board[r][f]
+			switch piece.piece {
+			case None:
+			case Pawn:
+				letters[piece.color] = append(letters[piece.color], 'P')
+			default:
+				letters[piece.color] = append(letters[piece.color], sanPieces[piece.piece][0])
+			}
+		}
+	}
+	for _, side := range letters {
+		sort.Slice(side, func(i, j int) bool {
+			return strings.IndexByte(syzygyPieces, side[i]) < strings.IndexByte(syzygyPieces, side[j])
+		})
+	}
+	white, black := string(letters[White]), string(letters[Black])
+	if syzygyStronger(black, white) {
+		return black + "v" + white, true
+	}
+	return white + "v" + black, false
+}
+
+// syzygyStronger reports whether one side's material is named before the other's: by more pieces, then by more
+// valuable pieces.
+func syzygyStronger(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return strings.IndexByte(syzygyPieces, a[i]) < strings.IndexByte(syzygyPieces, b[i])
+		}
+	}
+	return false
+}
+
+// MaxPieces returns the most pieces of the tables found.
+func (tb *SyzygyTablebase) MaxPieces() int {
+	return tb.maxPieces
+}
+
+// Has reports whether the tables include the position's material.
+func (tb *SyzygyTablebase) Has(p *Position) bool {
+	material, _ := syzygyMaterial(p)
+	_, ok := tb.WDL[material]
+	return ok
+}
+
+// ProbeWDL returns the outcome of the position with side to move, with the fifty-move counter reset. Captures are
+// searched rather than probed, since the tables may hold any value for positions whose best move is a capture.
+func (tb *SyzygyTablebase) ProbeWDL(p *Position, side Side) (WDL, bool) {
+	wdl, result := tb.search(p, side, false)
+	return wdl, result != syzygyFailed
+}
+
+// ProbeDTZ returns the number of plies until the fifty-move counter is reset by a capture or pawn move, or until
+// mate, with best play and the counter reset. Cursed wins and blessed losses count 100 plies more than their tables
+// hold. Each DTZ table holds one side to move, so the other side's positions are probed by searching a ply.
+func (tb *SyzygyTablebase) ProbeDTZ(p *Position, side Side) (int, bool) {
+	wdl, result := tb.search(p, side, true)
+	switch {
+	case result == syzygyFailed:
+		return 0, false
+	case wdl == Draw:
+		// DTZ tables don't hold draws.
+		return 0, true
+	case result == syzygyZeroingBest:
+		return syzygyDTZBeforeZeroing(wdl), true
+	}
+
+	dtz, result := tb.probeTable(p, side, false, wdl)
+	switch result {
+	case syzygyFailed:
+		return 0, false
+	case syzygyOK:
+		if wdl == CursedWin || wdl == BlessedLoss {
+			dtz += 100
+		}
+		if wdl < 0 {
+			dtz = -dtz
+		}
+		return dtz, true
+	}
+
+	// The table holds the other side to move: play each move and take the quickest win or slowest loss.
+	best := math.MaxInt32
+	for _, move := range p.GetMoves(side) {
+		zeroing := p.board[move.oRank][move.oFile].piece == Pawn || p.board[move.nRank][move.nFile].piece != None
+		undo := p.makeMove(move)
+		var dtz int
+		ok := true
+		if zeroing {
+			// A zeroing move's own distance depends only on the outcome it leads to.
+			var childWDL WDL
+			childWDL, result = tb.search(p, side.OppSide(), false)
+			dtz, ok = -syzygyDTZBeforeZeroing(childWDL), result != syzygyFailed
+		} else {
+			dtz, ok = tb.ProbeDTZ(p, side.OppSide())
+			dtz = -dtz
+		}
+		if dtz == 1 && p.InCheck(side.OppSide()) && len(p.GetMoves(side.OppSide())) == 0 {
+			// The move mates.
+			best = 1
+		}
+		p.unmakeMove(undo)
+		if !ok {
+			return 0, false
+		}
+		if !zeroing {
+			dtz += sign(dtz)
+		}
+		if dtz < best && sign(dtz) == sign(int(wdl)) {
+			best = dtz
+		}
+	}
+	if best == math.MaxInt32 {
+		// Mated.
+		return -1, true
+	}
+	return best, true
+}
+
+// sign returns -1, 0 or 1 as n is negative, zero or positive.
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// syzygyDTZBeforeZeroing returns the distance to zeroing of a position whose best move is a zeroing move leading to
+// the outcome wdl.
+func syzygyDTZBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case Win:
+		return 1
+	case CursedWin:
+		return 101
+	case BlessedLoss:
+		return -101
+	case Loss:
+		return -1
+	}
+	return 0
+}
+
+// syzygyResult is how a probe of the tables went.
+type syzygyResult int
+
+const (
+	syzygyOK syzygyResult = iota
+	// syzygyFailed means a table wasn't found or couldn't be read.
+	syzygyFailed
+	// syzygyChangeSide means the DTZ table holds the other side to move.
+	syzygyChangeSide
+	// syzygyZeroingBest means the best move is a capture or pawn move, for which the tables may hold any value.
+	syzygyZeroingBest
+)
+
+// search returns the outcome of the position with side to move, searching captures, and pawn moves too when zeroing
+// is set, and probing the WDL table for the rest of the moves. The result is syzygyZeroingBest when one of the moves
+// searched is the best.
+func (tb *SyzygyTablebase) search(p *Position, side Side, zeroing bool) (WDL, syzygyResult) {
+	best := Loss
+	moves := p.GetMoves(side)
+	searched := 0
+	for _, move := range moves {
+		pawn := p.board[move.oRank][move.oFile].piece == Pawn
+		capture := p.board[move.nRank][move.nFile].piece != None || pawn && move.oFile != move.nFile
+		if !capture && (!zeroing || !pawn) {
+			continue
+		}
+		searched++
+		undo := p.makeMove(move)
+		wdl, result := tb.search(p, side.OppSide(), false)
+		p.unmakeMove(undo)
+		if result == syzygyFailed {
+			return Draw, syzygyFailed
+		}
+		if -wdl > best {
+			best = -wdl
+			if best >= Win {
+				return best, syzygyZeroingBest
+			}
+		}
+	}
+
+	// When every move was searched the table needn't be probed, which matters as it may be wrong: tables don't hold
+	// en passant rights.
+	allSearched := searched > 0 && searched == len(moves)
+	value := best
+	if !allSearched {
+		v, result := tb.probeTable(p, side, true, Draw)
+		if result == syzygyFailed {
+			return Draw, syzygyFailed
+		}
+		value = WDL(v)
+	}
+	if best >= value {
+		if best > Draw || allSearched {
+			return best, syzygyZeroingBest
+		}
+		return best, syzygyOK
+	}
+	return value, syzygyOK
+}
+
+// probeTable returns the value the WDL or DTZ table of the position's material holds for it. A DTZ table's value is
+// the distance to zeroing of a position whose outcome is wdl, without its sign.
+func (tb *SyzygyTablebase) probeTable(p *Position, side Side, wdl bool, outcome WDL) (int, syzygyResult) {
+	material, reversed := syzygyMaterial(p)
+	if material == "KvK" {
+		return int(Draw), syzygyOK
+	}
+	paths := tb.DTZ
+	if wdl {
+		paths = tb.WDL
+	}
+	path, ok := paths[material]
+	if !ok {
+		return 0, syzygyFailed
+	}
+	t := tb.table(path, material, wdl)
+	if t == nil {
+		return 0, syzygyFailed
+	}
+
+	d, idx, stm := t.encode(p, side, reversed)
+	if !wdl && int(d.flags&syzygySTM) != stm && !(t.symmetric && !t.hasPawns) {
+		return 0, syzygyChangeSide
+	}
+	value, ok := d.decompress(idx)
+	if !ok {
+		return 0, syzygyFailed
+	}
+	if wdl {
+		return value - 2, syzygyOK
+	}
+	value, ok = t.dtzValue(d, value, outcome)
+	if !ok {
+		return 0, syzygyFailed
+	}
+	return value, syzygyOK
+}
+
+// table returns the table in the file at path, reading it the first time, or nil if it can't be read.
+func (tb *SyzygyTablebase) table(path, material string, wdl bool) *syzygyTable {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if t, ok := tb.tables[path]; ok {
+		return t
+	}
+	var t *syzygyTable
+	data, err := os.ReadFile(path)
+	if err == nil {
+		t, err = parseSyzygyTable(data, material, wdl)
+	}
+	if err != nil {
+		t = nil
+	}
+	tb.tables[path] = t
+	return t
+}
+
+// The flags of a table's pairs data.
+const (
+	// syzygySTM is the side to move of the positions a DTZ table holds, 1 for Black.
+	syzygySTM = 1
+	// syzygyMapped means DTZ values are indices into a list of values for each outcome.
+	syzygyMapped = 2
+	// syzygyWinPlies and syzygyLossPlies mean DTZ values of wins and losses are in plies rather than moves.
+	syzygyWinPlies  = 4
+	syzygyLossPlies = 8
+	// syzygyWide means the lists of DTZ values hold 16 bit values rather than bytes.
+	syzygyWide = 16
+	// syzygySingleValue means every position has the same value.
+	syzygySingleValue = 128
+)
+
+// syzygyPieceCodes are the codes Syzygy tables give the pieces of the stronger side. Those of the weaker side have 8
+// added.
+var syzygyPieceCodes = [...]byte{Pawn: 1, Rook: 4, Knight: 2, Bishop: 3, Queen: 5, King: 6}
+
+// The tables which map the squares of pieces to the index of a position.
+var (
+	// syzygyBinomial[k][n] is the number of ways to choose k things from n.
+	syzygyBinomial [syzygyMaxPieces][64]uint64
+	// syzygyMapB1H1H7 numbers the squares below the a1-h8 diagonal, and syzygyMapA1D1D4 the squares of the a1-d1-d4
+	// triangle, those on the diagonal last.
+	syzygyMapB1H1H7 [64]int
+	syzygyMapA1D1D4 [64]int
+	// syzygyMapKK numbers the legal placements of two kings, the first on the a1-d1-d4 triangle, indexed by the first
+	// king's syzygyMapA1D1D4 number and the second's square.
+	syzygyMapKK [10][64]int
+	// syzygyMapPawns numbers the squares pawns may stand on, from those nearest the edges and the first rank. The pawn
+	// with the highest number leads.
+	syzygyMapPawns [64]int
+	// syzygyLeadPawnIdx is the index of each placement of the leading pawn, by the number of leading pawns, and
+	// syzygyLeadPawnsSize the number of placements with the leading pawn on each file from a to d.
+	syzygyLeadPawnIdx   [6][64]uint64
+	syzygyLeadPawnsSize [6][4]uint64
+)
+
+// offA1H8 returns how far a square is above the a1-h8 diagonal, negative when it's below.
+func offA1H8(sq int) int {
+	return sq/8 - sq%8
+}
+
+func init() {
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if offA1H8(sq) < 0 {
+			syzygyMapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	code = 0
+	var diagonal []int
+	for sq := 0; sq < 28; sq++ {
+		switch {
+		case sq%8 > 3:
+		case offA1H8(sq) < 0:
+			syzygyMapA1D1D4[sq] = code
+			code++
+		case offA1H8(sq) == 0:
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		syzygyMapA1D1D4[sq] = code
+		code++
+	}
+
+	// Placements with both kings on the diagonal come last.
+	code = 0
+	var bothOnDiagonal [][2]int
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 < 28; s1++ {
+			if s1%8 > 3 || syzygyMapA1D1D4[s1] != idx || idx == 0 && s1 != 1 {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				df, dr := s1%8-s2%8, s1/8-s2/8
+				switch {
+				case df >= -1 && df <= 1 && dr >= -1 && dr <= 1:
+					// The kings touch.
+				case offA1H8(s1) == 0 && offA1H8(s2) > 0:
+					// The reflection in the diagonal has the second king below it.
+				case offA1H8(s1) == 0 && offA1H8(s2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, [2]int{idx, s2})
+				default:
+					syzygyMapKK[idx][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, kings := range bothOnDiagonal {
+		syzygyMapKK[kings[0]][kings[1]] = code
+		code++
+	}
+
+	syzygyBinomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < syzygyMaxPieces && k <= n; k++ {
+			if k > 0 {
+				syzygyBinomial[k][n] += syzygyBinomial[k-1][n-1]
+			}
+			if k < n {
+				syzygyBinomial[k][n] += syzygyBinomial[k][n-1]
+			}
+		}
+	}
+
+	available := 47
+	for leadPawns := 1; leadPawns < 6; leadPawns++ {
+		for f := 0; f < 4; f++ {
+			idx := uint64(0)
+			for r := 1; r < 7; r++ {
+				sq := r*8 + f
+				if leadPawns == 1 {
+					syzygyMapPawns[sq] = available
+					syzygyMapPawns[sq^7] = available - 1
+					available -= 2
+				}
+				syzygyLeadPawnIdx[leadPawns][sq] = idx
+				idx += syzygyBinomial[leadPawns-1][syzygyMapPawns[sq]]
+			}
+			syzygyLeadPawnsSize[leadPawns][f] = idx
+		}
+	}
+}
+
+// syzygyTable is a WDL or DTZ table read from its file.
+type syzygyTable struct {
+	wdl bool
+	// symmetric is whether both sides have the same material, in which case only White to move is held.
+	symmetric bool
+	hasPawns  bool
+	// bothPawns is whether both sides have pawns.
+	bothPawns  bool
+	pieceCount int
+	// uniquePieces is whether either side has a piece other than its king of which it has just one. Three such
+	// pieces, kings included, are placed together.
+	uniquePieces bool
+	// pairs holds the pairs data of each side to move, and of each file of the leading pawn when there are pawns.
+	// DTZ tables hold one side to move.
+	pairs [2][4]*syzygyPairs
+	data  []byte
+}
+
+// syzygyPairs is the compressed data of the positions of a table with one side to move and, in tables with pawns,
+// the leading pawn on one file.
+type syzygyPairs struct {
+	// pieces lists the pieces in the order of the index, and groupLen the number of pieces of each group of the
+	// index, ending with 0. groupIdx is the factor of each group's index, the last being the number of positions.
+	pieces   [syzygyMaxPieces]byte
+	groupLen [syzygyMaxPieces + 1]int
+	groupIdx [syzygyMaxPieces + 1]uint64
+	flags    byte
+	// singleValue is the value of every position when the flags say there's just one.
+	singleValue int
+	// Positions are stored in blocks of blockSize bytes, each holding the values of a run of positions, the number
+	// of which less one blockLength lists. sparseIndex gives the block and offset in it of every span positions,
+	// starting at span/2.
+	blockSize       int
+	span            uint64
+	sparseIndex     []byte
+	blockLength     []byte
+	blockLengthSize int
+	blocks          int
+	blockData       []byte
+	// Symbols are canonical Huffman codes of minSymLen bits and more. lowestSym holds the first symbol of each length
+	// and base the smallest code of each length, aligned to 64 bits.
+	minSymLen int
+	lowestSym []byte
+	base      []uint64
+	// Each symbol stands for symLen+1 values: a value itself, or a pair of other symbols in btree.
+	symLen []int
+	btree  []byte
+	// mapIdx is where the list of DTZ values of each outcome starts in the table's data.
+	mapIdx [4]int
+}
+
+var errSyzygyCorrupt = errors.New("corrupt Syzygy table")
+
+// newSyzygyTable returns a table of the material, without its data.
+func newSyzygyTable(material string, wdl bool) *syzygyTable {
+	sides := strings.Split(material, "v")
+	t := &syzygyTable{wdl: wdl, symmetric: sides[0] == sides[1], pieceCount: len(sides[0]) + len(sides[1])}
+	t.hasPawns = strings.Contains(material, "P")
+	t.bothPawns = strings.Contains(sides[0], "P") && strings.Contains(sides[1], "P")
+	for _, side := range sides {
+		for _, letter := range side[1:] {
+			if strings.Count(side, string(letter)) == 1 {
+				t.uniquePieces = true
+			}
+		}
+	}
+	return t
+}
+
+// parseSyzygyTable reads the table of the material from a file's contents.
+func parseSyzygyTable(data []byte, material string, wdl bool) (*syzygyTable, error) {
+	magic := syzygyDTZMagic
+	if wdl {
+		magic = syzygyWDLMagic
+	}
+	if len(data) < 5 || !bytes.Equal(data[:4], magic) {
+		return nil, errSyzygyCorrupt
+	}
+	t := newSyzygyTable(material, wdl)
+	t.data = data
+	if (data[4]&2 != 0) != t.hasPawns {
+		return nil, errSyzygyCorrupt
+	}
+
+	// The header lists the order of the groups and the pieces for each file, the sides in the low and high nibbles.
+	sides, files := t.sides(), t.files()
+	pos := 5
+	for f := 0; f < files; f++ {
+		orderBytes := 1
+		if t.bothPawns {
+			orderBytes = 2
+		}
+		if pos+orderBytes+t.pieceCount > len(data) {
+			return nil, errSyzygyCorrupt
+		}
+		order := [2][2]int{{int(data[pos] & 0xf), 0xf}, {int(data[pos] >> 4), 0xf}}
+		if t.bothPawns {
+			order[0][1], order[1][1] = int(data[pos+1]&0xf), int(data[pos+1]>>4)
+		}
+		pos += orderBytes
+		for i := 0; i < sides; i++ {
+			d := &syzygyPairs{}
+			for k := 0; k < t.pieceCount; k++ {
+				d.pieces[k] = data[pos+k] >> (4 * uint(i)) & 0xf
+			}
+			t.setGroups(d, order[i], f)
+			t.pairs[i][f] = d
+		}
+		pos += t.pieceCount
+	}
+	pos += pos & 1
+
+	var err error
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			if pos, err = t.pairs[i][f].setSizes(data, pos); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !wdl {
+		if pos, err = t.setDTZMap(data, pos); err != nil {
+			return nil, err
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.pairs[i][f]
+			if d.flags&syzygySingleValue != 0 {
+				continue
+			}
+			d.sparseIndex, pos, err = syzygySlice(data, pos, 6*int((d.groupIdx[d.groupCount()]+d.span-1)/d.span))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.pairs[i][f]
+			if d.blockLength, pos, err = syzygySlice(data, pos, 2*d.blockLengthSize); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.pairs[i][f]
+			pos = (pos + 0x3f) &^ 0x3f
+			if _, _, err = syzygySlice(data, pos, d.blocks*d.blockSize); err != nil {
+				return nil, err
+			}
+			// Decoding may read a little past a block, so the data runs on to the end of the file.
+			d.blockData = data[pos:]
+			pos += d.blocks * d.blockSize
+		}
+	}
+	return t, nil
+}
+
+// syzygySlice returns the n bytes of data at pos, and the position after them.
+func syzygySlice(data []byte, pos, n int) ([]byte, int, error) {
+	if n < 0 || pos+n > len(data) {
+		return nil, 0, errSyzygyCorrupt
+	}
+	return data[pos : pos+n], pos + n, nil
+}
+
+// sides returns the number of sides to move the table holds.
+func (t *syzygyTable) sides() int {
+	if t.wdl && !t.symmetric {
+		return 2
+	}
+	return 1
+}
+
+// files returns the number of files of the leading pawn the table is split by.
+func (t *syzygyTable) files() int {
+	if t.hasPawns {
+		return 4
+	}
+	return 1
+}
+
+// setGroups divides the pieces into the groups of the index: the leading pieces or pawns, then the other side's pawns
+// and then pieces alike. The order gives where the leading group and the other pawns come among the groups.
+func (t *syzygyTable) setGroups(d *syzygyPairs, order [2]int, file int) {
+	n, firstLen := 0, 0
+	if !t.hasPawns {
+		firstLen = 2
+		if t.uniquePieces {
+			firstLen = 3
+		}
+	}
+	d.groupLen[0] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	next := 1
+	free := 64 - d.groupLen[0]
+	if t.bothPawns {
+		next = 2
+		free -= d.groupLen[1]
+	}
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch {
+		case k == order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= syzygyLeadPawnsSize[d.groupLen[0]][file]
+			case t.uniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case k == order[1]:
+			d.groupIdx[1] = idx
+			idx *= syzygyBinomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= syzygyBinomial[d.groupLen[next]][free]
+			free -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+// groupCount returns the number of groups of the index.
+func (d *syzygyPairs) groupCount() int {
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	return n
+}
+
+// setSizes reads the sizes of the pairs data and its symbols at pos, and returns the position after them.
+func (d *syzygyPairs) setSizes(data []byte, pos int) (int, error) {
+	if pos+2 > len(data) {
+		return 0, errSyzygyCorrupt
+	}
+	d.flags = data[pos]
+	if d.flags&syzygySingleValue != 0 {
+		d.singleValue = int(data[pos+1])
+		return pos + 2, nil
+	}
+	if pos+10 > len(data) || data[pos+1] > 31 || data[pos+2] > 63 {
+		return 0, errSyzygyCorrupt
+	}
+	d.blockSize = 1 << data[pos+1]
+	d.span = 1 << data[pos+2]
+	padding := int(data[pos+3])
+	d.blocks = int(binary.LittleEndian.Uint32(data[pos+4:]))
+	d.blockLengthSize = d.blocks + padding
+	maxSymLen, minSymLen := int(data[pos+8]), int(data[pos+9])
+	if minSymLen < 1 || maxSymLen < minSymLen || maxSymLen > 32 {
+		return 0, errSyzygyCorrupt
+	}
+	d.minSymLen = minSymLen
+	var err error
+	lengths := maxSymLen - minSymLen + 1
+	if d.lowestSym, pos, err = syzygySlice(data, pos+10, 2*lengths); err != nil {
+		return 0, err
+	}
+
+	// Longer codes have lower values, so the smallest code of each length can be worked out from the longest,
+	// which is 0.
+	d.base = make([]uint64, lengths)
+	for i := lengths - 2; i >= 0; i-- {
+		d.base[i] = (d.base[i+1] + uint64(d.lowest(i)) - uint64(d.lowest(i+1))) / 2
+	}
+	for i := range d.base {
+		d.base[i] <<= uint(64 - i - minSymLen)
+	}
+
+	if pos+2 > len(data) {
+		return 0, errSyzygyCorrupt
+	}
+	symbols := int(binary.LittleEndian.Uint16(data[pos:]))
+	if d.btree, pos, err = syzygySlice(data, pos+2, 3*symbols); err != nil {
+		return 0, err
+	}
+	d.symLen = make([]int, symbols)
+	visited := make([]bool, symbols)
+	for sym := range d.symLen {
+		if !visited[sym] && !d.setSymLen(sym, visited) {
+			return 0, errSyzygyCorrupt
+		}
+	}
+	return pos + symbols&1, nil
+}
+
+// setSymLen works out the number of values less one which a symbol and the symbols it pairs stand for.
+func (d *syzygyPairs) setSymLen(sym int, visited []bool) bool {
+	visited[sym] = true
+	right := d.right(sym)
+	if right == 0xfff {
+		return true
+	}
+	left := d.left(sym)
+	for _, child := range [...]int{left, right} {
+		if child >= len(d.symLen) || !visited[child] && !d.setSymLen(child, visited) {
+			return false
+		}
+	}
+	d.symLen[sym] = d.symLen[left] + d.symLen[right] + 1
+	return true
+}
+
+// lowest returns the first symbol of the ith code length.
+func (d *syzygyPairs) lowest(i int) int {
+	return int(binary.LittleEndian.Uint16(d.lowestSym[2*i:]))
+}
+
+// left returns the first symbol of the pair a symbol stands for, or its value when it stands for just one.
+func (d *syzygyPairs) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xf)<<8 | int(d.btree[3*sym])
+}
+
+// right returns the second symbol of the pair a symbol stands for, or 0xfff when it stands for a value.
+func (d *syzygyPairs) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+// blockLen returns the number of values less one in a block.
+func (d *syzygyPairs) blockLen(block int) int {
+	return int(binary.LittleEndian.Uint16(d.blockLength[2*block:]))
+}
+
+// word returns the big-endian 32 bits at i in the block data, or 0 past its end.
+func (d *syzygyPairs) word(i int) uint64 {
+	if i+4 > len(d.blockData) {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(d.blockData[i:]))
+}
+
+// setDTZMap reads the lists of DTZ values at pos, and returns the position after them.
+func (t *syzygyTable) setDTZMap(data []byte, pos int) (int, error) {
+	for f := 0; f < t.files(); f++ {
+		d := t.pairs[0][f]
+		if d.flags&syzygyMapped == 0 {
+			continue
+		}
+		if d.flags&syzygyWide != 0 {
+			pos += pos & 1
+		}
+		for i := range d.mapIdx {
+			if pos+2 > len(data) {
+				return 0, errSyzygyCorrupt
+			}
+			if d.flags&syzygyWide != 0 {
+				d.mapIdx[i] = pos + 2
+				pos += 2 + 2*int(binary.LittleEndian.Uint16(data[pos:]))
+			} else {
+				d.mapIdx[i] = pos + 1
+				pos += 1 + int(data[pos])
+			}
+		}
+	}
+	return pos + pos&1, nil
+}
+
+// dtzValue converts a value of a DTZ table to plies, for a position whose outcome is wdl.
+func (t *syzygyTable) dtzValue(d *syzygyPairs, value int, wdl WDL) (int, bool) {
+	if d.flags&syzygyMapped != 0 {
+		// The lists are of wins, losses, cursed wins and blessed losses.
+		i := d.mapIdx[[...]int{1, 3, 0, 2, 0}[wdl+2]]
+		if d.flags&syzygyWide != 0 {
+			i += 2 * value
+			if i+2 > len(t.data) {
+				return 0, false
+			}
+			value = int(binary.LittleEndian.Uint16(t.data[i:]))
+		} else {
+			i += value
+			if i >= len(t.data) {
+				return 0, false
+			}
+			value = int(t.data[i])
+		}
+	}
+	if wdl == Win && d.flags&syzygyWinPlies == 0 || wdl == Loss && d.flags&syzygyLossPlies == 0 ||
+		wdl == CursedWin || wdl == BlessedLoss {
+		value *= 2
+	}
+	return value + 1, true
+}
+
+// encode returns the pairs data holding the position with side to move, its index there, and the side to move of the
+// table's positions it corresponds to, 1 for Black. reversed is whether Black has the material the table names first.
+func (t *syzygyTable) encode(p *Position, side Side, reversed bool) (*syzygyPairs, uint64, int) {
+	// Tables hold the side named first as White, and symmetric ones only White to move, so the colors of other
+	// positions are swapped and the board reflected between the first and last ranks.
+	flip := reversed || t.symmetric && side == Black
+	var flipColor byte
+	flipSquares := 0
+	stm := int(side)
+	if flip {
+		flipColor, flipSquares, stm = 8, 56, stm^1
+	}
+
+	var squares [syzygyMaxPieces]int
+	var pieces [syzygyMaxPieces]byte
+	size, leadPawns, file := 0, 0, 0
+	lead := GamePiece{None, White}
+	if t.hasPawns {
+		// The leading pawns are those of the color of the first piece, and the one with the highest number leads.
+		lead = GamePiece{Pawn, Side(t.pairs[0][0].pieces[0] >> 3)}
+		if flip {
+			lead.color = lead.color.OppSide()
+		}
+		for sq := 0; sq < 64; sq++ {
+			if p.board[sq/8][sq%8] == lead {
+				squares[size] = sq ^ flipSquares
+				if syzygyMapPawns[squares[size]] > syzygyMapPawns[squares[0]] {
+					squares[0], squares[size] = squares[size], squares[0]
+				}
+				size++
+			}
+		}
+		leadPawns = size
+		file = squares[0] % 8
+		if file > 3 {
+			file = 7 - file
+		}
+	}
+
+	for sq := 0; sq < 64; sq++ {
+		piece := p.board[sq/8][sq%8]
+		if piece.piece == None || piece == lead {
+			continue
+		}
+		squares[size] = sq ^ flipSquares
+		pieces[size] = (syzygyPieceCodes[piece.piece] | byte(piece.color)<<3) ^ flipColor
+		size++
+	}
+
+	sideIdx := 0
+	if t.sides() == 2 {
+		sideIdx = stm
+	}
+	d := t.pairs[sideIdx][file]
+
+	// Put the pieces in the table's order.
+	for i := leadPawns; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Reflect the board so that the leading piece is on the a-d files.
+	if squares[0]%8 > 3 {
+		for i := range squares[:size] {
+			squares[i] ^= 7
+		}
+	}
+
+	var idx uint64
+	if t.hasPawns {
+		idx = syzygyLeadPawnIdx[leadPawns][squares[0]]
+		others := squares[1:leadPawns]
+		sort.SliceStable(others, func(i, j int) bool { return syzygyMapPawns[others[i]] < syzygyMapPawns[others[j]] })
+		for i := 1; i < leadPawns; i++ {
+			idx += syzygyBinomial[i][syzygyMapPawns[squares[i]]]
+		}
+	} else {
+		// Without pawns, the board is also reflected so that the leading piece is on the first four ranks, and then
+		// in the a1-h8 diagonal so that the first piece of the leading group off it is below it.
+		if squares[0]/8 > 3 {
+			for i := range squares[:size] {
+				squares[i] ^= 56
+			}
+		}
+		for i := 0; i < d.groupLen[0]; i++ {
+			off := offA1H8(squares[i])
+			if off == 0 {
+				continue
+			}
+			if off > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = (squares[j]>>3 | squares[j]<<3) & 63
+				}
+			}
+			break
+		}
+		idx = t.encodeLeading(squares[:size])
+	}
+	idx *= d.groupIdx[0]
+
+	// The other groups are indexed by their squares in order, leaving out the squares of the groups before them, and
+	// the first and last ranks for the pawns of the side which doesn't lead.
+	remainingPawns := t.bothPawns
+	start := d.groupLen[0]
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		sort.Ints(group)
+		var n uint64
+		for i, sq := range group {
+			adjust := 0
+			for _, before := range squares[:start] {
+				if sq > before {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += syzygyBinomial[i+1][sq-adjust]
+		}
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+	return d, idx, stm
+}
+
+// encodeLeading returns the index of the leading group of a table without pawns: three unique pieces placed
+// together, or the two kings.
+func (t *syzygyTable) encodeLeading(squares []int) uint64 {
+	if !t.uniquePieces {
+		return uint64(syzygyMapKK[syzygyMapA1D1D4[squares[0]]][squares[1]])
+	}
+	s0, s1, s2 := squares[0], squares[1], squares[2]
+	adjust1 := 0
+	if s1 > s0 {
+		adjust1 = 1
+	}
+	adjust2 := 0
+	if s2 > s0 {
+		adjust2++
+	}
+	if s2 > s1 {
+		adjust2++
+	}
+	var idx int
+	switch {
+	case offA1H8(s0) != 0:
+		idx = (syzygyMapA1D1D4[s0]*63+s1-adjust1)*62 + s2 - adjust2
+	case offA1H8(s1) != 0:
+		idx = (6*63+(s0/8)*28+syzygyMapB1H1H7[s1])*62 + s2 - adjust2
+	case offA1H8(s2) != 0:
+		idx = 6*63*62 + 4*28*62 + (s0/8)*7*28 + (s1/8-adjust1)*28 + syzygyMapB1H1H7[s2]
+	default:
+		idx = 6*63*62 + 4*28*62 + 4*7*28 + (s0/8)*7*6 + (s1/8-adjust1)*6 + s2/8 - adjust2
+	}
+	return uint64(idx)
+}
+
+// decompress returns the value of the position with the index.
+func (d *syzygyPairs) decompress(idx uint64) (int, bool) {
+	if d.flags&syzygySingleValue != 0 {
+		return d.singleValue, true
+	}
+
+	// The sparse index gives the block and offset of a nearby position, from which the blocks are walked to the one
+	// holding the position.
+	k := idx / d.span
+	if 6*k+6 > uint64(len(d.sparseIndex)) {
+		return 0, false
+	}
+	block := int(binary.LittleEndian.Uint32(d.sparseIndex[6*k:]))
+	offset := int(binary.LittleEndian.Uint16(d.sparseIndex[6*k+4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+	for offset < 0 {
+		block--
+		if block < 0 {
+			return 0, false
+		}
+		offset += d.blockLen(block) + 1
+	}
+	for {
+		if block >= d.blockLengthSize {
+			return 0, false
+		}
+		if offset <= d.blockLen(block) {
+			break
+		}
+		offset -= d.blockLen(block) + 1
+		block++
+	}
+
+	// Read the block's symbols until the one standing for the value.
+	ptr := block * d.blockSize
+	buf := d.word(ptr)<<32 | d.word(ptr+4)
+	ptr += 8
+	bufSize := 64
+	var sym int
+	for {
+		length := 0
+		for buf < d.base[length] {
+			length++
+		}
+		sym = int((buf-d.base[length])>>uint(64-length-d.minSymLen)) + d.lowest(length)
+		if sym >= len(d.symLen) {
+			return 0, false
+		}
+		if offset < d.symLen[sym]+1 {
+			break
+		}
+		offset -= d.symLen[sym] + 1
+		length += d.minSymLen
+		buf <<= uint(length)
+		bufSize -= length
+		if bufSize <= 32 {
+			bufSize += 32
+			buf |= d.word(ptr) << uint(64-bufSize)
+			ptr += 4
+		}
+	}
+
+	// Expand the symbol's pairs down to the value.
+	for d.symLen[sym] != 0 {
+		left := d.left(sym)
+		if offset < d.symLen[left]+1 {
+			sym = left
+		} else {
+			offset -= d.symLen[left] + 1
+			sym = d.right(sym)
+		}
+	}
+	return d.left(sym), true
+}
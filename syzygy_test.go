@@ -0,0 +1,980 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The tables in testdata/syzygy-roundtrip aren't real Syzygy tables: TestWriteSyzygyFixtures writes them in Syzygy's
+// format from RobChess's own retrograde tables, indexing positions with the decoder's own encoding. Reading them back
+// only shows the decoder agrees with that writer. TestSyzygyReal checks real tables, given with -syzygy <dir>, and
+// TestSyzygyIndex checks the encoding against the board's symmetries. Run with -update-syzygy to write the tables again.
+var updateSyzygy = flag.Bool("update-syzygy", false, "write the Syzygy tables in testdata/syzygy-roundtrip again")
+
+const syzygyFixtureDir = "testdata/syzygy-roundtrip"
+
+// syzygyFixtures are the materials of the tables in testdata/syzygy-roundtrip. KBvK and KNvK are there for the pawn's
+// underpromotions.
+var syzygyFixtures = []string{"KQvK", "KRvK", "KBvK", "KNvK", "KPvK"}
+
+// syzygyKnownPositions are positions of three pieces whose outcomes and distances to zeroing are known: the longest
+// wins of KQvK and KRvK, mates and stalemates, and pawn endings decided by the rule of the square.
+var syzygyKnownPositions = []struct {
+	name, fen string
+	wdl       WDL
+	dtz       int
+}{
+	{"KQvK, longest win", "8/8/8/5k2/8/8/1Q6/K7 w - - 0 1", Win, 19},
+	{"KQvK, colors reversed", "k7/1q6/8/8/5K2/8/8/8 b - - 0 1", Win, 19},
+	{"KRvK, longest win", "8/8/8/8/8/2k5/1R6/K7 w - - 0 1", Win, 31},
+	{"KQvK, mated", "k7/1Q6/1K6/8/8/8/8/8 b - - 0 1", Loss, -1},
+	{"KQvK, mate in one", "k7/8/1K6/8/8/8/8/6Q1 w - - 0 1", Win, 1},
+	{"KQvK, stalemate", "k7/2Q5/1K6/8/8/8/8/8 b - - 0 1", Draw, 0},
+	{"KQvK, queen taken", "8/8/8/8/8/8/1kQ5/7K b - - 0 1", Draw, 0},
+	{"KBvK", "8/8/8/8/8/8/1B6/K6k w - - 0 1", Draw, 0},
+	{"KNvK", "8/8/8/8/8/8/1N6/K6k b - - 0 1", Draw, 0},
+	{"KPvK, outside the square", "8/8/8/8/k7/8/6P1/K7 w - - 0 1", Win, 1},
+	{"KPvK, promotes", "8/6P1/8/8/8/k7/8/K7 w - - 0 1", Win, 1},
+	{"KPvK, rook pawn", "k7/8/8/8/8/8/P7/K7 w - - 0 1", Draw, 0},
+	{"KPvK, pawn taken", "8/8/8/8/8/3k4/4P3/6K1 b - - 0 1", Draw, 0},
+	{"KPvK, colors reversed", "k7/6p1/8/K7/8/8/8/8 b - - 0 1", Win, 1},
+}
+
+func openSyzygyFixtures(t *testing.T) *SyzygyTablebase {
+	t.Helper()
+	tb, err := OpenSyzygy(syzygyFixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func TestSyzygyOpen(t *testing.T) {
+	tb := openSyzygyFixtures(t)
+	if len(tb.WDL) != len(syzygyFixtures) || len(tb.DTZ) != len(syzygyFixtures) {
+		t.Errorf("found %d WDL and %d DTZ tables, want %d of each", len(tb.WDL), len(tb.DTZ), len(syzygyFixtures))
+	}
+	if tb.MaxPieces() != 3 {
+		t.Errorf("MaxPieces() = %d, want 3", tb.MaxPieces())
+	}
+}
+
+// TestSyzygyReal probes real Syzygy tables, which must include KQvK, KRvK and KPvK, when run with -syzygy <dir>. Real
+// DTZ tables may count wins and losses in moves rather than plies, so their distances may be a ply long.
+func TestSyzygyReal(t *testing.T) {
+	if *syzygyPath == "" {
+		t.Skip("run with -syzygy <dir> to probe real Syzygy tables")
+	}
+	tb, err := OpenSyzygy(*syzygyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, material := range []string{"KQvK", "KRvK", "KPvK"} {
+		if tb.WDL[material] == "" || tb.DTZ[material] == "" {
+			t.Fatalf("%s has no %s tables", *syzygyPath, material)
+		}
+	}
+	for _, test := range syzygyKnownPositions {
+		p, side, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wdl, ok := tb.ProbeWDL(p, side)
+		if !ok || wdl != test.wdl {
+			t.Errorf("%s, %s: WDL %v (%v), want %v", test.name, test.fen, wdl, ok, test.wdl)
+		}
+		dtz, ok := tb.ProbeDTZ(p, side)
+		if !ok || dtz != test.dtz && dtz != test.dtz+sign(test.dtz) {
+			t.Errorf("%s, %s: DTZ %v (%v), want %v", test.name, test.fen, dtz, ok, test.dtz)
+		}
+	}
+}
+
+func TestSyzygyProbe(t *testing.T) {
+	tb := openSyzygyFixtures(t)
+	for _, test := range syzygyKnownPositions {
+		p, side, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wdl, ok := tb.ProbeWDL(p, side)
+		if !ok || wdl != test.wdl {
+			t.Errorf("%s, %s: WDL %v (%v), want %v", test.name, test.fen, wdl, ok, test.wdl)
+		}
+		dtz, ok := tb.ProbeDTZ(p, side)
+		if !ok || dtz != test.dtz {
+			t.Errorf("%s, %s: DTZ %v (%v), want %v", test.name, test.fen, dtz, ok, test.dtz)
+		}
+	}
+
+	// Materials without tables aren't covered.
+	p, side, err := ParseFEN("8/8/8/8/k7/8/8/2Q3RK w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tb.ProbeWDL(p, side); ok {
+		t.Error("probed a position without a table")
+	}
+}
+
+// TestSyzygyIndex checks the indices of every position of KQvK and KPvK against the board's symmetries, which are all
+// the index leaves out: positions share an index when, and only when, a symmetry maps one onto the other. Without
+// pawns the board can be mirrored, flipped and turned, and with them only mirrored.
+func TestSyzygyIndex(t *testing.T) {
+	for _, material := range []string{"KQvK", "KPvK"} {
+		table := newSyzygyIndex(material)
+		pieces := syzygyFixturePieces(material)
+		symmetries := 8
+		if table.hasPawns {
+			symmetries = 2
+		}
+		classes := make(map[[4]int]uint64)
+		indices := make(map[uint64][4]int)
+		p := emptyPosition()
+		squares := make([]int, len(pieces))
+		for n := 0; n < 64*64*64; n++ {
+			squares[0], squares[1], squares[2] = n/4096, n/64%64, n%64
+			for side := White; side <= Black; side++ {
+				if !placeSyzygyPieces(p, pieces, squares, side) {
+					continue
+				}
+				d, idx, stm := table.encode(p, side, false)
+				clearSyzygyPieces(p, squares)
+				f := 0
+				for table.pairs[stm][f] != d {
+					f++
+				}
+				if idx >= d.groupIdx[d.groupCount()] {
+					t.Fatalf("%s: index %d out of range", p.FEN(side), idx)
+				}
+				key := uint64(stm)<<40 | uint64(f)<<32 | idx
+
+				// The class of a position is its least transformation.
+				class := [4]int{64, 64, 64, int(side)}
+				for s := 0; s < symmetries; s++ {
+					var moved [4]int
+					moved[3] = int(side)
+					for i, sq := range squares {
+						file, rank := sq%8, sq/8
+						if s&1 != 0 {
+							file = 7 - file
+						}
+						if s&2 != 0 {
+							rank = 7 - rank
+						}
+						if s&4 != 0 {
+							file, rank = rank, file
+						}
+						moved[i] = 8*rank + file
+					}
+					if moved[0] < class[0] || moved[0] == class[0] && (moved[1] < class[1] ||
+						moved[1] == class[1] && moved[2] < class[2]) {
+						class = moved
+					}
+				}
+				if other, ok := classes[class]; ok && other != key {
+					t.Fatalf("%s: symmetric positions have indices %x and %x", material, other, key)
+				}
+				classes[class] = key
+				if other, ok := indices[key]; ok && other != class {
+					t.Fatalf("%s: positions %v and %v share index %x", material, other, class, key)
+				}
+				indices[key] = class
+			}
+		}
+	}
+}
+
+// newSyzygyIndex returns a table indexing the positions of a material of three pieces, the stronger side White, with
+// its pieces in the order of syzygyFixturePieces.
+func newSyzygyIndex(material string) *syzygyTable {
+	table := newSyzygyTable(material, true)
+	pieces := syzygyFixturePieces(material)
+	for f := 0; f < table.files(); f++ {
+		for i := 0; i < 2; i++ {
+			d := &syzygyPairs{}
+			for k, piece := range pieces {
+				d.pieces[k] = syzygyPieceCodes[piece.piece] | byte(piece.color)<<3
+			}
+			table.setGroups(d, [2]int{0, 0xf}, f)
+			table.pairs[i][f] = d
+		}
+	}
+	return table
+}
+
+// A truncated table isn't probed, at whatever point it's cut off.
+func TestSyzygyTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(syzygyFixtureDir, "KQvK.rtbw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, side, _ := ParseFEN("8/8/8/5k2/8/8/1Q6/K7 w - - 0 1")
+	for _, n := range []int{5, 16, 100, len(data) / 2, len(data) - 1} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "KQvK.rtbw"), data[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		tb, err := OpenSyzygy(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wdl, ok := tb.ProbeWDL(p, side); ok {
+			t.Errorf("probed %v from %d of %d bytes", wdl, n, len(data))
+		}
+	}
+}
+
+// TestSyzygyConsistent checks random positions of each table against the values of their moves: a position's outcome
+// is the best of its moves', and its distance to zeroing one more than that of the quickest win or slowest loss.
+func TestSyzygyConsistent(t *testing.T) {
+	tb := openSyzygyFixtures(t)
+	rng := rand.New(rand.NewSource(1))
+	for _, material := range syzygyFixtures {
+		pieces := syzygyFixturePieces(material)
+		for n := 0; n < 200; {
+			p := emptyPosition()
+			squares := rng.Perm(64)[:len(pieces)]
+			side := Side(rng.Intn(2))
+			if !placeSyzygyPieces(p, pieces, squares, side) {
+				continue
+			}
+			n++
+			checkSyzygyPosition(t, tb, p, side)
+		}
+	}
+}
+
+// checkSyzygyPosition checks the probes of a position against those of the positions its moves lead to.
+func checkSyzygyPosition(t *testing.T, tb *SyzygyTablebase, p *Position, side Side) {
+	t.Helper()
+	fen := p.FEN(side)
+	wdl, ok := tb.ProbeWDL(p, side)
+	if !ok {
+		t.Fatalf("%s: not covered", fen)
+	}
+	dtz, ok := tb.ProbeDTZ(p, side)
+	if !ok {
+		t.Fatalf("%s: DTZ not covered", fen)
+	}
+
+	// Without moves, the side to move is mated or stalemated.
+	wantWDL, wantDTZ := Draw, 0
+	moves := p.GetMoves(side)
+	if len(moves) == 0 && p.InCheck(side) {
+		wantWDL, wantDTZ = Loss, -1
+	}
+	for i, move := range moves {
+		zeroing := p.board[move.oRank][move.oFile].piece == Pawn || p.board[move.nRank][move.nFile].piece != None
+		undo := p.makeMove(move)
+		childWDL, _ := tb.ProbeWDL(p, side.OppSide())
+		childDTZ, _ := tb.ProbeDTZ(p, side.OppSide())
+		mate := len(p.GetMoves(side.OppSide())) == 0 && p.InCheck(side.OppSide())
+		p.unmakeMove(undo)
+
+		if i == 0 || -childWDL > wantWDL {
+			wantWDL = -childWDL
+		}
+		// The distance to zeroing through this move. A win takes the quickest and a loss the slowest, so both want
+		// the least.
+		moveDTZ := -sign(int(childWDL)) - childDTZ
+		if zeroing || mate {
+			moveDTZ = syzygyDTZBeforeZeroing(-childWDL)
+		}
+		switch {
+		case childWDL == Loss && (wantDTZ <= 0 || moveDTZ < wantDTZ):
+			wantDTZ = moveDTZ
+		case childWDL == Win && moveDTZ < wantDTZ:
+			wantDTZ = moveDTZ
+		}
+	}
+	if wantWDL != Win && wantWDL != Loss {
+		wantDTZ = 0
+	}
+	if wdl != wantWDL {
+		t.Errorf("%s: WDL %v, want %v from its moves", fen, wdl, wantWDL)
+	}
+	if dtz != wantDTZ {
+		t.Errorf("%s: DTZ %v, want %v from its moves", fen, dtz, wantDTZ)
+	}
+}
+
+// syzygyFixturePieces returns the pieces of a material, White's first, in the order the fixtures index them: the
+// pawns, then the kings and pieces.
+func syzygyFixturePieces(material string) []GamePiece {
+	var pieces []GamePiece
+	for i, letters := range strings.Split(material, "v") {
+		for _, letter := range letters {
+			piece := Pawn
+			if letter != 'P' {
+				piece = pieceFromSAN(byte(letter))
+			}
+			pieces = append(pieces, GamePiece{piece, Side(i)})
+		}
+	}
+	sort.SliceStable(pieces, func(i, j int) bool { return pieces[i].piece == Pawn && pieces[j].piece != Pawn })
+	return pieces
+}
+
+// placeSyzygyPieces puts pieces on the squares of an empty board, reporting whether the position is legal with side
+// to move. The board is left empty when it isn't.
+func placeSyzygyPieces(p *Position, pieces []GamePiece, squares []int, side Side) bool {
+	for i, sq := range squares {
+		r := sq / 8
+		if p.board[r][sq%8].piece != None || pieces[i].piece == Pawn && (r == 0 || r == 7) {
+			clearSyzygyPieces(p, squares[:i])
+			return false
+		}
+		p.board[r][sq%8] = pieces[i]
+	}
+	if p.InCheck(side.OppSide()) {
+		clearSyzygyPieces(p, squares)
+		return false
+	}
+	return true
+}
+
+func clearSyzygyPieces(p *Position, squares []int) {
+	for _, sq := range squares {
+		p.board[sq/8][sq%8] = GamePiece{None, White}
+	}
+}
+
+// TestWriteSyzygyFixtures writes the tables in testdata/syzygy-roundtrip, when run with -update-syzygy.
+func TestWriteSyzygyFixtures(t *testing.T) {
+	if !*updateSyzygy {
+		t.Skip("run with -update-syzygy to write the Syzygy fixtures")
+	}
+	retro := NewRetrogradeTablebase()
+	for _, material := range []string{"KQvK", "KRvK", "KPvK"} {
+		if err := retro.Generate(material, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(syzygyFixtureDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, material := range syzygyFixtures {
+		w := newSyzygyWriter(t, material, retro)
+		for _, wdl := range []bool{true, false} {
+			data := w.write(wdl)
+			ext := ".rtbz"
+			if wdl {
+				ext = ".rtbw"
+			}
+			if err := os.WriteFile(filepath.Join(syzygyFixtureDir, material+ext), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("%s%s: %d bytes", material, ext, len(data))
+		}
+	}
+}
+
+// syzygyWriter writes the tables of a material of three pieces, the stronger side White, from the outcomes of a
+// retrograde tablebase and the distances to zeroing it works out from them.
+type syzygyWriter struct {
+	t        *testing.T
+	material string
+	// table indexes positions; its pieces are in the order of syzygyFixturePieces.
+	table *syzygyTable
+	// nodes holds the positions of each side to move, file and index.
+	nodes [2][4][]*syzygyNode
+}
+
+// syzygyNode is a position of a table being written, and one of its moves.
+type syzygyNode struct {
+	wdl WDL
+	dtz int
+	// moves are the positions the moves within the table lead to. zeroing is the distance of the best zeroing move
+	// or mate, from 0 when there's none.
+	moves   []*syzygyNode
+	zeroing int
+	// dtm is the distance to mate the retrograde tables give, to check the distances to zeroing of tables without
+	// pawns against.
+	dtm int
+}
+
+func newSyzygyWriter(t *testing.T, material string, retro *RetrogradeTablebase) *syzygyWriter {
+	w := &syzygyWriter{t: t, material: material, table: newSyzygyIndex(material)}
+	pieces := syzygyFixturePieces(material)
+	for f := 0; f < w.table.files(); f++ {
+		for i := 0; i < 2; i++ {
+			d := w.table.pairs[i][f]
+			w.nodes[i][f] = make([]*syzygyNode, d.groupIdx[d.groupCount()])
+		}
+	}
+
+	// Find every legal position, and the moves of one position of each index.
+	type pending struct {
+		node     *syzygyNode
+		children [][3]int
+	}
+	var pendings []pending
+	p := emptyPosition()
+	squares := make([]int, len(pieces))
+	var place func(i int)
+	place = func(i int) {
+		if i < len(pieces) {
+			for sq := 0; sq < 64; sq++ {
+				squares[i] = sq
+				place(i + 1)
+			}
+			return
+		}
+		for side := White; side <= Black; side++ {
+			if !placeSyzygyPieces(p, pieces, squares, side) {
+				continue
+			}
+			stm, f, idx := w.encode(p, side)
+			wdl, ok := retro.ProbeWDL(p, side)
+			if !ok {
+				t.Fatalf("%s: no retrograde value", p.FEN(side))
+			}
+			if node := w.nodes[stm][f][idx]; node != nil {
+				// Only positions reflecting each other may share an index.
+				if node.wdl != wdl {
+					t.Fatalf("%s: index %d holds both %v and %v", p.FEN(side), idx, node.wdl, wdl)
+				}
+			} else {
+				node := &syzygyNode{wdl: wdl}
+				node.dtm, _ = retro.ProbeDTM(p, side)
+				w.nodes[stm][f][idx] = node
+				pendings = append(pendings, pending{node, w.moves(p, side, node, retro)})
+			}
+			clearSyzygyPieces(p, squares)
+		}
+	}
+	place(0)
+	for _, pending := range pendings {
+		for _, child := range pending.children {
+			node := w.nodes[child[0]][child[1]][child[2]]
+			if node == nil {
+				t.Fatalf("%s: a move leads to a missing position", material)
+			}
+			pending.node.moves = append(pending.node.moves, node)
+		}
+	}
+	w.solveDTZ()
+	return w
+}
+
+// encode returns the side to move, file and index of a position in the tables being written.
+func (w *syzygyWriter) encode(p *Position, side Side) (int, int, int) {
+	d, idx, stm := w.table.encode(p, side, false)
+	for f, pairs := range w.table.pairs[stm] {
+		if pairs == d {
+			if idx >= d.groupIdx[d.groupCount()] {
+				w.t.Fatalf("%s: index %d out of range", p.FEN(side), idx)
+			}
+			return stm, f, int(idx)
+		}
+	}
+	panic("no pairs data")
+}
+
+// moves returns the positions the moves of a position lead to within the table, and sets the distance of its best
+// zeroing move or mate.
+func (w *syzygyWriter) moves(p *Position, side Side, node *syzygyNode, retro *RetrogradeTablebase) [][3]int {
+	var children [][3]int
+	for _, move := range p.GetMoves(side) {
+		zeroing := p.board[move.oRank][move.oFile].piece == Pawn || p.board[move.nRank][move.nFile].piece != None
+		undo := p.makeMove(move)
+		if zeroing || len(p.GetMoves(side.OppSide())) == 0 {
+			wdl, ok := retro.ProbeWDL(p, side.OppSide())
+			if !ok {
+				w.t.Fatalf("%s: no retrograde value", p.FEN(side.OppSide()))
+			}
+			dtz := syzygyDTZBeforeZeroing(-wdl)
+			if dtz != 0 && (node.zeroing == 0 || dtz > node.zeroing) {
+				node.zeroing = dtz
+			}
+		} else {
+			stm, f, idx := w.encode(p, side.OppSide())
+			children = append(children, [3]int{stm, f, idx})
+		}
+		p.unmakeMove(undo)
+	}
+	return children
+}
+
+// solveDTZ works out the distance to zeroing of every won and lost position, a ply at a time: wins which can zero
+// or mate at once are 1, and then each ply the wins with a move to a loss of the last ply, and the losses all of
+// whose moves reach decided wins.
+func (w *syzygyWriter) solveDTZ() {
+	var all []*syzygyNode
+	for stm := range w.nodes {
+		for f := range w.nodes[stm] {
+			for _, node := range w.nodes[stm][f] {
+				if node == nil {
+					continue
+				}
+				all = append(all, node)
+				switch {
+				case node.wdl == Win && node.zeroing == 1:
+					node.dtz = 1
+				case node.wdl == Loss && len(node.moves) == 0:
+					// Mated, or with only zeroing moves to wins.
+					node.dtz = -1
+				}
+			}
+		}
+	}
+	for ply := 2; ; ply++ {
+		var decided []*syzygyNode
+		for _, node := range all {
+			if node.dtz != 0 {
+				continue
+			}
+			switch node.wdl {
+			case Win:
+				for _, child := range node.moves {
+					if child.wdl == Loss && child.dtz == -(ply-1) {
+						decided = append(decided, node)
+						break
+					}
+				}
+			case Loss:
+				longest := 0
+				for _, child := range node.moves {
+					if child.dtz == 0 {
+						longest = -1
+						break
+					}
+					if child.dtz > longest {
+						longest = child.dtz
+					}
+				}
+				if longest == ply-1 {
+					decided = append(decided, node)
+				}
+			}
+		}
+		if len(decided) == 0 {
+			break
+		}
+		for _, node := range decided {
+			node.dtz = ply
+			if node.wdl == Loss {
+				node.dtz = -ply
+			}
+		}
+	}
+
+	for _, node := range all {
+		switch {
+		case node.wdl != Win && node.wdl != Loss:
+		case node.dtz == 0 || node.dtz > 100 || node.dtz < -100:
+			w.t.Fatalf("%s: a %v with distance to zeroing %d", w.material, node.wdl, node.dtz)
+		case !w.table.hasPawns && node.dtm != 0 && node.dtz != node.dtm:
+			// Without pawns, nothing but mate zeroes.
+			w.t.Fatalf("%s: distance to zeroing %d but to mate %d", w.material, node.dtz, node.dtm)
+		}
+	}
+}
+
+// write returns the WDL or DTZ table file.
+func (w *syzygyWriter) write(wdl bool) []byte {
+	files := w.table.files()
+	var packs [2][4]*syzygyPack
+	var maps [4][]byte
+	var header bytes.Buffer
+	header.Write(syzygyDTZMagic)
+	if wdl {
+		header.Reset()
+		header.Write(syzygyWDLMagic)
+	}
+	flags := byte(0)
+	if wdl {
+		flags |= 1
+	}
+	if w.table.hasPawns {
+		flags |= 2
+	}
+	header.WriteByte(flags)
+
+	sides := 1
+	if wdl {
+		sides = 2
+	}
+	for f := 0; f < files; f++ {
+		// Every group is in order, and the sides have the pieces in the same order.
+		header.WriteByte(0)
+		d := w.table.pairs[0][f]
+		for k := 0; k < w.table.pieceCount; k++ {
+			if wdl {
+				header.WriteByte(d.pieces[k] | d.pieces[k]<<4)
+			} else {
+				header.WriteByte(d.pieces[k])
+			}
+		}
+		if wdl {
+			for i := 0; i < 2; i++ {
+				packs[i][f] = packSyzygyValues(w.t, w.wdlValues(i, f), 0)
+			}
+			continue
+		}
+		// A DTZ table holds whichever side to move packs smaller.
+		for i := 0; i < 2; i++ {
+			values, list := w.dtzValues(i, f)
+			pack := packSyzygyValues(w.t, values, byte(i)|syzygyMapped|syzygyWinPlies|syzygyLossPlies)
+			if packs[0][f] == nil || pack.size() < packs[0][f].size() {
+				packs[0][f], maps[f] = pack, list
+			}
+		}
+	}
+
+	data := header.Bytes()
+	align := func(n int) {
+		for len(data)%n != 0 {
+			data = append(data, 0)
+		}
+	}
+	align(2)
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, packs[i][f].sizes...)
+		}
+	}
+	if !wdl {
+		for f := 0; f < files; f++ {
+			if packs[0][f].sizes[0]&syzygyMapped != 0 {
+				data = append(data, maps[f]...)
+			}
+		}
+		align(2)
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, packs[i][f].sparseIndex...)
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, packs[i][f].blockLength...)
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			align(64)
+			data = append(data, packs[i][f].blocks...)
+		}
+	}
+	return data
+}
+
+// wdlValues returns the WDL values of the positions of a side to move and file. Indices without a position take the
+// value before them, which compresses best.
+func (w *syzygyWriter) wdlValues(stm, f int) []int {
+	values := make([]int, len(w.nodes[stm][f]))
+	last := int(Draw) + 2
+	for idx, node := range w.nodes[stm][f] {
+		if node != nil {
+			last = int(node.wdl) + 2
+		}
+		values[idx] = last
+	}
+	return values
+}
+
+// dtzValues returns the DTZ values of the positions of a side to move and file, and the lists of distances of wins
+// and losses the values index.
+func (w *syzygyWriter) dtzValues(stm, f int) ([]int, []byte) {
+	var lists [4][]int
+	for _, node := range w.nodes[stm][f] {
+		if node == nil {
+			continue
+		}
+		switch node.wdl {
+		case Win:
+			lists[0] = append(lists[0], node.dtz-1)
+		case Loss:
+			lists[1] = append(lists[1], -node.dtz-1)
+		}
+	}
+	var mapped []byte
+	for i := range lists {
+		sort.Ints(lists[i])
+		distinct := lists[i][:0]
+		for _, dtz := range lists[i] {
+			if len(distinct) == 0 || distinct[len(distinct)-1] != dtz {
+				distinct = append(distinct, dtz)
+			}
+		}
+		lists[i] = distinct
+		mapped = append(mapped, byte(len(distinct)))
+		for _, dtz := range distinct {
+			mapped = append(mapped, byte(dtz))
+		}
+	}
+
+	values := make([]int, len(w.nodes[stm][f]))
+	last := 0
+	for idx, node := range w.nodes[stm][f] {
+		switch {
+		case node == nil || node.wdl == Draw:
+		case node.wdl == Win:
+			last = sort.SearchInts(lists[0], node.dtz-1)
+		default:
+			last = sort.SearchInts(lists[1], -node.dtz-1)
+		}
+		values[idx] = last
+	}
+	return values, mapped
+}
+
+// syzygyPack is a sequence of values compressed into Syzygy's format: the sizes and symbols, the sparse index, the
+// lengths of the blocks and the blocks.
+type syzygyPack struct {
+	sizes, sparseIndex, blockLength, blocks []byte
+}
+
+func (pack *syzygyPack) size() int {
+	return len(pack.sizes) + len(pack.sparseIndex) + len(pack.blockLength) + len(pack.blocks)
+}
+
+// The block size and span of the fixtures are small, so that even small tables have many of them.
+const (
+	syzygyFixtureBlockBits = 6
+	syzygyFixtureSpanBits  = 8
+)
+
+// packSyzygyValues compresses values by recursive pairing and canonical Huffman codes, with the flags given.
+func packSyzygyValues(t *testing.T, values []int, flags byte) *syzygyPack {
+	single := true
+	for _, value := range values {
+		single = single && value == values[0]
+	}
+	if single {
+		return &syzygyPack{sizes: []byte{flags | syzygySingleValue, byte(values[0])}}
+	}
+
+	// Symbols stand for a value, or for a pair of symbols.
+	type symbol struct {
+		left, right, count int
+	}
+	var symbols []symbol
+	leaves := make(map[int]int)
+	seq := make([]int, len(values))
+	for i, value := range values {
+		sym, ok := leaves[value]
+		if !ok {
+			sym = len(symbols)
+			leaves[value] = sym
+			symbols = append(symbols, symbol{value, 0xfff, 1})
+		}
+		seq[i] = sym
+	}
+
+	// Replace the most frequent pair with a new symbol, while pairs are frequent.
+	for len(symbols) < 256 {
+		counts := make(map[[2]int]int)
+		for i := 0; i+1 < len(seq); i++ {
+			counts[[2]int{seq[i], seq[i+1]}]++
+		}
+		best, bestCount := [2]int{}, 0
+		for pair, count := range counts {
+			if count > bestCount || count == bestCount && (pair[0] < best[0] || pair[0] == best[0] && pair[1] < best[1]) {
+				best, bestCount = pair, count
+			}
+		}
+		if bestCount < 16 || symbols[best[0]].count+symbols[best[1]].count > 64 {
+			break
+		}
+		sym := len(symbols)
+		symbols = append(symbols, symbol{best[0], best[1], symbols[best[0]].count + symbols[best[1]].count})
+		paired := seq[:0]
+		for i := 0; i < len(seq); i++ {
+			if i+1 < len(seq) && seq[i] == best[0] && seq[i+1] == best[1] {
+				paired = append(paired, sym)
+				i++
+			} else {
+				paired = append(paired, seq[i])
+			}
+		}
+		seq = paired
+	}
+
+	// Huffman code lengths of the symbols used, with at least two of them so that codes have a bit.
+	freqs := make(map[int]int)
+	for _, sym := range seq {
+		freqs[sym]++
+	}
+	for sym := 0; len(freqs) < 2; sym++ {
+		if _, ok := freqs[sym]; !ok {
+			freqs[sym] = 0
+		}
+	}
+	lengths := huffmanLengths(freqs)
+
+	// Number the symbols used with the longest codes first, then the rest.
+	used := make([]int, 0, len(lengths))
+	for sym := range lengths {
+		used = append(used, sym)
+	}
+	sort.Slice(used, func(i, j int) bool {
+		if lengths[used[i]] != lengths[used[j]] {
+			return lengths[used[i]] > lengths[used[j]]
+		}
+		return used[i] < used[j]
+	})
+	number := make([]int, len(symbols))
+	for i := range number {
+		number[i] = -1
+	}
+	for i, sym := range used {
+		number[sym] = i
+	}
+	next := len(used)
+	for sym := range symbols {
+		if number[sym] < 0 {
+			number[sym] = next
+			next++
+		}
+	}
+
+	minLen, maxLen := lengths[used[len(used)-1]], lengths[used[0]]
+	if maxLen > 32 {
+		t.Fatalf("Huffman code of %d bits", maxLen)
+	}
+	lowest := make([]int, maxLen-minLen+1)
+	codes := make(map[int]uint64)
+	base := uint64(0)
+	i := 0
+	for length := maxLen; length >= minLen; length-- {
+		lowest[length-minLen] = i
+		start := i
+		for i < len(used) && lengths[used[i]] == length {
+			codes[used[i]] = base + uint64(i-start)
+			i++
+		}
+		if length > minLen {
+			if (base+uint64(i-start))%2 != 0 {
+				t.Fatal("Huffman code isn't complete")
+			}
+			base = (base + uint64(i-start)) / 2
+		}
+	}
+
+	// Fill blocks with whole symbols.
+	blockSize := 1 << syzygyFixtureBlockBits
+	var blocks []byte
+	var blockLengths []int
+	var bits []bool
+	count := 0
+	flush := func() {
+		block := make([]byte, blockSize)
+		for j, bit := range bits {
+			if bit {
+				block[j/8] |= 0x80 >> uint(j%8)
+			}
+		}
+		blocks = append(blocks, block...)
+		blockLengths = append(blockLengths, count-1)
+		bits, count = bits[:0], 0
+	}
+	for _, sym := range seq {
+		length := lengths[sym]
+		if len(bits)+length > 8*blockSize {
+			flush()
+		}
+		for j := length - 1; j >= 0; j-- {
+			bits = append(bits, codes[sym]>>uint(j)&1 != 0)
+		}
+		count += symbols[sym].count
+	}
+	flush()
+
+	pack := &syzygyPack{blocks: blocks}
+	sizes := []byte{flags, syzygyFixtureBlockBits, syzygyFixtureSpanBits, 0, 0, 0, 0, 0, byte(maxLen), byte(minLen)}
+	binary.LittleEndian.PutUint32(sizes[4:], uint32(len(blockLengths)))
+	for _, sym := range lowest {
+		sizes = binary.LittleEndian.AppendUint16(sizes, uint16(sym))
+	}
+	sizes = binary.LittleEndian.AppendUint16(sizes, uint16(len(symbols)))
+	btree := make([]byte, 3*len(symbols))
+	for sym, s := range symbols {
+		left, right := s.left, s.right
+		if right != 0xfff {
+			left, right = number[left], number[right]
+		}
+		n := 3 * number[sym]
+		btree[n], btree[n+1], btree[n+2] = byte(left), byte(left>>8&0xf|right<<4&0xf0), byte(right>>4)
+	}
+	sizes = append(sizes, btree...)
+	if len(symbols)%2 != 0 {
+		sizes = append(sizes, 0)
+	}
+	pack.sizes = sizes
+
+	for _, length := range blockLengths {
+		pack.blockLength = binary.LittleEndian.AppendUint16(pack.blockLength, uint16(length))
+	}
+	span := 1 << syzygyFixtureSpanBits
+	block, start := 0, 0
+	for k := 0; k < (len(values)+span-1)/span; k++ {
+		idx := k*span + span/2
+		for block+1 < len(blockLengths) && idx > start+blockLengths[block] {
+			start += blockLengths[block] + 1
+			block++
+		}
+		pack.sparseIndex = binary.LittleEndian.AppendUint32(pack.sparseIndex, uint32(block))
+		pack.sparseIndex = binary.LittleEndian.AppendUint16(pack.sparseIndex, uint16(idx-start))
+	}
+	return pack
+}
+
+// huffmanLengths returns the lengths of the Huffman codes of symbols with the frequencies given.
+func huffmanLengths(freqs map[int]int) map[int]int {
+	h := &huffmanHeap{}
+	for sym, freq := range freqs {
+		*h = append(*h, &huffmanNode{freq: freq, sym: sym})
+	}
+	heap.Init(h)
+	for h.Len() > 1 {
+		a, b := heap.Pop(h).(*huffmanNode), heap.Pop(h).(*huffmanNode)
+		heap.Push(h, &huffmanNode{freq: a.freq + b.freq, sym: -1, children: [2]*huffmanNode{a, b}})
+	}
+	lengths := make(map[int]int)
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.sym >= 0 {
+			lengths[n.sym] = depth
+			return
+		}
+		walk(n.children[0], depth+1)
+		walk(n.children[1], depth+1)
+	}
+	walk(heap.Pop(h).(*huffmanNode), 0)
+	return lengths
+}
+
+type huffmanNode struct {
+	freq, sym int
+	children  [2]*huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].sym < h[j].sym
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
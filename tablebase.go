@@ -0,0 +1,194 @@
+package main
+
+import "math"
+
+// WDL is the outcome of an endgame with perfect play, from the perspective of the side to move. A cursed win is a win
+// which the fifty-move rule turns into a draw, and a blessed loss a loss it saves.
+type WDL int
+
+// The outcomes of an endgame.
+const (
+	Loss        WDL = -2
+	BlessedLoss WDL = -1
+	Draw        WDL = 0
+	CursedWin   WDL = 1
+	Win         WDL = 2
+)
+
+// Tablebase gives the perfect play outcome of endgames with few pieces.
+type Tablebase interface {
+	// MaxPieces is the most pieces, kings included, of the positions the tablebase may cover.
+	MaxPieces() int
+	// ProbeWDL returns the outcome of the position with side to move, ignoring the fifty-move counter. ok is false
+	// when the tablebase doesn't cover the position.
+	ProbeWDL(p *Position, side Side) (wdl WDL, ok bool)
+	// ProbeDTZ returns the number of plies until the fifty-move counter is reset by a capture or pawn move, or until
+	// mate, with best play. It's positive when the side to move wins and negative when it loses. ok is false when the
+	// tablebase doesn't cover the position or doesn't know its distance to zeroing.
+	ProbeDTZ(p *Position, side Side) (dtz int, ok bool)
+}
+
+// dtmTablebase is a Tablebase which also knows the distance to mate.
+type dtmTablebase interface {
+	// ProbeDTM returns the number of plies to mate with best play, positive when the side to move mates and negative
+	// when it's mated.
+	ProbeDTM(p *Position, side Side) (dtm int, ok bool)
+}
+
+// tablebase is the tablebase the search consults, or nil when there is none.
+var tablebase Tablebase
+
+// UseTablebase has searches consult tb, or no tablebase when tb is nil.
+func UseTablebase(tb Tablebase) {
+	tablebase = tb
+}
+
+// tablebaseWinScore is the score of a position the tablebase says is won, less one per ply from the root. It ranks
+// below any mate the search finds itself, so that the search still mates once it sees how.
+const tablebaseWinScore = mateScore - 4*maxSearchDepth
+
+// pieceCount returns the number of pieces on the board, kings included.
+func (p *Position) pieceCount() int {
+	count := 0
+	for r := range p.board {
+		for f := range p.board[r] {
+			if p.board[r][f].piece != None {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// hasCastlingRights reports whether either side may still castle. Tablebases don't cover such positions.
+func (p *Position) hasCastlingRights() bool {
+	return p.canCastleShortWhite || p.canCastleLongWhite || p.canCastleShortBlack || p.canCastleLongBlack
+}
+
+// tablebaseCovers reports whether the tablebase may cover the position.
+func tablebaseCovers(p *Position) bool {
+	return tablebase != nil && !p.hasCastlingRights() && p.pieceCount() <= tablebase.MaxPieces()
+}
+
+// probeTablebaseScore returns the search score of a position the tablebase covers, found ply plies from the root.
+func probeTablebaseScore(p *Position, side Side, ply int) (float64, bool) {
+	if !tablebaseCovers(p) {
+		return 0, false
+	}
+	wdl, ok := tablebase.ProbeWDL(p, side)
+	if !ok {
+		return 0, false
+	}
+	if wdl == Win || wdl == Loss {
+		// A tablebase knowing the distance to mate gives the mate's exact score.
+		if dtmTB, ok := tablebase.(dtmTablebase); ok {
+			if dtm, ok := dtmTB.ProbeDTM(p, side); ok {
+				if dtm > 0 {
+					return mateScore - float64(ply+dtm), true
+				}
+				return -mateScore + float64(ply-dtm), true
+			}
+		}
+	}
+	switch wdl {
+	case Win:
+		return tablebaseWinScore - float64(ply), true
+	case Loss:
+		return -tablebaseWinScore + float64(ply), true
+	default:
+		// Cursed wins and blessed losses are draws, but slightly better or worse ones.
+		return float64(wdl) / 100, true
+	}
+}
+
+// tablebaseRootMove chooses a move for side by the tablebase, when it covers the position. Winning moves make the
+// quickest progress, losing moves hold out the longest, and a win is only counted as one when the fifty-move rule
+// allows it to be completed, which tablebases without the distance to zeroing can't tell.
+func tablebaseRootMove(p *Position, side Side) (SearchResult, bool) {
+	if !tablebaseCovers(p) {
+		return SearchResult{}, false
+	}
+	moves := p.GetMoves(side)
+	if len(moves) == 0 {
+		return SearchResult{}, false
+	}
+
+	bestRank := math.MinInt32
+	var result SearchResult
+	for _, move := range moves {
+		zeroing := p.board[move.oRank][move.oFile].piece == Pawn || p.board[move.nRank][move.nFile].piece != None
+		undo := p.makeMove(move)
+		rank, score, ok := tablebaseRank(p, side.OppSide(), zeroing, undo.halfMoveClock)
+		p.unmakeMove(undo)
+		if !ok {
+			return SearchResult{}, false
+		}
+		if rank > bestRank {
+			bestRank = rank
+			result = SearchResult{BestMove: move, Score: score, Depth: 1, PV: []Move{move}}
+		}
+	}
+	return result, true
+}
+
+// tablebaseRank ranks the move which led to the position, from the perspective of the side which played it: higher
+// is better. zeroing is whether the move reset the fifty-move counter, which stood at clock before it.
+func tablebaseRank(p *Position, side Side, zeroing bool, clock int) (rank int, score float64, ok bool) {
+	if len(p.GetMoves(side)) == 0 {
+		if p.InCheck(side) {
+			return 1000, mateScore - 1, true
+		}
+		return 0, 0, true
+	}
+	wdl, ok := tablebase.ProbeWDL(p, side)
+	if !ok {
+		return 0, 0, false
+	}
+	wdl = -wdl
+	if wdl != Win && wdl != Loss {
+		return int(wdl), float64(wdl) / 100, true
+	}
+
+	// The distance to zeroing for the side which moved, counting the move itself, when the tablebase knows it.
+	dtz, hasDTZ := 1, true
+	if !zeroing {
+		childDTZ, ok := tablebase.ProbeDTZ(p, side)
+		if childDTZ < 0 {
+			childDTZ = -childDTZ
+		}
+		dtz, hasDTZ = dtz+childDTZ, ok
+	} else {
+		clock = 0
+	}
+
+	// Moves are ranked by the distance to mate when the tablebase knows it, and otherwise by the distance to zeroing.
+	distance := dtz
+	winScore := tablebaseWinScore - 1
+	if dtmTB, ok := tablebase.(dtmTablebase); ok {
+		dtm, ok := dtmTB.ProbeDTM(p, side)
+		if !ok {
+			return 0, 0, false
+		}
+		if dtm < 0 {
+			dtm = -dtm
+		}
+		distance = 1 + dtm
+		winScore = mateScore - float64(distance)
+	} else if !hasDTZ {
+		return 0, 0, false
+	}
+
+	// Only the distance to zeroing tells whether the fifty-move rule allows the win to be completed, so without it
+	// wins and losses are taken at face value.
+	fiftyMoveDraw := hasDTZ && clock+dtz > 100
+	if wdl == Win {
+		if fiftyMoveDraw {
+			return int(CursedWin), float64(CursedWin) / 100, true
+		}
+		return 1000 - distance, winScore, true
+	}
+	if fiftyMoveDraw {
+		return int(BlessedLoss), float64(BlessedLoss) / 100, true
+	}
+	return -1000 + distance, -winScore, true
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Tournament formats.
+const (
+	RoundRobin       = "roundrobin"
+	DoubleRoundRobin = "double"
+	Gauntlet         = "gauntlet"
+)
+
+// TournamentGame is a scheduled game of a tournament. White and Black index the tournament's players.
+type TournamentGame struct {
+	Number      int    `json:"number"`
+	White       int    `json:"white"`
+	Black       int    `json:"black"`
+	Opening     string `json:"opening"`
+	Result      string `json:"result,omitempty"`
+	Termination string `json:"termination,omitempty"`
+}
+
+// Finished reports whether the game has been played to a result.
+func (g TournamentGame) Finished() bool {
+	return g.Result != "" && g.Result != "*"
+}
+
+// Tournament is a tournament between several players. It is saved to disk after each game, so that an interrupted
+// tournament can be resumed.
+type Tournament struct {
+	Format string `json:"format"`
+	Cycles int    `json:"cycles"`
+	// Players are the specs of the players, as accepted by NewPlayer, and Names their names in results.
+	Players []string         `json:"players"`
+	Names   []string         `json:"names"`
+	Games   []TournamentGame `json:"games"`
+}
+
+// NewTournament schedules a tournament. In a round robin each pair of players meets once per cycle, in a double round
+// robin twice with colors reversed, and in a gauntlet the first player meets each other player twice with colors
+// reversed. The games of a pairing share an opening, and pairings take the openings in turn.
+func NewTournament(format string, players []string, cycles int, openings []string) (*Tournament, error) {
+	if len(players) < 2 {
+		return nil, fmt.Errorf("a tournament needs at least two players")
+	}
+	if len(openings) == 0 {
+		openings = []string{StartFEN}
+	}
+	t := &Tournament{Format: format, Cycles: cycles, Players: players}
+	for _, spec := range players {
+		player, name, err := NewPlayer(spec)
+		if err != nil {
+			return nil, err
+		}
+		closePlayer(player)
+		t.Names = append(t.Names, name)
+	}
+	disambiguateNames(t.Names)
+
+	if err := t.scheduleGames(openings); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// scheduleGames schedules the games of the tournament's format and cycles, with the openings given.
+func (t *Tournament) scheduleGames(openings []string) error {
+	var pairings [][2]int
+	switch t.Format {
+	case RoundRobin, DoubleRoundRobin:
+		for i := range t.Players {
+			for j := i + 1; j < len(t.Players); j++ {
+				pairings = append(pairings, [2]int{i, j})
+			}
+		}
+	case Gauntlet:
+		for j := 1; j < len(t.Players); j++ {
+			pairings = append(pairings, [2]int{0, j})
+		}
+	default:
+		return fmt.Errorf("unknown tournament format %q", t.Format)
+	}
+
+	pairing := 0
+	for cycle := 0; cycle < t.Cycles; cycle++ {
+		for _, pair := range pairings {
+			opening := openings[pairing%len(openings)]
+			white, black := pair[0], pair[1]
+			if (white+black+cycle)%2 == 1 {
+				white, black = black, white
+			}
+			t.schedule(white, black, opening)
+			if t.Format != RoundRobin {
+				t.schedule(black, white, opening)
+			}
+			pairing++
+		}
+	}
+	return nil
+}
+
+func (t *Tournament) schedule(white, black int, opening string) {
+	t.Games = append(t.Games, TournamentGame{Number: len(t.Games) + 1, White: white, Black: black, Opening: opening})
+}
+
+// disambiguateNames numbers any names which appear more than once.
+func disambiguateNames(names []string) {
+	counts := make(map[string]int)
+	for _, name := range names {
+		counts[name]++
+	}
+	seen := make(map[string]int)
+	for i, name := range names {
+		if counts[name] > 1 {
+			seen[name]++
+			names[i] = fmt.Sprintf("%s %d", name, seen[name])
+		}
+	}
+}
+
+// LoadTournament reads a tournament saved by Save.
+func LoadTournament(path string) (*Tournament, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Tournament
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &t, nil
+}
+
+// Save writes the tournament to path. The file is replaced atomically, so that an interruption can't corrupt it.
+func (t *Tournament) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Run plays the tournament's unfinished games. finished is called with each game as it finishes, and the PGN of each
+// game is written to pgn.
+func (t *Tournament) Run(ctx context.Context, cfg MatchConfig, pgn io.Writer, finished func(TournamentGame)) error {
+	var pending []int
+	for i, game := range t.Games {
+		if !game.Finished() {
+			pending = append(pending, i)
+		}
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+
+	type result struct {
+		index int
+		game  MatchGame
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan int)
+	results := make(chan result)
+	errs := make(chan error, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker starts its own players as it needs them, since external engines play one game at a time.
+			players := make(map[int]Player)
+			defer func() {
+				for _, player := range players {
+					closePlayer(player)
+				}
+			}()
+			get := func(i int) (Player, error) {
+				if players[i] == nil {
+					player, _, err := NewPlayer(t.Players[i])
+					if err != nil {
+						return nil, err
+					}
+					players[i] = player
+				}
+				return players[i], nil
+			}
+
+			for index := range jobs {
+				game := t.Games[index]
+				white, err := get(game.White)
+				if err == nil {
+					var black Player
+					if black, err = get(game.Black); err == nil {
+						played := playMatchGame(ctx, cfg, game.Number, game.Opening, 0, [2]Player{white, black},
+							[2]string{t.Names[game.White], t.Names[game.Black]})
+						select {
+						case results <- result{index, played}:
+						case <-ctx.Done():
+							return
+						}
+						continue
+					}
+				}
+				errs <- err
+				cancel()
+				return
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, index := range pending {
+			select {
+			case jobs <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		if !r.game.PGN.hasResult() {
+			continue
+		}
+		game := &t.Games[r.index]
+		game.Result, game.Termination = r.game.Result, r.game.Termination
+		if err := WritePGN(pgn, r.game.PGN); err != nil {
+			return err
+		}
+		finished(*game)
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// hasResult reports whether the game was played to a result, rather than abandoned.
+func (g *PGNGame) hasResult() bool {
+	return g.Result != "" && g.Result != "*"
+}
+
+// Standing is a player's place in a tournament's standings.
+type Standing struct {
+	Player int     `json:"player"`
+	Name   string  `json:"name"`
+	Games  int     `json:"games"`
+	Points float64 `json:"points"`
+	Wins   int     `json:"wins"`
+	Draws  int     `json:"draws"`
+	Losses int     `json:"losses"`
+	// SonnebornBerger is the sum of the points of the opponents beaten, plus half those of the opponents drawn.
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Elo             float64 `json:"elo"`
+}
+
+// points returns the points each player scored against each other in the finished games, along with the number of
+// games between them.
+func (t *Tournament) points() (points [][]float64, games [][]int) {
+	n := len(t.Players)
+	points = make([][]float64, n)
+	games = make([][]int, n)
+	for i := range points {
+		points[i] = make([]float64, n)
+		games[i] = make([]int, n)
+	}
+	for _, game := range t.Games {
+		if !game.Finished() {
+			continue
+		}
+		white := map[string]float64{"1-0": 1, "1/2-1/2": .5, "0-1": 0}[game.Result]
+		points[game.White][game.Black] += white
+		points[game.Black][game.White] += 1 - white
+		games[game.White][game.Black]++
+		games[game.Black][game.White]++
+	}
+	return points, games
+}
+
+// Standings returns the players ordered by points, then by Sonneborn-Berger score.
+func (t *Tournament) Standings() []Standing {
+	points, games := t.points()
+	ratings := t.Ratings()
+	standings := make([]Standing, len(t.Players))
+	for i := range standings {
+		standings[i] = Standing{Player: i, Name: t.Names[i], Elo: ratings[i]}
+		for j := range points[i] {
+			standings[i].Games += games[i][j]
+			standings[i].Points += points[i][j]
+		}
+	}
+	for _, game := range t.Games {
+		if !game.Finished() {
+			continue
+		}
+		white, black := &standings[game.White], &standings[game.Black]
+		switch game.Result {
+		case "1-0":
+			white.Wins++
+			black.Losses++
+		case "0-1":
+			white.Losses++
+			black.Wins++
+		default:
+			white.Draws++
+			black.Draws++
+		}
+	}
+	for i := range standings {
+		for j := range points[i] {
+			standings[i].SonnebornBerger += points[i][j] * standings[j].Points
+		}
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Points != standings[j].Points {
+			return standings[i].Points > standings[j].Points
+		}
+		return standings[i].SonnebornBerger > standings[j].SonnebornBerger
+	})
+	return standings
+}
+
+// Ratings estimates each player's Elo from the finished games, averaging zero. It finds the most likely ratings under
+// the logistic Elo model, with a draw counting as half a win. As a prior, each player is given a virtual draw against
+// a player of average rating, which keeps the ratings of players who won or lost every game finite.
+func (t *Tournament) Ratings() []float64 {
+	points, games := t.points()
+	n := len(t.Players)
+	ratings := make([]float64, n)
+	for iteration := 0; iteration < 1000; iteration++ {
+		change := 0.0
+		for i := range ratings {
+			score, expected, derivative := .5, expectedScore(ratings[i]), 0.0
+			derivative += expected * (1 - expected)
+			for j := range ratings {
+				if games[i][j] == 0 {
+					continue
+				}
+				e := expectedScore(ratings[i] - ratings[j])
+				score += points[i][j]
+				expected += float64(games[i][j]) * e
+				derivative += float64(games[i][j]) * e * (1 - e)
+			}
+			// Take a Newton step, converting the derivative of the expected score to per-Elo.
+			step := (score - expected) / (derivative * math.Ln10 / 400)
+			ratings[i] += step
+			change = math.Max(change, math.Abs(step))
+		}
+
+		mean := 0.0
+		for _, rating := range ratings {
+			mean += rating
+		}
+		mean /= float64(n)
+		for i := range ratings {
+			ratings[i] -= mean
+		}
+		if change < 1e-6 {
+			break
+		}
+	}
+	return ratings
+}
+
+// WriteStandings writes the standings and crosstable as text.
+func (t *Tournament) WriteStandings(w io.Writer) {
+	standings := t.Standings()
+	points, games := t.points()
+	played := 0
+	for _, game := range t.Games {
+		if game.Finished() {
+			played++
+		}
+	}
+	fmt.Fprintf(w, "%s tournament, %d of %d games played\n\n", t.Format, played, len(t.Games))
+
+	width := 4
+	for _, name := range t.Names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	fmt.Fprintf(w, "%3s %-*s %6s %5s %7s %6s %7s %7s ", "#", width, "Name", "Games", "Pts", "W-D-L", "%", "SB", "Elo")
+	for i := range standings {
+		fmt.Fprintf(w, "%5d", i+1)
+	}
+	fmt.Fprintln(w)
+	for i, s := range standings {
+		percent := 0.0
+		if s.Games > 0 {
+			percent = s.Points / float64(s.Games) * 100
+		}
+		fmt.Fprintf(w, "%3d %-*s %6d %5.1f %7s %5.1f%% %7.2f %7.1f ", i+1, width, s.Name, s.Games, s.Points,
+			fmt.Sprintf("%d-%d-%d", s.Wins, s.Draws, s.Losses), percent, s.SonnebornBerger, s.Elo)
+		for _, opp := range standings {
+			switch {
+			case opp.Player == s.Player:
+				fmt.Fprintf(w, "%5s", "X")
+			case games[s.Player][opp.Player] == 0:
+				fmt.Fprintf(w, "%5s", ".")
+			default:
+				fmt.Fprintf(w, "%5s", strings.TrimSuffix(fmt.Sprintf("%.1f", points[s.Player][opp.Player]), ".0"))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// tournamentJSON is the JSON form of a tournament's standings.
+type tournamentJSON struct {
+	Format    string     `json:"format"`
+	Played    int        `json:"played"`
+	Scheduled int        `json:"scheduled"`
+	Standings []Standing `json:"standings"`
+	// Crosstable gives the points each player, in the order of Players, scored against each other, or null when they
+	// haven't met.
+	Players    []string     `json:"players"`
+	Crosstable [][]*float64 `json:"crosstable"`
+}
+
+// WriteStandingsJSON writes the standings and crosstable as JSON.
+func (t *Tournament) WriteStandingsJSON(w io.Writer) error {
+	points, games := t.points()
+	out := tournamentJSON{Format: t.Format, Scheduled: len(t.Games), Standings: t.Standings(), Players: t.Names}
+	for _, game := range t.Games {
+		if game.Finished() {
+			out.Played++
+		}
+	}
+	for i := range points {
+		row := make([]*float64, len(points[i]))
+		for j := range points[i] {
+			if games[i][j] > 0 {
+				row[j] = &points[i][j]
+			}
+		}
+		out.Crosstable = append(out.Crosstable, row)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// stringList is a flag which may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// checkFlags returns an error describing the tournament when the flags given to resume it schedule another one. Flags
+// left out keep the tournament's own.
+func (t *Tournament) checkFlags(fs *flag.FlagSet, openings []string) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch {
+		case err != nil:
+		case f.Name == "format" && f.Value.String() != t.Format:
+			err = fmt.Errorf("a %s tournament", t.Format)
+		case f.Name == "cycles" && f.Value.String() != strconv.Itoa(t.Cycles):
+			err = fmt.Errorf("a tournament of %d cycles", t.Cycles)
+		case f.Name == "openings":
+			scheduled := &Tournament{Format: t.Format, Cycles: t.Cycles, Players: t.Players}
+			if len(openings) == 0 || scheduled.scheduleGames(openings) != nil || len(scheduled.Games) != len(t.Games) {
+				err = fmt.Errorf("a tournament from other openings")
+				return
+			}
+			for i, game := range scheduled.Games {
+				if game.Opening != t.Games[i].Opening {
+					err = fmt.Errorf("a tournament from other openings")
+					return
+				}
+			}
+		}
+	})
+	return err
+}
+
+// TournamentCommand runs the tournament command. A tournament is saved to its state file as it is played, and running
+// the command again with the same state file resumes it, as long as the players, format, cycles and openings given
+// agree with it.
+func TournamentCommand(args []string) error {
+	fs := flag.NewFlagSet("tournament", flag.ExitOnError)
+	var players stringList
+	fs.Var(&players, "player", "a player: robchess[:option=value,...] or uci:<command> (repeat for each player)")
+	format := fs.String("format", RoundRobin, "tournament format: roundrobin, double or gauntlet (first player against the rest)")
+	cycles := fs.Int("cycles", 1, "number of times to play the schedule")
+	statePath := fs.String("state", "tournament.json", "`file` the tournament is saved to and resumed from")
+	pgnPath := fs.String("pgn", "tournament.pgn", "`file` to append the games to")
+	jsonPath := fs.String("json", "", "`file` to write the standings to as JSON")
+	config := matchFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := config()
+	if err != nil {
+		return err
+	}
+
+	t, err := LoadTournament(*statePath)
+	switch {
+	case err == nil:
+		if len(players) > 0 && strings.Join(players, "\n") != strings.Join(t.Players, "\n") {
+			return fmt.Errorf("%s holds a tournament between other players", *statePath)
+		}
+		if err := t.checkFlags(fs, cfg.Openings); err != nil {
+			return fmt.Errorf("%s holds %v", *statePath, err)
+		}
+		fmt.Printf("Resuming tournament from %s\n", *statePath)
+	case os.IsNotExist(err):
+		if t, err = NewTournament(*format, players, *cycles, cfg.Openings); err != nil {
+			return err
+		}
+		if err := t.Save(*statePath); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	pgn, err := os.OpenFile(*pgnPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer pgn.Close()
+
+	err = t.Run(context.Background(), cfg, pgn, func(game TournamentGame) {
+		fmt.Printf("Game %d: %s - %s %s (%s)\n", game.Number, t.Names[game.White], t.Names[game.Black], game.Result,
+			game.Termination)
+		if err := t.Save(*statePath); err != nil {
+			fmt.Fprintf(os.Stderr, "saving %s: %v\n", *statePath, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	t.WriteStandings(os.Stdout)
+	if *jsonPath != "" {
+		f, err := os.Create(*jsonPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := t.WriteStandingsJSON(f); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return pgn.Close()
+}
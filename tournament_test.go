@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Resuming a tournament with flags which schedule another one is an error, rather than resuming the old schedule.
+func TestTournamentResumeFlags(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "tournament.json")
+	openings := filepath.Join(dir, "openings.epd")
+	others := filepath.Join(dir, "others.epd")
+	fens := []string{StartFEN, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"}
+	if err := os.WriteFile(openings, []byte(strings.Join(fens, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(others, []byte(fens[1]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	players := []string{"robchess", "robchess:depth=1", "robchess:depth=2"}
+	tournament, err := NewTournament(DoubleRoundRobin, players, 2, fens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With every game played, resuming only writes the standings.
+	for i := range tournament.Games {
+		tournament.Games[i].Result = "1/2-1/2"
+	}
+	if err := tournament.Save(state); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-state", state, "-pgn", filepath.Join(dir, "tournament.pgn")}
+	tests := []struct {
+		flags []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"-format", DoubleRoundRobin, "-cycles", "2", "-openings", openings}, true},
+		{[]string{"-player", "robchess", "-player", "robchess:depth=1", "-player", "robchess:depth=2"}, true},
+		{[]string{"-format", RoundRobin}, false},
+		{[]string{"-cycles", "1"}, false},
+		{[]string{"-openings", others}, false},
+		{[]string{"-player", "robchess", "-player", "robchess:depth=1"}, false},
+	}
+	for _, test := range tests {
+		err := TournamentCommand(append(append([]string{}, args...), test.flags...))
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%v: error %v, want an error %v", test.flags, err, !test.ok)
+		}
+	}
+}
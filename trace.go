@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// EvalTrace is a position's evaluation broken down by its terms, for working out why the engine judges a position as
+// it does.
+type EvalTrace struct {
+	FEN   string      `json:"fen"`
+	Terms []TermTrace `json:"terms"`
+	// Total is the sum of the terms, from White's perspective.
+	Total Score `json:"total"`
+	// Phase runs from 0 in a bare endgame to maxPhase with all pieces on the board, and the total is tapered by it.
+	Phase int `json:"phase"`
+	// Scale, out of normalScale, is how much of the tapered total counts in an ending which is harder to win than
+	// its material suggests.
+	Scale int `json:"scale"`
+	// Endgame is the material of an ending whose own evaluation replaces the terms.
+	Endgame string `json:"endgame,omitempty"`
+	// Score is the evaluation in centipawns, from White's perspective.
+	Score int `json:"score"`
+	// Network is the evaluation of the network the engine was started with, if any, in centipawns from White's
+	// perspective.
+	Network *int `json:"network,omitempty"`
+}
+
+// TermTrace is one term of an evaluation for each side, each from its own perspective.
+type TermTrace struct {
+	Name  string `json:"name"`
+	White Score  `json:"white"`
+	Black Score  `json:"black"`
+}
+
+// EvaluateTrace evaluates the position term by term. side is the side to move.
+func EvaluateTrace(p *Position, side Side) *EvalTrace {
+	terms, phase := evaluateTerms(evalParams, nil, p)
+	trace := &EvalTrace{
+		FEN:   p.FEN(side),
+		Phase: phase,
+		Scale: endgameScale(p),
+		Score: whitePerspective(EvaluateCentipawns(p, side), side),
+	}
+	for term, scores := range terms {
+		trace.Terms = append(trace.Terms, TermTrace{termNames[term], scores[White], scores[Black]})
+		trace.Total = trace.Total.Add(scores[White]).Sub(scores[Black])
+	}
+	if _, ok := evaluateEndgame(p, side); ok {
+		trace.Endgame, _ = syzygyMaterial(p)
+	}
+	if evalNetwork != nil {
+		score := whitePerspective(EvaluateNetwork(evalNetwork, p, side), side)
+		trace.Network = &score
+	}
+	return trace
+}
+
+// whitePerspective turns a score for side into one from White's perspective. The evaluation of some endings depends on
+// the side to move, so positions are evaluated for it rather than for White.
+func whitePerspective(score int, side Side) int {
+	if side == Black {
+		return -score
+	}
+	return score
+}
+
+// WriteTable writes the trace as a table of the terms, with the middlegame and endgame scores of each side and the
+// difference between them.
+func (t *EvalTrace) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Term\tWhite MG\tWhite EG\tBlack MG\tBlack EG\tTotal MG\tTotal EG\t\n")
+	for _, term := range t.Terms {
+		total := term.White.Sub(term.Black)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t\n", term.Name, term.White.MG, term.White.EG, term.Black.MG,
+			term.Black.EG, total.MG, total.EG)
+	}
+	fmt.Fprintf(tw, "Total\t\t\t\t\t%d\t%d\t\n", t.Total.MG, t.Total.EG)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Phase %d/%d, tapered %d", t.Phase, maxPhase, t.Total.Taper(t.Phase))
+	if t.Scale != normalScale {
+		fmt.Fprintf(w, ", scaled by %d/%d", t.Scale, normalScale)
+	}
+	fmt.Fprintln(w)
+	if t.Endgame != "" {
+		fmt.Fprintf(w, "%s is evaluated as a specific ending instead\n", t.Endgame)
+	}
+	if t.Network != nil {
+		fmt.Fprintf(w, "Network evaluation: %+.2f (White)\n", float64(*t.Network)/100)
+	}
+	_, err := fmt.Fprintf(w, "Evaluation: %+.2f (White)\n", float64(t.Score)/100)
+	return err
+}
+
+// EvalCommand runs the eval command, which prints the breakdown of the evaluation of each position given as a FEN
+// argument, or of the starting position.
+func EvalCommand(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the breakdowns as JSON instead of tables")
+	fs.Parse(args)
+	fens := fs.Args()
+	if len(fens) == 0 {
+		fens = []string{StartFEN}
+	}
+
+	var traces []*EvalTrace
+	for _, fen := range fens {
+		p, side, err := ParseFEN(fen)
+		if err != nil {
+			return err
+		}
+		traces = append(traces, EvaluateTrace(p, side))
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(traces, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("%s\n", data)
+		return err
+	}
+	for i, trace := range traces {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(trace.FEN)
+		if err := trace.WriteTable(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
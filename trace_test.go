@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+// The trace evaluates the position with its side to move, as the engine does, whose evaluations of some endings depend
+// on the move.
+func TestEvaluateTrace(t *testing.T) {
+	tests := []string{
+		"8/8/8/4P3/k7/8/8/K7 b - - 0 1",
+		"8/8/8/4P3/k7/8/8/K7 w - - 0 1",
+		"8/8/8/8/8/8/1Q6/K6k b - - 0 1",
+		StartFEN,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+	}
+	for _, fen := range tests {
+		p, side, err := ParseFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := EvaluateCentipawns(p, side)
+		if side == Black {
+			want = -want
+		}
+		if trace := EvaluateTrace(p, side); trace.Score != want {
+			t.Errorf("%s: traced %d, want %d", fen, trace.Score, want)
+		}
+	}
+
+	// With Black to move, the king catches the pawn.
+	p, side, _ := ParseFEN("8/8/8/4P3/k7/8/8/K7 b - - 0 1")
+	if trace := EvaluateTrace(p, side); trace.Score != 0 || trace.Endgame != "KPvK" {
+		t.Errorf("KPvK with Black to move: traced %d as %q, want 0 as KPvK", trace.Score, trace.Endgame)
+	}
+}
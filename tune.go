@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tunePosition is a position of a tuning dataset, stored compactly as the piece on each square, with the result of
+// the game it comes from for White: 1 for a win, 0.5 for a draw and 0 for a loss.
+type tunePosition struct {
+	board  [64]int8
+	result float64
+}
+
+// noPiece marks an empty square of a tunePosition.
+const noPiece = -1
+
+func newTunePosition(p *Position, result float64) tunePosition {
+	t := tunePosition{result: result}
+	for sq := range t.board {
+		piece := p.board[sq/8][sq%8]
+		t.board[sq] = noPiece
+		if piece.piece != None {
+			t.board[sq] = int8(int(piece.color)*int(None) + int(piece.piece))
+		}
+	}
+	return t
+}
+
+// setUp sets the position's board to the tunePosition's.
+func (t *tunePosition) setUp(p *Position) {
+	for sq, piece := range t.board {
+		if piece == noPiece {
+			p.board[sq/8][sq%8] = GamePiece{None, White}
+		} else {
+			p.board[sq/8][sq%8] = GamePiece{Piece(int(piece) % int(None)), Side(int(piece) / int(None))}
+		}
+	}
+}
+
+// parseResult parses a game result, as in PGN or as White's score, e.g. "1/2-1/2" or "0.5".
+func parseResult(s string) (float64, bool) {
+	switch s {
+	case "1-0":
+		return 1, true
+	case "0-1":
+		return 0, true
+	case "1/2-1/2":
+		return 0.5, true
+	}
+	result, err := strconv.ParseFloat(s, 64)
+	return result, err == nil && (result == 0 || result == 0.5 || result == 1)
+}
+
+// LoadTuneData loads the positions of a tuning dataset. A file whose name ends in .pgn holds games, from which the
+// quiet positions are taken, skipping the first skip plies of each game. Other files hold a position on each line,
+// as a FEN followed by the result in brackets, e.g. "[0.5]", or as EPD with the result in its c9 operation. A file
+// whose name ends in .bin holds the records written by the datagen command.
+func LoadTuneData(path string, skip int) ([]tunePosition, error) {
+	if strings.HasSuffix(path, ".bin") {
+		var positions []tunePosition
+		err := ReadDataRecords(path, func(d DataRecord) error {
+			positions = append(positions, newTunePosition(d.Position, d.Result))
+			return nil
+		})
+		return positions, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".pgn") {
+		return loadTuneGames(f, skip)
+	}
+
+	var positions []tunePosition
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var fen, result string
+		if i := strings.LastIndex(text, "["); i >= 0 && strings.HasSuffix(text, "]") {
+			fen, result = text[:i], text[i+1:len(text)-1]
+		} else {
+			e, err := ParseEPD(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			fen, result = e.FEN, e.operand("c9")
+		}
+		score, ok := parseResult(strings.TrimSpace(result))
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: no result", path, line)
+		}
+		p, _, err := ParseFEN(fen)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		positions = append(positions, newTunePosition(p, score))
+	}
+	return positions, scanner.Err()
+}
+
+// loadTuneGames takes the quiet positions of games: those where the side to move isn't in check, the last move
+// wasn't a capture, and the move played is neither a capture nor a promotion.
+func loadTuneGames(r io.Reader, skip int) ([]tunePosition, error) {
+	var positions []tunePosition
+	reader := NewPGNReader(bufio.NewReader(r))
+	for {
+		game, err := reader.Read()
+		if err == io.EOF {
+			return positions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result, ok := parseResult(game.Result)
+		if !ok {
+			continue
+		}
+		p, side, err := game.StartPosition()
+		if err != nil {
+			return nil, err
+		}
+		captured := false
+		for ply, move := range game.Moves {
+			quiet := ply >= skip && !captured && move.promoPiece == "" && !p.InCheck(side)
+			undo := p.makeMove(move)
+			captured = undo.captured.piece != None
+			if quiet && !captured {
+				p.unmakeMove(undo)
+				positions = append(positions, newTunePosition(p, result))
+				p.makeMove(move)
+			}
+			side = side.OppSide()
+		}
+	}
+}
+
+// tuner fits evaluation weights to the results of the games a dataset's positions come from, by minimizing the mean
+// squared difference between each result and the sigmoid of the evaluation.
+type tuner struct {
+	positions []tunePosition
+	params    *EvalParams
+	weights   []EvalWeight
+	threads   int
+	k         float64
+	log       io.Writer
+	out       string
+}
+
+// sigmoid maps an evaluation in centipawns to an expected score for White.
+func sigmoid(k float64, eval int) float64 {
+	return 1 / (1 + math.Pow(10, -k*float64(eval)/400))
+}
+
+// evaluate evaluates positions from White's perspective with the tuner's params, across its threads.
+func (t *tuner) evaluate(positions []tunePosition, evals []int) {
+	var wg sync.WaitGroup
+	for i := 0; i < t.threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// The params change between calls, so each call caches pawn structures afresh.
+			pawns := new(pawnTable)
+			p := emptyPosition()
+			for j := i; j < len(positions); j += t.threads {
+				positions[j].setUp(p)
+				evals[j] = evaluateCentipawns(t.params, pawns, p, White)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// meanSquaredError returns the error of the evaluations of positions, with the sigmoid scaled by k.
+func meanSquaredError(positions []tunePosition, evals []int, k float64) float64 {
+	sum := 0.0
+	for i := range positions {
+		d := positions[i].result - sigmoid(k, evals[i])
+		sum += d * d
+	}
+	return sum / float64(len(positions))
+}
+
+// error returns the error of the tuner's params over positions.
+func (t *tuner) error(positions []tunePosition) float64 {
+	evals := make([]int, len(positions))
+	t.evaluate(positions, evals)
+	return meanSquaredError(positions, evals, t.k)
+}
+
+// optimizeK finds the scaling of the sigmoid which best fits the current evaluation to the results, refining it a
+// digit at a time.
+func (t *tuner) optimizeK() {
+	evals := make([]int, len(t.positions))
+	t.evaluate(t.positions, evals)
+	k := 1.0
+	best := meanSquaredError(t.positions, evals, k)
+	for step := 0.1; step >= 0.0001; step /= 10 {
+		for _, dir := range []float64{step, -step} {
+			for k+dir > 0 {
+				e := meanSquaredError(t.positions, evals, k+dir)
+				if e >= best {
+					break
+				}
+				k, best = k+dir, e
+			}
+		}
+	}
+	t.k = k
+	t.logf("K = %.4f, error %.8f", k, best)
+}
+
+func (t *tuner) logf(format string, args ...interface{}) {
+	fmt.Fprintf(t.log, time.Now().Format("15:04:05 ")+format+"\n", args...)
+}
+
+// save writes the params to the tuner's output file.
+func (t *tuner) save() error {
+	return t.params.Save(t.out)
+}
+
+// localSearch tunes by the Texel method: each weight in turn is moved a step up or down while that lowers the
+// error, until a pass over the weights lowers it no further.
+func (t *tuner) localSearch(iterations int) error {
+	best := t.error(t.positions)
+	t.logf("iteration 0: error %.8f", best)
+	for iteration := 1; iterations == 0 || iteration <= iterations; iteration++ {
+		improved := 0
+		for _, weight := range t.weights {
+			for _, step := range []int{1, -1} {
+				*weight.Value += step
+				if t.params.validate() == nil {
+					if e := t.error(t.positions); e < best {
+						best = e
+						improved++
+						break
+					}
+				}
+				*weight.Value -= step
+			}
+		}
+		t.logf("iteration %d: error %.8f, %d weights changed", iteration, best, improved)
+		if err := t.save(); err != nil {
+			return err
+		}
+		if improved == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// Adam's hyperparameters.
+const (
+	adamBeta1   = 0.9
+	adamBeta2   = 0.999
+	adamEpsilon = 1e-8
+)
+
+// adam tunes by gradient descent with Adam. The evaluation isn't differentiable in its integer weights, so the
+// gradient of each minibatch is estimated by simultaneous perturbation: the error is measured with every weight moved
+// a step up or down at random, and again with every weight moved the other way.
+func (t *tuner) adam(iterations, batch int, rate float64, seed int64) error {
+	rng := rand.New(rand.NewSource(seed))
+	n := len(t.weights)
+	theta := make([]float64, n)
+	for i, weight := range t.weights {
+		theta[i] = float64(*weight.Value)
+	}
+	m, v := make([]float64, n), make([]float64, n)
+	delta := make([]float64, n)
+	batch = minInt(batch, len(t.positions))
+	sample := make([]tunePosition, batch)
+
+	// apply sets the weights to theta, moved by scale times delta, and reports whether they're valid.
+	apply := func(scale float64) bool {
+		for i, weight := range t.weights {
+			*weight.Value = int(math.Round(theta[i] + scale*delta[i]))
+		}
+		return t.params.validate() == nil
+	}
+
+	t.logf("iteration 0: error %.8f", t.error(t.positions))
+	logEvery := maxInt(iterations/100, 1)
+	for iteration := 1; iteration <= iterations; iteration++ {
+		for i := range sample {
+			sample[i] = t.positions[rng.Intn(len(t.positions))]
+		}
+		for i := range delta {
+			delta[i] = float64(2*rng.Intn(2) - 1)
+		}
+		if !apply(1) {
+			continue
+		}
+		plus := t.error(sample)
+		if !apply(-1) {
+			continue
+		}
+		minus := t.error(sample)
+
+		for i := range theta {
+			g := (plus - minus) / (2 * delta[i])
+			m[i] = adamBeta1*m[i] + (1-adamBeta1)*g
+			v[i] = adamBeta2*v[i] + (1-adamBeta2)*g*g
+			mHat := m[i] / (1 - math.Pow(adamBeta1, float64(iteration)))
+			vHat := v[i] / (1 - math.Pow(adamBeta2, float64(iteration)))
+			theta[i] -= rate * mHat / (math.Sqrt(vHat) + adamEpsilon)
+		}
+
+		if iteration%logEvery == 0 || iteration == iterations {
+			if !apply(0) {
+				// Keep the last valid weights rather than stepping into invalid ones.
+				for i, weight := range t.weights {
+					theta[i] = float64(*weight.Value)
+				}
+			}
+			t.logf("iteration %d: error %.8f", iteration, t.error(t.positions))
+			if err := t.save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectWeights returns the weights of params whose names begin with one of the comma-separated prefixes, or all of
+// them but KingDangerDivisor when there are none: it only rescales the attack weights, so tuning it alongside them
+// gains nothing.
+func selectWeights(params *EvalParams, prefixes string) ([]EvalWeight, error) {
+	var selected []EvalWeight
+	for _, weight := range params.Weights() {
+		if prefixes == "" {
+			if weight.Name != "KingDangerDivisor" {
+				selected = append(selected, weight)
+			}
+			continue
+		}
+		for _, prefix := range strings.Split(prefixes, ",") {
+			if weight.Name == prefix || strings.HasPrefix(weight.Name, prefix+".") {
+				selected = append(selected, weight)
+				break
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no evaluation weights match %q", prefixes)
+	}
+	return selected, nil
+}
+
+// TuneCommand runs the tune command, which tunes the evaluation's weights to the results of a dataset's positions.
+func TuneCommand(args []string) error {
+	fs := flag.NewFlagSet("tune", flag.ExitOnError)
+	out := fs.String("out", "tuned.json", "`file` to write the tuned weights to after each iteration")
+	logPath := fs.String("log", "", "`file` to append the progress to as well as printing it")
+	method := fs.String("method", "local", "tuning method: local for Texel's local search or adam for gradient descent")
+	weights := fs.String("weights", "", "comma-separated names of the weights to tune, e.g. PassedPawn,DoubledPawn.eg (default all)")
+	iterations := fs.Int("iterations", 0, "number of iterations (default until local search converges, 1000 for adam)")
+	batch := fs.Int("batch", 16384, "positions in each minibatch of adam")
+	rate := fs.Float64("rate", 0.5, "learning rate of adam, in units of a weight")
+	k := fs.Float64("k", 0, "scaling of the sigmoid (default fitted to the dataset)")
+	skip := fs.Int("skip", 8, "plies at the start of each PGN game to take no positions from")
+	threads := fs.Int("threads", runtime.NumCPU(), "number of goroutines evaluating positions")
+	seed := fs.Int64("seed", 1, "seed of adam's minibatches and perturbations")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("tune: no datasets given")
+	}
+
+	t := &tuner{params: evalParams, threads: maxInt(*threads, 1), k: *k, log: os.Stdout, out: *out}
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		t.log = io.MultiWriter(os.Stdout, f)
+	}
+	var err error
+	if t.weights, err = selectWeights(t.params, *weights); err != nil {
+		return err
+	}
+
+	p := emptyPosition()
+	for _, path := range fs.Args() {
+		positions, err := LoadTuneData(path, *skip)
+		if err != nil {
+			return err
+		}
+		// Endings with an evaluation of their own don't depend on the weights.
+		for _, position := range positions {
+			position.setUp(p)
+			if _, ok := evaluateEndgame(p, White); !ok {
+				t.positions = append(t.positions, position)
+			}
+		}
+	}
+	if len(t.positions) == 0 {
+		return fmt.Errorf("tune: the datasets hold no positions to tune with")
+	}
+	t.logf("tuning %d weights with %d positions", len(t.weights), len(t.positions))
+
+	if t.k == 0 {
+		t.optimizeK()
+	}
+	start := time.Now()
+	switch *method {
+	case "local":
+		err = t.localSearch(*iterations)
+	case "adam":
+		if *iterations == 0 {
+			*iterations = 1000
+		}
+		err = t.adam(*iterations, *batch, *rate, *seed)
+	default:
+		return fmt.Errorf("tune: unknown method %q", *method)
+	}
+	if err != nil {
+		return err
+	}
+	t.logf("done in %s, weights written to %s", time.Since(start).Round(time.Second), t.out)
+	return nil
+}